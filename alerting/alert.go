@@ -0,0 +1,32 @@
+package alerting
+
+import "time"
+
+type Status string
+
+const (
+	Firing   Status = "firing"
+	Resolved Status = "resolved"
+)
+
+// Alert is a change of the state of an alert rule for an app. An alert is sent once when the
+// rule starts firing and once when it is resolved, never for every statistic in between.
+type Alert struct {
+	Status     Status     `json:"status"`
+	Rule       string     `json:"rule"`
+	AppId      string     `json:"app_id"`
+	Metric     string     `json:"metric"`
+	Value      float64    `json:"value"`
+	Threshold  float64    `json:"threshold"`
+	StartedAt  time.Time  `json:"started_at"`
+	ResolvedAt *time.Time `json:"resolved_at,omitempty"`
+}
+
+// Notifier delivers the alerts. Notify is called from the goroutine evaluating the rules, so it
+// must not block on the network.
+type Notifier interface {
+	Notify(alert Alert)
+
+	// Close delivers the alerts still queued and releases the notifier.
+	Close()
+}
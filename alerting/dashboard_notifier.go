@@ -0,0 +1,43 @@
+package alerting
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/iamsayantan/larasockets"
+	"github.com/iamsayantan/larasockets/messages"
+)
+
+// NewDashboardNotifier returns a notifier that broadcasts the alerts to the dashboard of the app,
+// as alert events on the private-app-{id}-alerts channel.
+func NewDashboardNotifier(cm larasockets.ChannelManager) Notifier {
+	return &dashboardNotifier{channelManager: cm}
+}
+
+type dashboardNotifier struct {
+	channelManager larasockets.ChannelManager
+}
+
+func (d *dashboardNotifier) Notify(alert Alert) {
+	channelName := fmt.Sprintf("private-app-%s-alerts", alert.AppId)
+	// dashboards that are not open do not need the alert, the channel is not created for it.
+	channel := d.channelManager.FindChannel(alert.AppId, channelName)
+	if channel == nil {
+		return
+	}
+
+	payloadData, err := json.Marshal(alert)
+	if err != nil {
+		return
+	}
+
+	msg := messages.PusherEventPayload{
+		Event:   "alert",
+		Channel: channelName,
+		Data:    string(payloadData),
+	}
+
+	channel.Broadcast(context.Background(), msg)
+}
+
+func (d *dashboardNotifier) Close() {}
@@ -0,0 +1,211 @@
+package alerting
+
+import (
+	"github.com/iamsayantan/larasockets"
+	"github.com/iamsayantan/larasockets/config"
+	"github.com/iamsayantan/larasockets/statistics"
+	"sync"
+	"time"
+)
+
+// Manager evaluates the alert rules on every statistic collected for an app. A rule fires once the
+// metric stayed above the threshold for the duration of the rule and resolves once the metric is
+// back at or below it. Apps without any activity do not get statistics, their firing alerts are
+// resolved when no statistic arrived for two dump intervals.
+type Manager struct {
+	rules        []config.AlertRuleConfig
+	cm           larasockets.ChannelManager
+	notifiers    []Notifier
+	dumpInterval time.Duration
+
+	mu sync.Mutex
+	// states holds the state of a rule per app, keyed by rule name and app id. There is only a
+	// state while the metric is above the threshold.
+	states map[stateKey]*ruleState
+
+	stopOnce  sync.Once
+	stopCh    chan struct{}
+	stoppedCh chan struct{}
+}
+
+type stateKey struct {
+	rule  string
+	appId string
+}
+
+type ruleState struct {
+	// since is when the metric went above the threshold.
+	since time.Time
+	// lastSeen is the time of the last statistic of the app.
+	lastSeen time.Time
+	value    float64
+	firing   bool
+}
+
+// NewManager returns a manager that sends the alerts of the rules to the notifiers, dumpInterval
+// is the interval the statistics are collected at.
+func NewManager(rules []config.AlertRuleConfig, cm larasockets.ChannelManager, dumpInterval time.Duration, notifiers ...Notifier) *Manager {
+	manager := &Manager{
+		rules:        rules,
+		cm:           cm,
+		notifiers:    notifiers,
+		dumpInterval: dumpInterval,
+		states:       make(map[stateKey]*ruleState),
+		stopCh:       make(chan struct{}),
+		stoppedCh:    make(chan struct{}),
+	}
+
+	go manager.resolveInactive()
+
+	return manager
+}
+
+func (m *Manager) ListenStatChanged(event statistics.StatEvent) {
+	collected, ok := event.(statistics.StatisticsCollected)
+	if !ok {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, rule := range m.rules {
+		if !appliesTo(rule, collected.AppId()) {
+			continue
+		}
+
+		m.evaluate(rule, collected.AppId(), m.value(rule.Metric, &collected.Statistic), collected.Time)
+	}
+}
+
+// Stop stops resolving the alerts of inactive apps and delivers the queued alerts.
+func (m *Manager) Stop() {
+	m.stopOnce.Do(func() {
+		close(m.stopCh)
+		<-m.stoppedCh
+
+		for _, notifier := range m.notifiers {
+			notifier.Close()
+		}
+	})
+}
+
+// evaluate updates the state of the rule for the app with the value of its metric at now. It must
+// be called with mu held.
+func (m *Manager) evaluate(rule config.AlertRuleConfig, appId string, value float64, now time.Time) {
+	key := stateKey{rule: rule.Name, appId: appId}
+	state, ok := m.states[key]
+
+	if value <= rule.Threshold {
+		if ok && state.firing {
+			m.notify(rule, appId, Resolved, value, state.since, now)
+		}
+
+		delete(m.states, key)
+		return
+	}
+
+	if !ok {
+		state = &ruleState{since: now}
+		m.states[key] = state
+	}
+
+	state.lastSeen = now
+	state.value = value
+	if !state.firing && now.Sub(state.since) >= rule.For {
+		state.firing = true
+		m.notify(rule, appId, Firing, value, state.since, now)
+	}
+}
+
+func (m *Manager) notify(rule config.AlertRuleConfig, appId string, status Status, value float64, since, now time.Time) {
+	alert := Alert{
+		Status:    status,
+		Rule:      rule.Name,
+		AppId:     appId,
+		Metric:    rule.Metric,
+		Value:     value,
+		Threshold: rule.Threshold,
+		StartedAt: since,
+	}
+
+	if status == Resolved {
+		alert.ResolvedAt = &now
+	}
+
+	for _, notifier := range m.notifiers {
+		notifier.Notify(alert)
+	}
+}
+
+// resolveInactive evaluates the rules of the apps that stopped sending statistics with a value
+// of zero, as an app without statistics had no activity at all.
+func (m *Manager) resolveInactive() {
+	ticker := time.NewTicker(m.dumpInterval)
+	defer close(m.stoppedCh)
+
+	for {
+		select {
+		case now := <-ticker.C:
+			m.mu.Lock()
+			for _, rule := range m.rules {
+				for key, state := range m.states {
+					if key.rule == rule.Name && now.Sub(state.lastSeen) > 2*m.dumpInterval {
+						m.evaluate(rule, key.appId, 0, now)
+					}
+				}
+			}
+			m.mu.Unlock()
+		case <-m.stopCh:
+			ticker.Stop()
+			return
+		}
+	}
+}
+
+// value returns the metric of the statistic, the rates are per minute.
+func (m *Manager) value(metric string, stat *statistics.Statistic) float64 {
+	perMinute := func(count int) float64 {
+		return float64(count) / m.dumpInterval.Minutes()
+	}
+
+	switch metric {
+	case config.AlertMetricConcurrentConnections:
+		return float64(stat.ConcurrentConnections())
+	case config.AlertMetricCapacityPercent:
+		app := m.cm.AppManager().FindById(stat.AppId())
+		if app == nil || app.Capacity() <= 0 {
+			return 0
+		}
+
+		return float64(stat.ConcurrentConnections()) * 100 / float64(app.Capacity())
+	case config.AlertMetricApiMessagesPerMinute:
+		return perMinute(stat.ApiMessages())
+	case config.AlertMetricWebsocketMessagesPerMinute:
+		return perMinute(stat.WebsocketMessages())
+	case config.AlertMetricDroppedMessagesPerMinute:
+		return perMinute(stat.DroppedMessages())
+	case config.AlertMetricWebhookFailures:
+		return float64(stat.WebhookFailures())
+	case config.AlertMetricPeakSendQueueDepth:
+		return float64(stat.PeakSendQueueDepth())
+	case config.AlertMetricDeliveryLatencyP99:
+		return stat.DeliveryLatency().P99.Seconds() * 1000
+	}
+
+	return 0
+}
+
+func appliesTo(rule config.AlertRuleConfig, appId string) bool {
+	if len(rule.Apps) == 0 {
+		return true
+	}
+
+	for _, id := range rule.Apps {
+		if id == appId {
+			return true
+		}
+	}
+
+	return false
+}
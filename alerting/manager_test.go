@@ -0,0 +1,173 @@
+package alerting
+
+import (
+	"github.com/iamsayantan/larasockets/config"
+	"github.com/iamsayantan/larasockets/statistics"
+	"sync"
+	"testing"
+	"time"
+)
+
+type testNotifier struct {
+	mu     sync.Mutex
+	alerts []Alert
+}
+
+func (n *testNotifier) Notify(alert Alert) {
+	n.mu.Lock()
+	n.alerts = append(n.alerts, alert)
+	n.mu.Unlock()
+}
+
+func (n *testNotifier) Close() {}
+
+func (n *testNotifier) sent() []Alert {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	return append([]Alert(nil), n.alerts...)
+}
+
+// newTestManager returns a manager of the rules with a notifier that records the alerts.
+func newTestManager(t *testing.T, dumpInterval time.Duration, rules ...config.AlertRuleConfig) (*Manager, *testNotifier) {
+	t.Helper()
+
+	notifier := &testNotifier{}
+	manager := NewManager(rules, nil, dumpInterval, notifier)
+	t.Cleanup(manager.Stop)
+
+	return manager, notifier
+}
+
+// collect sends the manager a statistic of the app with the concurrent connections, collected at.
+func collect(manager *Manager, appId string, connections int, at time.Time) {
+	manager.ListenStatChanged(statistics.StatisticsCollected{
+		Statistic: *statistics.NewStatisticWithData(appId, connections, connections, 0, 0, 0, 0),
+		Time:      at,
+	})
+}
+
+func assertAlerts(t *testing.T, alerts []Alert, expected ...Alert) {
+	t.Helper()
+
+	if len(alerts) != len(expected) {
+		t.Fatalf("expected %d alerts, got %+v", len(expected), alerts)
+	}
+
+	for i, alert := range alerts {
+		e := expected[i]
+		if alert.Status != e.Status || alert.AppId != e.AppId || alert.Value != e.Value || !alert.StartedAt.Equal(e.StartedAt) {
+			t.Fatalf("expected alert %d to be %s for app %s with %v since %s, got %+v", i, e.Status, e.AppId, e.Value, e.StartedAt, alert)
+		}
+
+		if (alert.Status == Resolved) != (alert.ResolvedAt != nil) {
+			t.Fatalf("expected only resolved alerts to have a resolve time, got %+v", alert)
+		}
+
+		if e.ResolvedAt != nil && !alert.ResolvedAt.Equal(*e.ResolvedAt) {
+			t.Fatalf("expected alert %d to be resolved at %s, got %s", i, e.ResolvedAt, alert.ResolvedAt)
+		}
+	}
+}
+
+var connectionsRule = config.AlertRuleConfig{
+	Name:      "connections",
+	Metric:    config.AlertMetricConcurrentConnections,
+	Threshold: 10,
+}
+
+func TestManagerFiresAndResolvesOnce(t *testing.T) {
+	manager, notifier := newTestManager(t, time.Hour, connectionsRule)
+	start := time.Now()
+	at := func(minutes int) time.Time { return start.Add(time.Duration(minutes) * time.Minute) }
+
+	for minute, connections := range []int{5, 10, 20, 30, 25, 5, 3, 15} {
+		collect(manager, "1", connections, at(minute))
+	}
+
+	resolvedAt := at(5)
+	assertAlerts(t, notifier.sent(),
+		Alert{Status: Firing, AppId: "1", Value: 20, StartedAt: at(2)},
+		Alert{Status: Resolved, AppId: "1", Value: 5, StartedAt: at(2), ResolvedAt: &resolvedAt},
+		Alert{Status: Firing, AppId: "1", Value: 15, StartedAt: at(7)},
+	)
+}
+
+func TestManagerWaitsForTheDuration(t *testing.T) {
+	rule := connectionsRule
+	rule.For = 2 * time.Minute
+
+	manager, notifier := newTestManager(t, time.Hour, rule)
+	start := time.Now()
+	at := func(minutes int) time.Time { return start.Add(time.Duration(minutes) * time.Minute) }
+
+	// a spike shorter than the duration does not fire.
+	collect(manager, "1", 20, at(0))
+	collect(manager, "1", 20, at(1))
+	collect(manager, "1", 5, at(2))
+	if alerts := notifier.sent(); len(alerts) != 0 {
+		t.Fatalf("expected no alert for a short spike, got %+v", alerts)
+	}
+
+	collect(manager, "1", 20, at(3))
+	collect(manager, "1", 30, at(4))
+	collect(manager, "1", 40, at(5))
+	collect(manager, "1", 50, at(6))
+	collect(manager, "1", 0, at(7))
+
+	resolvedAt := at(7)
+	assertAlerts(t, notifier.sent(),
+		Alert{Status: Firing, AppId: "1", Value: 40, StartedAt: at(3)},
+		Alert{Status: Resolved, AppId: "1", Value: 0, StartedAt: at(3), ResolvedAt: &resolvedAt},
+	)
+}
+
+func TestManagerKeepsAStatePerApp(t *testing.T) {
+	rule := connectionsRule
+	rule.Apps = []string{"1", "2"}
+
+	manager, notifier := newTestManager(t, time.Hour, rule)
+	start := time.Now()
+
+	collect(manager, "1", 20, start)
+	collect(manager, "2", 20, start)
+	collect(manager, "3", 20, start)
+	collect(manager, "2", 5, start.Add(time.Minute))
+
+	resolvedAt := start.Add(time.Minute)
+	assertAlerts(t, notifier.sent(),
+		Alert{Status: Firing, AppId: "1", Value: 20, StartedAt: start},
+		Alert{Status: Firing, AppId: "2", Value: 20, StartedAt: start},
+		Alert{Status: Resolved, AppId: "2", Value: 5, StartedAt: start, ResolvedAt: &resolvedAt},
+	)
+}
+
+func TestManagerResolvesInactiveApps(t *testing.T) {
+	manager, notifier := newTestManager(t, 20*time.Millisecond, connectionsRule)
+	start := time.Now()
+
+	collect(manager, "1", 20, start)
+
+	// the app does not send statistics anymore, after two dump intervals its alert is resolved.
+	deadline := time.Now().Add(5 * time.Second)
+	for len(notifier.sent()) < 2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the alert of the inactive app to be resolved, got %+v", notifier.sent())
+		}
+
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	// the alert is only resolved once.
+	time.Sleep(100 * time.Millisecond)
+
+	alerts := notifier.sent()
+	assertAlerts(t, alerts,
+		Alert{Status: Firing, AppId: "1", Value: 20, StartedAt: start},
+		Alert{Status: Resolved, AppId: "1", Value: 0, StartedAt: start},
+	)
+
+	if alerts[1].ResolvedAt.Sub(start) <= 40*time.Millisecond {
+		t.Fatalf("expected the alert to be resolved after two dump intervals, got %s", alerts[1].ResolvedAt.Sub(start))
+	}
+}
@@ -0,0 +1,127 @@
+package alerting
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/iamsayantan/larasockets/config"
+	"github.com/iamsayantan/larasockets/statistics"
+	"go.uber.org/zap"
+	"net/http"
+	"time"
+)
+
+const (
+	// SignatureHeader holds the hex encoded HMAC-SHA256 of the body, signed with the webhook secret.
+	SignatureHeader = "X-Larasockets-Signature"
+
+	webhookQueueSize = 100
+	webhookAttempts  = 3
+)
+
+// webhookRetryDelay is the wait before the second attempt, it grows by itself with every attempt.
+var webhookRetryDelay = time.Second
+
+// NewWebhookNotifier returns a notifier that posts the alerts as JSON to the webhook url. The
+// alerts are sent from a goroutine of their own in the order they were raised, a failed request
+// is retried a few times before the alert is dropped. The result of every attempt is reported to
+// the collector as a webhook delivery of the app of the alert.
+func NewWebhookNotifier(cfg config.AlertWebhookConfig, collector statistics.StatsCollector, logger *zap.Logger) Notifier {
+	notifier := &webhookNotifier{
+		cfg:       cfg,
+		client:    &http.Client{Timeout: cfg.Timeout},
+		collector: collector,
+		logger:    logger,
+		queue:     make(chan Alert, webhookQueueSize),
+		stoppedCh: make(chan struct{}),
+	}
+
+	go notifier.run()
+
+	return notifier
+}
+
+type webhookNotifier struct {
+	cfg       config.AlertWebhookConfig
+	client    *http.Client
+	collector statistics.StatsCollector
+	logger    *zap.Logger
+	queue     chan Alert
+	stoppedCh chan struct{}
+}
+
+func (w *webhookNotifier) Notify(alert Alert) {
+	select {
+	case w.queue <- alert:
+	default:
+		w.logger.Error("alert webhook queue is full, dropping alert", zap.String("rule", alert.Rule), zap.String("app_id", alert.AppId))
+	}
+}
+
+func (w *webhookNotifier) Close() {
+	close(w.queue)
+	<-w.stoppedCh
+}
+
+func (w *webhookNotifier) run() {
+	defer close(w.stoppedCh)
+
+	for alert := range w.queue {
+		body, err := json.Marshal(alert)
+		if err != nil {
+			continue
+		}
+
+		for attempt := 1; attempt <= webhookAttempts; attempt++ {
+			if err = w.send(body); err == nil {
+				w.collector.HandleWebhookDelivery(alert.AppId, statistics.WebhookDeliverySuccess)
+				break
+			}
+
+			w.collector.HandleWebhookDelivery(alert.AppId, statistics.WebhookDeliveryFailure)
+
+			if attempt < webhookAttempts {
+				time.Sleep(time.Duration(attempt) * webhookRetryDelay)
+			}
+		}
+
+		if err != nil {
+			w.logger.Error("error sending alert webhook", zap.String("rule", alert.Rule), zap.String("app_id", alert.AppId), zap.String("error", err.Error()))
+		}
+	}
+}
+
+func (w *webhookNotifier) send(body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, w.cfg.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(SignatureHeader, Sign(body, w.cfg.Secret))
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+// Sign returns the signature of the body a receiver of the alert webhook compares with the
+// SignatureHeader.
+func Sign(body []byte, secret string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
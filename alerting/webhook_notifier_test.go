@@ -0,0 +1,98 @@
+package alerting
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/iamsayantan/larasockets/channel_managers"
+	"github.com/iamsayantan/larasockets/config"
+	"github.com/iamsayantan/larasockets/statistics/collectors"
+	"github.com/iamsayantan/larasockets/statistics/stores"
+	"go.uber.org/zap"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWebhookNotifierSignsAndRetries(t *testing.T) {
+	webhookRetryDelay = 10 * time.Millisecond
+	defer func() { webhookRetryDelay = time.Second }()
+
+	var mu sync.Mutex
+	received := make([]Alert, 0)
+	attempts := 0
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+
+		mac := hmac.New(sha256.New, []byte("webhook-secret"))
+		mac.Write(body)
+		if r.Header.Get(SignatureHeader) != hex.EncodeToString(mac.Sum(nil)) {
+			t.Errorf("expected the body to be signed with the secret, got signature %q", r.Header.Get(SignatureHeader))
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		// the first alert fails twice before it is accepted, the second one fails for good.
+		attempts++
+		if attempts == 1 || attempts == 2 || attempts >= 4 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		var alert Alert
+		if err := json.Unmarshal(body, &alert); err != nil {
+			t.Errorf("error decoding the alert: %s", err.Error())
+		}
+
+		received = append(received, alert)
+	}))
+	defer server.Close()
+
+	collector := collectors.NewMemoryCollector(channel_managers.NewLocalManager(nil, zap.NewNop()), stores.NewNullStorage(), time.Hour)
+	defer collector.Stop()
+
+	notifier := NewWebhookNotifier(config.AlertWebhookConfig{URL: server.URL, Secret: "webhook-secret", Timeout: time.Second}, collector, zap.NewNop())
+	notifier.Notify(Alert{Status: Firing, Rule: "connections", AppId: "1", Value: 20, Threshold: 10})
+	notifier.Notify(Alert{Status: Firing, Rule: "connections", AppId: "2", Value: 30, Threshold: 10})
+
+	// Close delivers the queued alerts first.
+	notifier.Close()
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if attempts != 2*webhookAttempts {
+		t.Fatalf("expected %d attempts, got %d", 2*webhookAttempts, attempts)
+	}
+
+	if len(received) != 1 || received[0].AppId != "1" || received[0].Value != 20 || received[0].Status != Firing {
+		t.Fatalf("expected the alert of app 1 to be delivered, got %+v", received)
+	}
+
+	failures := map[string]int{"1": 2, "2": webhookAttempts}
+	for appId, expected := range failures {
+		stats := collector.GetAppStatistics(appId)
+		if got := stats.WebhookFailures(); got != expected {
+			t.Fatalf("expected %d failed deliveries for app %s, got %d", expected, appId, got)
+		}
+	}
+}
+
+func TestSign(t *testing.T) {
+	mac := hmac.New(sha256.New, []byte("secret"))
+	mac.Write([]byte(`{"status":"firing"}`))
+
+	if signature := Sign([]byte(`{"status":"firing"}`), "secret"); signature != hex.EncodeToString(mac.Sum(nil)) {
+		t.Fatalf("expected the hex encoded HMAC-SHA256 of the body, got %q", signature)
+	}
+
+	if Sign([]byte(`{"status":"firing"}`), "other") == Sign([]byte(`{"status":"firing"}`), "secret") {
+		t.Fatal("expected the signature to depend on the secret")
+	}
+}
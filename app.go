@@ -1,6 +1,22 @@
 package larasockets
 
-import "github.com/iamsayantan/larasockets/config"
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"github.com/iamsayantan/larasockets/config"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrApplicationNotFound is returned when no application exists with the given id.
+	ErrApplicationNotFound = errors.New("application not found")
+
+	// ErrApplicationExists is returned when an application with the same id or key already exists.
+	ErrApplicationExists = errors.New("application with the same id or key already exists")
+)
 
 // ApplicationManager defines methods to manage all the different applications.
 type ApplicationManager interface {
@@ -14,12 +30,39 @@ type ApplicationManager interface {
 	FindByKey(key string) *Application
 }
 
+// ApplicationStore is implemented by the application managers whose applications can be
+// changed while the server is running. Disabled applications are not returned by the
+// ApplicationManager methods, so no connection or api request is accepted for them.
+type ApplicationStore interface {
+	ApplicationManager
+
+	// Disabled returns all the applications that are currently disabled.
+	Disabled() []*Application
+
+	// Create adds a new application.
+	Create(appConfig config.AppConfig) (*Application, error)
+
+	// Update replaces the settings of an existing application, see Application.Update.
+	Update(appConfig config.AppConfig) (*Application, error)
+
+	// SetDisabled disables or re-enables an application.
+	SetDisabled(id string, disabled bool) error
+
+	// Delete removes the application.
+	Delete(id string) error
+}
+
 // Application represents an individual application instance. The server can hold multiple
 // apps, and each app is an logically isolated and serves one client.
 type Application struct {
-	id                   string
-	appKey               string
-	appSecret            string
+	id string
+
+	// mu guards the settings below, they are replaced by Update while connections use them.
+	mu     sync.RWMutex
+	appKey string
+	// secrets holds the current secret first, followed by the previous secrets that are
+	// still accepted until they expire.
+	secrets              []ApplicationSecret
 	appName              string
 	host                 string
 	path                 string
@@ -32,30 +75,93 @@ func (app *Application) Id() string {
 }
 
 func (app *Application) Key() string {
+	app.mu.RLock()
+	defer app.mu.RUnlock()
+
 	return app.appKey
 }
 
+// Secret returns the current secret of the app, everything the server signs is signed with it.
 func (app *Application) Secret() string {
-	return app.appSecret
+	app.mu.RLock()
+	defer app.mu.RUnlock()
+
+	return app.secrets[0].secret
+}
+
+// SecretVersion returns the version of the current secret.
+func (app *Application) SecretVersion() string {
+	app.mu.RLock()
+	defer app.mu.RUnlock()
+
+	return app.secrets[0].version
+}
+
+// ValidSecrets returns the current secret and the previous secrets that have not expired yet.
+func (app *Application) ValidSecrets() []ApplicationSecret {
+	app.mu.RLock()
+	defer app.mu.RUnlock()
+
+	now := time.Now()
+	secrets := make([]ApplicationSecret, 0, len(app.secrets))
+	for _, secret := range app.secrets {
+		if !secret.expiresAt.IsZero() && now.After(secret.expiresAt) {
+			continue
+		}
+
+		secrets = append(secrets, secret)
+	}
+
+	return secrets
+}
+
+// VerifySignature checks the hex encoded HMAC-SHA256 signature of the message against every
+// valid secret of the app. It returns the version of the secret that produced the signature.
+func (app *Application) VerifySignature(message string, signature []byte) (string, bool) {
+	for _, secret := range app.ValidSecrets() {
+		h := hmac.New(sha256.New, []byte(secret.secret))
+		h.Write([]byte(message))
+
+		if hmac.Equal(h.Sum(nil), signature) {
+			return secret.version, true
+		}
+	}
+
+	return "", false
 }
 
 func (app *Application) Name() string {
+	app.mu.RLock()
+	defer app.mu.RUnlock()
+
 	return app.appName
 }
 
 func (app *Application) Host() string {
+	app.mu.RLock()
+	defer app.mu.RUnlock()
+
 	return app.host
 }
 
 func (app *Application) Path() string {
+	app.mu.RLock()
+	defer app.mu.RUnlock()
+
 	return app.path
 }
 
 func (app *Application) Capacity() int {
+	app.mu.RLock()
+	defer app.mu.RUnlock()
+
 	return app.capacity
 }
 
 func (app *Application) ClientMessageEnabled() bool {
+	app.mu.RLock()
+	defer app.mu.RUnlock()
+
 	return app.clientMessageEnabled
 }
 
@@ -64,10 +170,16 @@ func (app *Application) SetName(name string) {
 		return
 	}
 
+	app.mu.Lock()
+	defer app.mu.Unlock()
+
 	app.appName = name
 }
 
 func (app *Application) EnableClientMessages() {
+	app.mu.Lock()
+	defer app.mu.Unlock()
+
 	app.clientMessageEnabled = true
 }
 
@@ -76,21 +188,82 @@ func (app *Application) SetCapacity(capacity int) {
 		return
 	}
 
+	app.mu.Lock()
+	defer app.mu.Unlock()
+
 	app.capacity = capacity
 }
 
+// Update replaces the settings of the app with the ones of appConfig, the id of the app is kept.
+// The connections that are already made to the app share the instance, so they are checked
+// against the new secrets from now on.
+func (app *Application) Update(appConfig config.AppConfig) {
+	updated := NewApplication(appConfig)
+
+	app.mu.Lock()
+	defer app.mu.Unlock()
+
+	app.appKey = updated.appKey
+	app.secrets = updated.secrets
+	app.appName = updated.appName
+	app.capacity = updated.capacity
+	app.clientMessageEnabled = updated.clientMessageEnabled
+}
+
+// ApplicationSecret is one of the secrets of an application.
+type ApplicationSecret struct {
+	version   string
+	secret    string
+	expiresAt time.Time
+}
+
+func (s ApplicationSecret) Version() string {
+	return s.version
+}
+
+func (s ApplicationSecret) Secret() string {
+	return s.secret
+}
+
+// ExpiresAt returns when the secret stops being accepted, it is zero for the current secret.
+func (s ApplicationSecret) ExpiresAt() time.Time {
+	return s.expiresAt
+}
+
 // NewApplication returns a new application instance.
 func NewApplication(appConfig config.AppConfig) *Application {
 	app := &Application{
-		id:        appConfig.ID,
-		appKey:    appConfig.Key,
-		appSecret: appConfig.Secret,
-		appName:   appConfig.Name,
+		id:      appConfig.ID,
+		appKey:  appConfig.Key,
+		appName: appConfig.Name,
+	}
+
+	currentVersion := appConfig.SecretVersion
+	if currentVersion == "" {
+		currentVersion = "current"
+	}
+
+	app.secrets = append(app.secrets, ApplicationSecret{version: currentVersion, secret: appConfig.Secret})
+	for i, previousSecret := range appConfig.PreviousSecrets {
+		version := previousSecret.Version
+		if version == "" {
+			version = fmt.Sprintf("previous-%d", i+1)
+		}
+
+		app.secrets = append(app.secrets, ApplicationSecret{
+			version:   version,
+			secret:    previousSecret.Secret,
+			expiresAt: previousSecret.ExpiresAt,
+		})
 	}
 
 	if appConfig.Capacity > 0 {
 		app.SetCapacity(appConfig.Capacity)
 	}
 
+	if appConfig.EnableClientMessages {
+		app.EnableClientMessages()
+	}
+
 	return app
 }
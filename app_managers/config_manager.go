@@ -3,25 +3,42 @@ package app_managers
 import (
 	"github.com/iamsayantan/larasockets"
 	"github.com/iamsayantan/larasockets/config"
+	"reflect"
+	"sync"
 )
 
+// ConfigManager is the ApplicationManager of the apps listed in the configuration file.
+type ConfigManager interface {
+	larasockets.ApplicationManager
+
+	// Reload replaces all the apps with the given ones and returns the ids of the apps that
+	// were removed. Apps that are still configured keep their instance, the changed ones are
+	// updated in place, so the connections made to them use the new settings right away.
+	Reload(appsConfig []config.AppConfig) []string
+}
+
 type configApplicationManager struct {
+	// mu guards the apps map, which is swapped as a whole on every reload.
+	mu   sync.RWMutex
 	apps map[string]*larasockets.Application
+	// configs holds the configuration every app was made from, to find the changed apps
+	// on reload.
+	configs map[string]config.AppConfig
 }
 
 // NewConfigManager returns an ApplicationManager instance that is managed in memory.
 // The apps are loaded from configuration file.
-func NewConfigManager(appsConfig []config.AppConfig) larasockets.ApplicationManager {
-	apps := make(map[string]*larasockets.Application, 0)
-	for _, configApp := range appsConfig {
-		app := larasockets.NewApplication(configApp)
-		apps[app.Id()] = app
-	}
+func NewConfigManager(appsConfig []config.AppConfig) ConfigManager {
+	manager := &configApplicationManager{}
+	manager.Reload(appsConfig)
 
-	return &configApplicationManager{apps: apps}
+	return manager
 }
 
 func (c *configApplicationManager) All() []*larasockets.Application {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
 	apps := make([]*larasockets.Application, 0)
 	for _, app := range c.apps {
 		apps = append(apps, app)
@@ -31,6 +48,9 @@ func (c *configApplicationManager) All() []*larasockets.Application {
 }
 
 func (c *configApplicationManager) FindById(id string) *larasockets.Application {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
 	app, ok := c.apps[id]
 	if !ok {
 		return nil
@@ -40,6 +60,9 @@ func (c *configApplicationManager) FindById(id string) *larasockets.Application
 }
 
 func (c *configApplicationManager) FindByKey(key string) *larasockets.Application {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
 	for _, app := range c.apps {
 		if app.Key() == key {
 			return app
@@ -48,3 +71,34 @@ func (c *configApplicationManager) FindByKey(key string) *larasockets.Applicatio
 
 	return nil
 }
+
+func (c *configApplicationManager) Reload(appsConfig []config.AppConfig) []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	apps := make(map[string]*larasockets.Application, 0)
+	configs := make(map[string]config.AppConfig, 0)
+	for _, configApp := range appsConfig {
+		app, ok := c.apps[configApp.ID]
+		if !ok {
+			app = larasockets.NewApplication(configApp)
+		} else if !reflect.DeepEqual(c.configs[configApp.ID], configApp) {
+			app.Update(configApp)
+		}
+
+		apps[app.Id()] = app
+		configs[app.Id()] = configApp
+	}
+
+	removed := make([]string, 0)
+	for id := range c.apps {
+		if _, ok := apps[id]; !ok {
+			removed = append(removed, id)
+		}
+	}
+
+	c.apps = apps
+	c.configs = configs
+
+	return removed
+}
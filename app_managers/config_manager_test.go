@@ -0,0 +1,64 @@
+package app_managers
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"github.com/iamsayantan/larasockets/config"
+	"testing"
+)
+
+func sign(secret, message string) []byte {
+	h := hmac.New(sha256.New, []byte(secret))
+	h.Write([]byte(message))
+
+	return h.Sum(nil)
+}
+
+func TestConfigManagerReload(t *testing.T) {
+	unchanged := config.AppConfig{ID: "1", Key: "key1", Secret: "secret1"}
+	changed := config.AppConfig{ID: "2", Key: "key2", Secret: "secret2", Capacity: 10}
+	removed := config.AppConfig{ID: "3", Key: "key3", Secret: "secret3"}
+
+	manager := NewConfigManager([]config.AppConfig{unchanged, changed, removed})
+	unchangedApp, changedApp := manager.FindById("1"), manager.FindById("2")
+
+	rotated := changed
+	rotated.Secret = "secret2-rotated"
+	rotated.Capacity = 20
+	added := config.AppConfig{ID: "4", Key: "key4", Secret: "secret4"}
+
+	removedIds := manager.Reload([]config.AppConfig{unchanged, rotated, added})
+	if len(removedIds) != 1 || removedIds[0] != "3" {
+		t.Fatalf("expected app 3 to be removed, got %v", removedIds)
+	}
+
+	if manager.FindById("3") != nil || manager.FindByKey("key3") != nil {
+		t.Fatal("expected the removed app not to be found")
+	}
+
+	if manager.FindById("4") == nil {
+		t.Fatal("expected the added app to be found")
+	}
+
+	if manager.FindById("1") != unchangedApp {
+		t.Fatal("expected the unchanged app to keep its instance")
+	}
+
+	// the connections made to the changed app hold on to its instance, they must see the new
+	// settings.
+	if manager.FindById("2") != changedApp {
+		t.Fatal("expected the changed app to keep its instance")
+	}
+
+	if changedApp.Secret() != "secret2-rotated" || changedApp.Capacity() != 20 {
+		t.Fatalf("expected the changed app to be updated, got secret %q and capacity %d", changedApp.Secret(), changedApp.Capacity())
+	}
+
+	if _, ok := changedApp.VerifySignature("1.1:private-room", sign("secret2-rotated", "1.1:private-room")); !ok {
+		t.Fatal("expected the new secret to be accepted")
+	}
+
+	if _, ok := changedApp.VerifySignature("1.1:private-room", sign("secret2", "1.1:private-room")); ok {
+		t.Fatal("expected the replaced secret to be rejected")
+	}
+}
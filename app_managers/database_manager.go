@@ -0,0 +1,293 @@
+package app_managers
+
+import (
+	"encoding/json"
+	"github.com/iamsayantan/larasockets"
+	"github.com/iamsayantan/larasockets/config"
+	"gorm.io/gorm"
+	"sync"
+	"time"
+)
+
+// LarasocketsApplication is the database row of an application.
+type LarasocketsApplication struct {
+	ID            string `gorm:"primarykey;size:191"`
+	Name          string
+	Key           string `gorm:"uniqueIndex;size:191"`
+	Secret        string
+	SecretVersion string
+	// PreviousSecrets holds the json encoded previous secrets that are still accepted.
+	PreviousSecrets      string `gorm:"type:text"`
+	Capacity             int
+	EnableClientMessages bool
+	Disabled             bool
+	CreatedAt            time.Time
+	UpdatedAt            time.Time
+}
+
+func newLarasocketsApplication(appConfig config.AppConfig) (LarasocketsApplication, error) {
+	previousSecrets, err := json.Marshal(appConfig.PreviousSecrets)
+	if err != nil {
+		return LarasocketsApplication{}, err
+	}
+
+	return LarasocketsApplication{
+		ID:                   appConfig.ID,
+		Name:                 appConfig.Name,
+		Key:                  appConfig.Key,
+		Secret:               appConfig.Secret,
+		SecretVersion:        appConfig.SecretVersion,
+		PreviousSecrets:      string(previousSecrets),
+		Capacity:             appConfig.Capacity,
+		EnableClientMessages: appConfig.EnableClientMessages,
+	}, nil
+}
+
+func (a LarasocketsApplication) appConfig() (config.AppConfig, error) {
+	appConfig := config.AppConfig{
+		ID:                   a.ID,
+		Name:                 a.Name,
+		Key:                  a.Key,
+		Secret:               a.Secret,
+		SecretVersion:        a.SecretVersion,
+		Capacity:             a.Capacity,
+		EnableClientMessages: a.EnableClientMessages,
+	}
+
+	if a.PreviousSecrets != "" {
+		if err := json.Unmarshal([]byte(a.PreviousSecrets), &appConfig.PreviousSecrets); err != nil {
+			return appConfig, err
+		}
+	}
+
+	return appConfig, nil
+}
+
+type databaseApplicationManager struct {
+	db *gorm.DB
+
+	// mu guards the cached applications. Lookups are served from the cache, the database
+	// is only read on start up and written to when an application changes.
+	mu       sync.RWMutex
+	apps     map[string]*larasockets.Application
+	disabled map[string]bool
+}
+
+// NewDatabaseManager returns an ApplicationStore whose applications are stored in the database.
+// The apps given in seed are created if no app with the same id exists yet, so apps can be moved
+// from the configuration file to the database.
+func NewDatabaseManager(db *gorm.DB, seed []config.AppConfig) (larasockets.ApplicationStore, error) {
+	manager := &databaseApplicationManager{
+		db:       db,
+		apps:     make(map[string]*larasockets.Application, 0),
+		disabled: make(map[string]bool, 0),
+	}
+
+	if err := manager.load(); err != nil {
+		return nil, err
+	}
+
+	for _, appConfig := range seed {
+		if _, ok := manager.apps[appConfig.ID]; ok {
+			continue
+		}
+
+		if _, err := manager.Create(appConfig); err != nil {
+			return nil, err
+		}
+	}
+
+	return manager, nil
+}
+
+func (m *databaseApplicationManager) All() []*larasockets.Application {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	apps := make([]*larasockets.Application, 0)
+	for _, app := range m.apps {
+		if m.disabled[app.Id()] {
+			continue
+		}
+
+		apps = append(apps, app)
+	}
+
+	return apps
+}
+
+func (m *databaseApplicationManager) FindById(id string) *larasockets.Application {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	app, ok := m.apps[id]
+	if !ok || m.disabled[id] {
+		return nil
+	}
+
+	return app
+}
+
+func (m *databaseApplicationManager) FindByKey(key string) *larasockets.Application {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	app := m.findByKey(key)
+	if app == nil || m.disabled[app.Id()] {
+		return nil
+	}
+
+	return app
+}
+
+func (m *databaseApplicationManager) Disabled() []*larasockets.Application {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	apps := make([]*larasockets.Application, 0)
+	for id := range m.disabled {
+		apps = append(apps, m.apps[id])
+	}
+
+	return apps
+}
+
+func (m *databaseApplicationManager) Create(appConfig config.AppConfig) (*larasockets.Application, error) {
+	if err := appConfig.Validate(); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.apps[appConfig.ID]; ok || m.findByKey(appConfig.Key) != nil {
+		return nil, larasockets.ErrApplicationExists
+	}
+
+	row, err := newLarasocketsApplication(appConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := m.db.Create(&row).Error; err != nil {
+		return nil, err
+	}
+
+	app := larasockets.NewApplication(appConfig)
+	m.apps[app.Id()] = app
+
+	return app, nil
+}
+
+func (m *databaseApplicationManager) Update(appConfig config.AppConfig) (*larasockets.Application, error) {
+	if err := appConfig.Validate(); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	app, ok := m.apps[appConfig.ID]
+	if !ok {
+		return nil, larasockets.ErrApplicationNotFound
+	}
+
+	if existing := m.findByKey(appConfig.Key); existing != nil && existing.Id() != appConfig.ID {
+		return nil, larasockets.ErrApplicationExists
+	}
+
+	row, err := newLarasocketsApplication(appConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	err = m.db.Model(&LarasocketsApplication{ID: appConfig.ID}).
+		Select("Name", "Key", "Secret", "SecretVersion", "PreviousSecrets", "Capacity", "EnableClientMessages").
+		Updates(row).
+		Error
+
+	if err != nil {
+		return nil, err
+	}
+
+	// the connections made to the app share its instance, so it is updated in place.
+	app.Update(appConfig)
+
+	return app, nil
+}
+
+func (m *databaseApplicationManager) SetDisabled(id string, disabled bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.apps[id]; !ok {
+		return larasockets.ErrApplicationNotFound
+	}
+
+	err := m.db.Model(&LarasocketsApplication{ID: id}).Update("disabled", disabled).Error
+	if err != nil {
+		return err
+	}
+
+	if disabled {
+		m.disabled[id] = true
+	} else {
+		delete(m.disabled, id)
+	}
+
+	return nil
+}
+
+func (m *databaseApplicationManager) Delete(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.apps[id]; !ok {
+		return larasockets.ErrApplicationNotFound
+	}
+
+	if err := m.db.Delete(&LarasocketsApplication{ID: id}).Error; err != nil {
+		return err
+	}
+
+	delete(m.apps, id)
+	delete(m.disabled, id)
+
+	return nil
+}
+
+// load fills the cache with all the applications stored in the database.
+func (m *databaseApplicationManager) load() error {
+	var rows []LarasocketsApplication
+	if err := m.db.Find(&rows).Error; err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, row := range rows {
+		appConfig, err := row.appConfig()
+		if err != nil {
+			return err
+		}
+
+		m.apps[row.ID] = larasockets.NewApplication(appConfig)
+		if row.Disabled {
+			m.disabled[row.ID] = true
+		}
+	}
+
+	return nil
+}
+
+// findByKey must be called with the lock held.
+func (m *databaseApplicationManager) findByKey(key string) *larasockets.Application {
+	for _, app := range m.apps {
+		if app.Key() == key {
+			return app
+		}
+	}
+
+	return nil
+}
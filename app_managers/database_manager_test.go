@@ -0,0 +1,164 @@
+package app_managers
+
+import (
+	"github.com/glebarez/sqlite"
+	"github.com/iamsayantan/larasockets"
+	"github.com/iamsayantan/larasockets/config"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func openTestDatabase(t *testing.T) *gorm.DB {
+	t.Helper()
+
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "apps.db")), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatalf("error opening the database: %s", err.Error())
+	}
+
+	if err := db.AutoMigrate(&LarasocketsApplication{}); err != nil {
+		t.Fatalf("error migrating the database: %s", err.Error())
+	}
+
+	return db
+}
+
+func newTestDatabaseManager(t *testing.T, db *gorm.DB, seed ...config.AppConfig) larasockets.ApplicationStore {
+	t.Helper()
+
+	manager, err := NewDatabaseManager(db, seed)
+	if err != nil {
+		t.Fatalf("error creating the database manager: %s", err.Error())
+	}
+
+	return manager
+}
+
+func TestDatabaseManagerCreate(t *testing.T) {
+	db := openTestDatabase(t)
+	manager := newTestDatabaseManager(t, db, config.AppConfig{ID: "1", Key: "key1", Secret: "secret1"})
+
+	if _, err := manager.Create(config.AppConfig{ID: "2", Key: "key2", Secret: "secret2", Capacity: 5}); err != nil {
+		t.Fatalf("error creating an app: %s", err.Error())
+	}
+
+	for _, appConfig := range []config.AppConfig{
+		{ID: "1", Key: "other", Secret: "secret"},
+		{ID: "3", Key: "key2", Secret: "secret"},
+	} {
+		if _, err := manager.Create(appConfig); err != larasockets.ErrApplicationExists {
+			t.Fatalf("expected app %s with key %s to exist already, got %v", appConfig.ID, appConfig.Key, err)
+		}
+	}
+
+	if _, err := manager.Create(config.AppConfig{ID: "3", Key: "key3"}); err == nil {
+		t.Fatal("expected an app without a secret to be rejected")
+	}
+
+	if app := manager.FindByKey("key2"); app == nil || app.Id() != "2" || app.Capacity() != 5 {
+		t.Fatalf("expected app 2 to be found by its key, got %v", app)
+	}
+
+	// the seed only creates the apps that do not exist yet.
+	reloaded := newTestDatabaseManager(t, db, config.AppConfig{ID: "1", Key: "key1", Secret: "changed"}, config.AppConfig{ID: "4", Key: "key4", Secret: "secret4"})
+	if len(reloaded.All()) != 3 {
+		t.Fatalf("expected 3 apps to be loaded, got %d", len(reloaded.All()))
+	}
+
+	if secret := reloaded.FindById("1").Secret(); secret != "secret1" {
+		t.Fatalf("expected the stored secret of app 1 to be kept, got %q", secret)
+	}
+}
+
+func TestDatabaseManagerUpdate(t *testing.T) {
+	db := openTestDatabase(t)
+	manager := newTestDatabaseManager(t, db,
+		config.AppConfig{ID: "1", Key: "key1", Secret: "secret1"},
+		config.AppConfig{ID: "2", Key: "key2", Secret: "secret2"},
+	)
+
+	app := manager.FindById("1")
+	expiresAt := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+	updated, err := manager.Update(config.AppConfig{
+		ID:              "1",
+		Key:             "key1",
+		Secret:          "rotated",
+		SecretVersion:   "v2",
+		PreviousSecrets: []config.SecretConfig{{Version: "v1", Secret: "secret1", ExpiresAt: expiresAt}},
+		Capacity:        10,
+	})
+
+	if err != nil {
+		t.Fatalf("error updating the app: %s", err.Error())
+	}
+
+	// the connections made to the app hold on to its instance.
+	if updated != app || app.Secret() != "rotated" || app.Capacity() != 10 {
+		t.Fatalf("expected the app to be updated in place, got secret %q and capacity %d", app.Secret(), app.Capacity())
+	}
+
+	if _, err := manager.Update(config.AppConfig{ID: "1", Key: "key2", Secret: "secret"}); err != larasockets.ErrApplicationExists {
+		t.Fatalf("expected the key of app 2 to be taken, got %v", err)
+	}
+
+	if _, err := manager.Update(config.AppConfig{ID: "3", Key: "key3", Secret: "secret"}); err != larasockets.ErrApplicationNotFound {
+		t.Fatalf("expected app 3 not to be found, got %v", err)
+	}
+
+	reloaded := newTestDatabaseManager(t, db).FindById("1")
+	secrets := reloaded.ValidSecrets()
+	if reloaded.Secret() != "rotated" || reloaded.SecretVersion() != "v2" || len(secrets) != 2 {
+		t.Fatalf("expected the update to be stored, got secret %q version %q and %d secrets", reloaded.Secret(), reloaded.SecretVersion(), len(secrets))
+	}
+
+	if secrets[1].Secret() != "secret1" || !secrets[1].ExpiresAt().Equal(expiresAt) {
+		t.Fatalf("expected the previous secret to be stored, got %q expiring at %s", secrets[1].Secret(), secrets[1].ExpiresAt())
+	}
+}
+
+func TestDatabaseManagerDisableAndDelete(t *testing.T) {
+	db := openTestDatabase(t)
+	manager := newTestDatabaseManager(t, db,
+		config.AppConfig{ID: "1", Key: "key1", Secret: "secret1"},
+		config.AppConfig{ID: "2", Key: "key2", Secret: "secret2"},
+	)
+
+	if err := manager.SetDisabled("1", true); err != nil {
+		t.Fatalf("error disabling the app: %s", err.Error())
+	}
+
+	if manager.FindById("1") != nil || manager.FindByKey("key1") != nil || len(manager.All()) != 1 {
+		t.Fatal("expected the disabled app not to be found")
+	}
+
+	if disabled := manager.Disabled(); len(disabled) != 1 || disabled[0].Id() != "1" {
+		t.Fatalf("expected app 1 to be disabled, got %v", disabled)
+	}
+
+	if reloaded := newTestDatabaseManager(t, db); reloaded.FindById("1") != nil || len(reloaded.Disabled()) != 1 {
+		t.Fatal("expected the app to stay disabled after a restart")
+	}
+
+	if err := manager.SetDisabled("1", false); err != nil || manager.FindById("1") == nil {
+		t.Fatalf("expected the app to be enabled again, got %v", err)
+	}
+
+	if err := manager.Delete("2"); err != nil {
+		t.Fatalf("error deleting the app: %s", err.Error())
+	}
+
+	if err := manager.Delete("2"); err != larasockets.ErrApplicationNotFound {
+		t.Fatalf("expected the deleted app not to be found, got %v", err)
+	}
+
+	if err := manager.SetDisabled("2", true); err != larasockets.ErrApplicationNotFound {
+		t.Fatalf("expected the deleted app not to be found, got %v", err)
+	}
+
+	if reloaded := newTestDatabaseManager(t, db); reloaded.FindById("2") != nil || len(reloaded.All()) != 1 {
+		t.Fatal("expected the deleted app to be gone after a restart")
+	}
+}
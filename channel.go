@@ -1,5 +1,7 @@
 package larasockets
 
+import "context"
+
 // Channel interface defines the methods required for a channel to implement.
 type Channel interface {
 	// Name returns the name of the channel
@@ -8,8 +10,10 @@ type Channel interface {
 	// Connections returns all the concurrent connections to this channel
 	Connections() []Connection
 
-	// Subscribe subscribes a new connection to the channel
-	Subscribe(conn Connection, payload interface{})
+	// Subscribe subscribes a new connection to the channel. A *SubscriptionError is returned
+	// when the subscription is rejected. Subscribe does not send anything to the connection,
+	// the caller sends the reply of the subscription, so it can do that without holding locks.
+	Subscribe(conn Connection, payload interface{}) (Subscription, error)
 
 	// Unsubscribe removes the connection from the current subscribed connections
 	UnSubscribe(conn Connection)
@@ -17,10 +21,33 @@ type Channel interface {
 	// IsSubscribed returns if the given connection is already subscribed to this channel
 	IsSubscribed(conn Connection) bool
 
-	// Broadcast sends the data to all the connected connections
-	Broadcast(data interface{})
+	// Broadcast sends the data to all the connected connections. The data is serialized
+	// only once and the same payload is sent to every connection. If ctx carries a trace,
+	// the fan-out and the delivery to every connection are traced as part of it.
+	Broadcast(ctx context.Context, data interface{})
 
 	// BroadcastExcept sends the data to all the connected connections except the given
 	// connection. Usually its the connection who initiated the broadcast.
-	BroadcastExcept(data interface{}, excludedConnectionId string)
+	BroadcastExcept(ctx context.Context, data interface{}, excludedConnectionId string)
+}
+
+// Subscription is the outcome of subscribing a connection to a channel.
+type Subscription struct {
+	// Reply is the message to send to the connection, the subscription_succeeded message or the
+	// error of a rejected subscription. It is nil when there is nothing to send.
+	Reply interface{}
+
+	// Occupied is true when the connection is the first subscriber of the channel.
+	Occupied bool
+}
+
+// SubscriptionError is returned when a connection is not allowed to subscribe to a channel.
+type SubscriptionError struct {
+	// Reason is a short machine readable description of the failure, used in the statistics.
+	Reason  string
+	Message string
+}
+
+func (e *SubscriptionError) Error() string {
+	return e.Message
 }
@@ -19,7 +19,7 @@ type ChannelManager interface {
 	FindOrCreateChannel(appId, channelName string) Channel
 
 	// SubscribeToChannel subscribes a connection to a channel.
-	SubscribeToChannel(conn Connection, channelName string, payload interface{})
+	SubscribeToChannel(conn Connection, channelName string, payload interface{}) error
 
 	// UnsubscribeFromChannel removes a connection from the channel
 	UnsubscribeFromChannel(conn Connection, channelName string, payload interface{})
@@ -5,15 +5,29 @@ import (
 	"github.com/iamsayantan/larasockets/channels"
 	"github.com/iamsayantan/larasockets/events"
 	"go.uber.org/zap"
+	"sync"
 )
 
 type localChannelManager struct {
 	appManager larasockets.ApplicationManager
 
 	logger *zap.Logger
-	// channels stores all the active channels per app with channel name as the key.
-	// map[appId]map[channelName]*Channel2
-	channels map[string]map[string]larasockets.Channel
+
+	// mu only guards the apps map. Every app has its own lock for its channels, so
+	// subscriptions and broadcasts of one app never wait on another app.
+	mu sync.RWMutex
+	// apps stores the channel registry of every app that had at least one channel.
+	// map[appId]*appChannels
+	apps map[string]*appChannels
+}
+
+// appChannels is the channel registry of a single application.
+type appChannels struct {
+	// mu guards the channels map. Subscribing to an existing channel only needs the read
+	// lock, the write lock is taken when a channel is created or removed.
+	mu sync.RWMutex
+	// channels stores all the active channels of the app with channel name as the key.
+	channels map[string]larasockets.Channel
 }
 
 // NewLocalManager will return return a ChannelManager instance that is managed in memory.
@@ -23,7 +37,7 @@ func NewLocalManager(apps larasockets.ApplicationManager, logger *zap.Logger) la
 		logger:     logger,
 	}
 
-	channelManager.channels = make(map[string]map[string]larasockets.Channel, 0)
+	channelManager.apps = make(map[string]*appChannels, 0)
 	return channelManager
 }
 
@@ -32,7 +46,15 @@ func (cm *localChannelManager) AppManager() larasockets.ApplicationManager {
 }
 
 func (cm *localChannelManager) FindChannel(appId, channelName string) larasockets.Channel {
-	channel, ok := cm.channels[appId][channelName]
+	registry := cm.registry(appId, false)
+	if registry == nil {
+		return nil
+	}
+
+	registry.mu.RLock()
+	defer registry.mu.RUnlock()
+
+	channel, ok := registry.channels[channelName]
 	if !ok {
 		return nil
 	}
@@ -42,13 +64,16 @@ func (cm *localChannelManager) FindChannel(appId, channelName string) larasocket
 
 func (cm *localChannelManager) AllChannels(appId string) []larasockets.Channel {
 	c := make([]larasockets.Channel, 0)
-	existingChannels, ok := cm.channels[appId]
-	if !ok {
-		cm.logger.Info("no channel found for this app id", zap.String("application_id", appId))
+	registry := cm.registry(appId, false)
+	if registry == nil {
+		cm.logger.Debug("no channel found for this app id", zap.String("application_id", appId))
 		return c
 	}
 
-	for _, channel := range existingChannels {
+	registry.mu.RLock()
+	defer registry.mu.RUnlock()
+
+	for _, channel := range registry.channels {
 		c = append(c, channel)
 	}
 
@@ -61,72 +86,112 @@ func (cm *localChannelManager) ConcurrentConnectionsForApp(appId string) int {
 		return 0
 	}
 
-	connectionIds := make([]string, 0)
 	connectionKeys := make(map[string]bool, 0)
-
 	for _, c := range activeChannels {
 		for _, conn := range c.Connections() {
-			if _, ok := connectionKeys[conn.Id()]; !ok {
-				connectionIds = append(connectionIds, conn.Id())
-				connectionKeys[conn.Id()] = true
-			}
+			connectionKeys[conn.Id()] = true
 		}
 	}
 
-	return len(connectionIds)
+	return len(connectionKeys)
 }
 
 func (cm *localChannelManager) FindOrCreateChannel(appId, channelName string) larasockets.Channel {
-	existingChannel := cm.FindChannel(appId, channelName)
-	if existingChannel != nil {
+	registry := cm.registry(appId, true)
+
+	registry.mu.RLock()
+	existingChannel, ok := registry.channels[channelName]
+	registry.mu.RUnlock()
+
+	if ok {
 		return existingChannel
 	}
 
-	// determine if a channels map already exists for this app, if no channel map exists,
-	// we need to make a new map for it.
-	existingChannelsForApp, ok := cm.channels[appId]
-	if !ok {
-		existingChannelsForApp = make(map[string]larasockets.Channel)
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+
+	// some other goroutine might have created the channel while we were waiting for the lock.
+	if existingChannel, ok := registry.channels[channelName]; ok {
+		return existingChannel
 	}
 
 	newChannel := channels.NewChannel(channelName)
-	existingChannelsForApp[channelName] = newChannel
+	registry.channels[channelName] = newChannel
 
-	cm.channels[appId] = existingChannelsForApp
 	return newChannel
 }
 
-func (cm *localChannelManager) RemoveChannel(appId, channelName string) {
-	channelListForApp, ok := cm.channels[appId]
-	if !ok {
-		return
+// RemoveChannel removes the channel from the app if nobody is subscribed to it anymore. It reports
+// whether the channel was removed.
+func (cm *localChannelManager) RemoveChannel(appId, channelName string) bool {
+	registry := cm.registry(appId, false)
+	if registry == nil {
+		return false
 	}
 
-	channelDetails := cm.FindChannel(appId, channelName)
-	if channelDetails == nil {
-		return
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+
+	channelDetails, ok := registry.channels[channelName]
+	if !ok {
+		return false
 	}
 
-	// we should not remove channel if there is anyone connected to it.
+	// we should not remove channel if there is anyone connected to it. As subscriptions hold the read
+	// lock of the registry, nobody can join the channel while we are checking.
 	if connections := channelDetails.Connections(); len(connections) != 0 {
-		return
+		return false
 	}
 
-	delete(channelListForApp, channelName)
-	cm.channels[appId] = channelListForApp
+	delete(registry.channels, channelName)
+	return true
 }
 
-func (cm *localChannelManager) SubscribeToChannel(conn larasockets.Connection, channelName string, payload interface{}) {
-	channel := cm.FindOrCreateChannel(conn.App().Id(), channelName)
-	channel.Subscribe(conn, payload)
+func (cm *localChannelManager) SubscribeToChannel(conn larasockets.Connection, channelName string, payload interface{}) error {
+	var subscription larasockets.Subscription
+	subscribe := func() error {
+		var err error
+		cm.withChannel(conn.App().Id(), channelName, func(channel larasockets.Channel) {
+			subscription, err = channel.Subscribe(conn, payload)
+		})
+
+		// the reply is sent after the registry lock is released, so a slow connection does not hold
+		// up the other subscriptions of the app.
+		if subscription.Reply != nil {
+			conn.Send(subscription.Reply)
+		}
+
+		return err
+	}
+
+	// the dashboard is sent the recent log right after it joined, before its own subscription
+	// is logged.
+	var err error
+	if channelName == events.DashboardLogChannel(conn.App().Id()) {
+		err = events.SubscribeToLog(conn, conn.App().Id(), subscribe)
+	} else {
+		err = subscribe()
+	}
+
+	if err != nil {
+		return err
+	}
 
 	// if this is the first connection in the channel, then we can trigger a channel-occupied event.
-	if currentConns := channel.Connections(); len(currentConns) == 1 {
+	if subscription.Occupied {
 		events.LogEvent(cm, events.Occupied, events.DashboardLogDetails{
 			AppId:       conn.App().Id(),
 			ChannelName: channelName,
 		})
 	}
+
+	events.LogEvent(cm, events.Subscribed, events.DashboardLogDetails{
+		AppId:        conn.App().Id(),
+		ChannelName:  channelName,
+		ConnectionId: conn.Id(),
+	})
+
+	return nil
 }
 
 func (cm *localChannelManager) UnsubscribeFromChannel(conn larasockets.Connection, channelName string, payload interface{}) {
@@ -140,7 +205,10 @@ func (cm *localChannelManager) UnsubscribeFromChannel(conn larasockets.Connectio
 
 	// if there are no  more connections is the channel, then remove the channel from memory.
 	if currentConns := channel.Connections(); len(currentConns) == 0 {
-		cm.RemoveChannel(conn.App().Id(), channelName)
+		if removed := cm.RemoveChannel(conn.App().Id(), channelName); !removed {
+			return
+		}
+
 		events.LogEvent(cm, events.Vacated, events.DashboardLogDetails{
 			AppId:       conn.App().Id(),
 			ChannelName: channelName,
@@ -152,7 +220,58 @@ func (cm *localChannelManager) UnsubscribeFromChannel(conn larasockets.Connectio
 func (cm *localChannelManager) UnsubscribeFromAllChannels(conn larasockets.Connection) {
 	c := cm.AllChannels(conn.App().Id())
 	for _, channel := range c {
+		if !channel.IsSubscribed(conn) {
+			continue
+		}
+
 		cm.UnsubscribeFromChannel(conn, channel.Name(), struct{}{})
-		channel.UnSubscribe(conn)
 	}
 }
+
+// withChannel finds or creates the channel and calls fn with it while holding the read lock
+// of the app registry, so the channel can not be removed from the app until fn returns. fn
+// must not call back into the channel manager or send to a connection.
+func (cm *localChannelManager) withChannel(appId, channelName string, fn func(channel larasockets.Channel)) {
+	registry := cm.registry(appId, true)
+
+	for {
+		registry.mu.RLock()
+		channel, ok := registry.channels[channelName]
+		if ok {
+			fn(channel)
+			registry.mu.RUnlock()
+			return
+		}
+		registry.mu.RUnlock()
+
+		registry.mu.Lock()
+		if _, ok := registry.channels[channelName]; !ok {
+			registry.channels[channelName] = channels.NewChannel(channelName)
+		}
+		registry.mu.Unlock()
+	}
+}
+
+// registry returns the channel registry of the app. If the app does not have a registry yet and
+// create is true, a new one is made for it, otherwise nil is returned.
+func (cm *localChannelManager) registry(appId string, create bool) *appChannels {
+	cm.mu.RLock()
+	registry, ok := cm.apps[appId]
+	cm.mu.RUnlock()
+
+	if ok || !create {
+		return registry
+	}
+
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	if registry, ok := cm.apps[appId]; ok {
+		return registry
+	}
+
+	registry = &appChannels{channels: make(map[string]larasockets.Channel)}
+	cm.apps[appId] = registry
+
+	return registry
+}
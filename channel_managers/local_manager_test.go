@@ -0,0 +1,320 @@
+package channel_managers
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/iamsayantan/larasockets"
+	"github.com/iamsayantan/larasockets/config"
+	"github.com/iamsayantan/larasockets/events"
+	"github.com/iamsayantan/larasockets/messages"
+	"go.uber.org/zap"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type testConnection struct {
+	id  string
+	app *larasockets.Application
+
+	sent int64
+	// block, when set, makes Send wait until it is closed.
+	block chan struct{}
+
+	mu       sync.Mutex
+	received [][]byte
+}
+
+func (c *testConnection) Id() string                    { return c.id }
+func (c *testConnection) App() *larasockets.Application { return c.app }
+func (c *testConnection) Receive()                      {}
+func (c *testConnection) Close()                        {}
+
+func (c *testConnection) Send(data interface{}) {
+	if c.block != nil {
+		<-c.block
+	}
+
+	atomic.AddInt64(&c.sent, 1)
+
+	payload, _ := json.Marshal(data)
+	if message, ok := data.(*larasockets.EncodedMessage); ok {
+		payload = message.Payload()
+	}
+
+	c.mu.Lock()
+	c.received = append(c.received, payload)
+	c.mu.Unlock()
+}
+
+// logEntries returns the dashboard log entries the connection was sent, in the order they were
+// sent.
+func (c *testConnection) logEntries(t *testing.T) []events.DashboardLogEntry {
+	t.Helper()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entries := make([]events.DashboardLogEntry, 0)
+	for _, payload := range c.received {
+		var message messages.PusherEventPayload
+		if err := json.Unmarshal(payload, &message); err != nil || message.Event != "log" {
+			continue
+		}
+
+		var entry events.DashboardLogEntry
+		if err := json.Unmarshal([]byte(message.Data), &entry); err != nil {
+			t.Fatalf("error decoding the log entry %q: %s", message.Data, err.Error())
+		}
+
+		entries = append(entries, entry)
+	}
+
+	return entries
+}
+
+func (c *testConnection) Disconnect(ctx context.Context, code int, message string) {}
+
+func (c *testConnection) Info() larasockets.ConnectionInfo {
+	return larasockets.ConnectionInfo{Id: c.id, AppId: c.app.Id()}
+}
+
+func newTestManager(appId string) (*localChannelManager, *larasockets.Application) {
+	app := larasockets.NewApplication(config.AppConfig{ID: appId, Key: appId + "-key", Secret: appId + "-secret"})
+	events.RecentLogs.Forget(appId)
+
+	return NewLocalManager(nil, zap.NewNop()).(*localChannelManager), app
+}
+
+func newTestConnections(app *larasockets.Application, n int) []*testConnection {
+	connections := make([]*testConnection, n)
+	for i := range connections {
+		connections[i] = &testConnection{id: fmt.Sprintf("%d.%d", i, i), app: app}
+	}
+
+	return connections
+}
+
+func countLogEntries(appId string, eventType events.EventType, channelName string) int {
+	return len(events.RecentLogs.Find(appId, events.LogFilter{Type: eventType, ChannelName: channelName}))
+}
+
+func TestSubscribeToChannelFiresOccupiedOnce(t *testing.T) {
+	cm, app := newTestManager("occupied")
+	connections := newTestConnections(app, 100)
+
+	var wg sync.WaitGroup
+	for _, conn := range connections {
+		wg.Add(1)
+		go func(conn *testConnection) {
+			defer wg.Done()
+			if err := cm.SubscribeToChannel(conn, "presence-less", struct{}{}); err != nil {
+				t.Errorf("unexpected subscription error: %s", err.Error())
+			}
+		}(conn)
+	}
+	wg.Wait()
+
+	if got := countLogEntries(app.Id(), events.Occupied, "presence-less"); got != 1 {
+		t.Fatalf("expected 1 occupied event, got %d", got)
+	}
+
+	if got := len(cm.FindChannel(app.Id(), "presence-less").Connections()); got != len(connections) {
+		t.Fatalf("expected %d subscribers, got %d", len(connections), got)
+	}
+
+	for _, conn := range connections {
+		wg.Add(1)
+		go func(conn *testConnection) {
+			defer wg.Done()
+			cm.UnsubscribeFromChannel(conn, "presence-less", struct{}{})
+		}(conn)
+	}
+	wg.Wait()
+
+	if got := countLogEntries(app.Id(), events.Vacated, "presence-less"); got != 1 {
+		t.Fatalf("expected 1 vacated event, got %d", got)
+	}
+
+	if cm.FindChannel(app.Id(), "presence-less") != nil {
+		t.Fatal("expected the vacated channel to be removed")
+	}
+
+	for _, conn := range connections {
+		if sent := atomic.LoadInt64(&conn.sent); sent != 1 {
+			t.Fatalf("expected connection %s to get 1 subscription_succeeded message, got %d", conn.id, sent)
+		}
+	}
+}
+
+func TestSubscribeToChannelWhileChannelsChange(t *testing.T) {
+	cm, app := newTestManager("hammer")
+	connections := newTestConnections(app, 20)
+	channelNames := []string{"one", "two", "three"}
+
+	var wg sync.WaitGroup
+	for _, conn := range connections {
+		wg.Add(1)
+		go func(conn *testConnection) {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				channelName := channelNames[i%len(channelNames)]
+				if err := cm.SubscribeToChannel(conn, channelName, struct{}{}); err != nil {
+					t.Errorf("unexpected subscription error: %s", err.Error())
+					return
+				}
+
+				if channel := cm.FindChannel(app.Id(), channelName); channel != nil {
+					channel.Broadcast(context.Background(), map[string]int{"i": i})
+				}
+
+				cm.UnsubscribeFromChannel(conn, channelName, struct{}{})
+			}
+		}(conn)
+	}
+
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 500; j++ {
+				cm.RemoveChannel(app.Id(), channelNames[j%len(channelNames)])
+				cm.ConcurrentConnectionsForApp(app.Id())
+			}
+		}()
+	}
+	wg.Wait()
+
+	for _, channelName := range channelNames {
+		if channel := cm.FindChannel(app.Id(), channelName); channel != nil && len(channel.Connections()) != 0 {
+			t.Fatalf("expected channel %s to have no subscribers, got %d", channelName, len(channel.Connections()))
+		}
+	}
+}
+
+func TestSubscribeToChannelDoesNotHoldRegistryWhileSending(t *testing.T) {
+	cm, app := newTestManager("slow")
+	slow := &testConnection{id: "1.1", app: app, block: make(chan struct{})}
+	fast := &testConnection{id: "2.2", app: app}
+
+	subscribed := make(chan struct{})
+	go func() {
+		defer close(subscribed)
+		_ = cm.SubscribeToChannel(slow, "slow-channel", struct{}{})
+	}()
+
+	// wait for the slow connection to join the channel, its reply is now blocked.
+	for cm.FindChannel(app.Id(), "slow-channel") == nil || len(cm.FindChannel(app.Id(), "slow-channel").Connections()) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		cm.FindOrCreateChannel(app.Id(), "other-channel")
+		cm.RemoveChannel(app.Id(), "other-channel")
+		_ = cm.SubscribeToChannel(fast, "fast-channel", struct{}{})
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the channels of the app were blocked by a slow connection")
+	}
+
+	close(slow.block)
+	<-subscribed
+}
+
+// dashboardSubscription returns the signed payload to subscribe the connection to the dashboard
+// log of its app.
+func dashboardSubscription(conn *testConnection) messages.PusherSubscriptionPayload {
+	channelName := events.DashboardLogChannel(conn.app.Id())
+
+	h := hmac.New(sha256.New, []byte(conn.app.Secret()))
+	h.Write([]byte(conn.id + ":" + channelName))
+
+	return messages.PusherSubscriptionPayload{
+		Channel: channelName,
+		Auth:    conn.app.Key() + ":" + hex.EncodeToString(h.Sum(nil)),
+	}
+}
+
+func TestSubscribeToDashboardLogReplaysBeforeTheSubscription(t *testing.T) {
+	cm, app := newTestManager("replay")
+	dashboard := &testConnection{id: "1.1", app: app}
+
+	for i := 0; i < 3; i++ {
+		events.LogEvent(cm, events.Connected, events.DashboardLogDetails{AppId: app.Id(), ConnectionId: fmt.Sprintf("%d.0", i)})
+	}
+
+	payload := dashboardSubscription(dashboard)
+	if err := cm.SubscribeToChannel(dashboard, payload.Channel, payload); err != nil {
+		t.Fatalf("unexpected subscription error: %s", err.Error())
+	}
+
+	entries := dashboard.logEntries(t)
+	if len(entries) != 5 {
+		t.Fatalf("expected 3 replayed entries followed by occupied and subscribed, got %+v", entries)
+	}
+
+	for i := 0; i < 3; i++ {
+		if entries[i].Type != events.Connected || entries[i].ConnectionId != fmt.Sprintf("%d.0", i) {
+			t.Fatalf("expected entry %d to be the connection of %d.0, got %+v", i, i, entries[i])
+		}
+	}
+
+	// the subscription of the dashboard itself is only sent live.
+	if entries[4].Type != events.Subscribed || entries[4].ConnectionId != dashboard.id {
+		t.Fatalf("expected the subscription of the dashboard to be sent last, got %+v", entries[4])
+	}
+}
+
+func TestSubscribeToDashboardLogWhileLogging(t *testing.T) {
+	cm, app := newTestManager("replay-live")
+	dashboard := &testConnection{id: "1.1", app: app}
+	logged := 2000
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < logged; i++ {
+			events.LogEvent(cm, events.ApiMessage, events.DashboardLogDetails{AppId: app.Id(), EventName: fmt.Sprintf("%d", i)})
+		}
+	}()
+
+	for len(events.RecentLogs.Find(app.Id(), events.LogFilter{})) < events.DashboardLogReplaySize {
+		time.Sleep(time.Millisecond)
+	}
+
+	payload := dashboardSubscription(dashboard)
+	if err := cm.SubscribeToChannel(dashboard, payload.Channel, payload); err != nil {
+		t.Fatalf("unexpected subscription error: %s", err.Error())
+	}
+	<-done
+
+	// every message is either replayed or sent live, exactly once and in the order it was logged.
+	previous := -1
+	for _, entry := range dashboard.logEntries(t) {
+		if entry.Type != events.ApiMessage {
+			continue
+		}
+
+		var n int
+		fmt.Sscanf(entry.EventName, "%d", &n)
+		if previous != -1 && n != previous+1 {
+			t.Fatalf("expected message %d after message %d, got message %d", previous+1, previous, n)
+		}
+
+		previous = n
+	}
+
+	if previous != logged-1 {
+		t.Fatalf("expected the last message to be %d, got %d", logged-1, previous)
+	}
+}
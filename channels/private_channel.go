@@ -1,8 +1,6 @@
 package channels
 
 import (
-	"crypto/hmac"
-	"crypto/sha256"
 	"encoding/hex"
 	"errors"
 	"github.com/iamsayantan/larasockets"
@@ -15,11 +13,11 @@ type privateChannel struct {
 	publicChannel
 }
 
-func (c *privateChannel) Subscribe(conn larasockets.Connection, payload interface{}) {
+func (c *privateChannel) Subscribe(conn larasockets.Connection, payload interface{}) (larasockets.Subscription, error) {
 	subscriptionPayload, ok := payload.(messages.PusherSubscriptionPayload)
 	if !ok {
 		log.Printf("error converting the payload")
-		return
+		return larasockets.Subscription{}, &larasockets.SubscriptionError{Reason: "invalid_payload", Message: "invalid subscription payload"}
 	}
 
 	err := c.verifySignature(conn, subscriptionPayload)
@@ -27,28 +25,17 @@ func (c *privateChannel) Subscribe(conn larasockets.Connection, payload interfac
 		log.Printf("error verifying signature: %s", err.Error())
 		// see https://pusher.com/docs/channels/library_auth_reference/pusher-websockets-protocol#Error-Codes
 		errMessage := messages.NewPusherErrorMessage(err.Error(), 4009)
-		conn.Send(errMessage)
 
-		return
+		return larasockets.Subscription{Reply: errMessage}, &larasockets.SubscriptionError{Reason: "invalid_signature", Message: err.Error()}
 	}
 
-	if c.IsSubscribed(conn) {
+	added, occupied := c.addConnection(conn)
+	if !added {
 		log.Printf("connection already subscribed")
-		return
+		return larasockets.Subscription{}, nil
 	}
 
-	c.connections[conn.Id()] = conn
-
-	resp := struct {
-		Event   string      `json:"event"`
-		Channel string      `json:"channel"`
-		Data    interface{} `json:"data"`
-	}{
-		Event:   "pusher_internal:subscription_succeeded",
-		Channel: c.Name(),
-		Data:    "{}",
-	}
-	conn.Send(resp)
+	return larasockets.Subscription{Reply: c.subscriptionSucceeded(), Occupied: occupied}, nil
 }
 
 func (c *privateChannel) verifySignature(conn larasockets.Connection, payload messages.PusherSubscriptionPayload) error {
@@ -68,14 +55,15 @@ func (c *privateChannel) verifySignature(conn larasockets.Connection, payload me
 	s := strings.SplitAfter(payload.Auth, ":")
 	incomingSignature, _ := hex.DecodeString(strings.Join(s[1:], ""))
 
-	signedSignature := signature.String()
-	h := hmac.New(sha256.New, []byte(conn.App().Secret()))
-	h.Write([]byte(signedSignature))
-
-	if valid := hmac.Equal(incomingSignature, h.Sum(nil)); !valid {
+	secretVersion, valid := conn.App().VerifySignature(signature.String(), incomingSignature)
+	if !valid {
 		return errors.New("invalid auth signature")
 	}
 
+	if secretVersion != conn.App().SecretVersion() {
+		log.Printf("subscription to %s authorized with previous secret version %s", c.Name(), secretVersion)
+	}
+
 	return nil
 }
 
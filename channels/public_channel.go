@@ -1,11 +1,22 @@
 package channels
 
 import (
+	"context"
 	"github.com/iamsayantan/larasockets"
+	"github.com/iamsayantan/larasockets/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"log"
+	"sync"
 )
 
 type publicChannel struct {
-	name        string
+	name string
+
+	// mu guards the connections map. Broadcasts only hold it long enough to take a
+	// snapshot of the subscribers, the data is sent after the lock is released.
+	mu          sync.RWMutex
 	connections map[string]larasockets.Connection
 }
 
@@ -14,7 +25,10 @@ func (c *publicChannel) Name() string {
 }
 
 func (c *publicChannel) Connections() []larasockets.Connection {
-	connections := make([]larasockets.Connection, 0)
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	connections := make([]larasockets.Connection, 0, len(c.connections))
 	for _, conn := range c.connections {
 		connections = append(connections, conn)
 	}
@@ -22,50 +36,109 @@ func (c *publicChannel) Connections() []larasockets.Connection {
 	return connections
 }
 
-func (c *publicChannel) Subscribe(conn larasockets.Connection, payload interface{}) {
-	if c.IsSubscribed(conn) {
-		return
+func (c *publicChannel) Subscribe(conn larasockets.Connection, payload interface{}) (larasockets.Subscription, error) {
+	added, occupied := c.addConnection(conn)
+	if !added {
+		return larasockets.Subscription{}, nil
 	}
-	c.connections[conn.Id()] = conn
 
-	resp := struct {
-		Event   string      `json:"event"`
-		Channel string      `json:"channel"`
-		Data    interface{} `json:"data"`
-	}{
-		Event:   "pusher_internal:subscription_succeeded",
-		Channel: c.Name(),
-		Data:    "{}",
-	}
-	conn.Send(resp)
+	return larasockets.Subscription{Reply: c.subscriptionSucceeded(), Occupied: occupied}, nil
 }
 
 func (c *publicChannel) UnSubscribe(conn larasockets.Connection) {
-	if !c.IsSubscribed(conn) {
-		return
-	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
 
 	delete(c.connections, conn.Id())
 }
 
 func (c *publicChannel) IsSubscribed(conn larasockets.Connection) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
 	_, ok := c.connections[conn.Id()]
 	return ok
 }
 
-func (c *publicChannel) Broadcast(data interface{}) {
-	for _, conn := range c.connections {
-		conn.Send(data)
-	}
+func (c *publicChannel) Broadcast(ctx context.Context, data interface{}) {
+	c.broadcast(ctx, data, "")
 }
 
-func (c *publicChannel) BroadcastExcept(data interface{}, excludedConnectionId string) {
-	for _, conn := range c.connections {
+func (c *publicChannel) BroadcastExcept(ctx context.Context, data interface{}, excludedConnectionId string) {
+	c.broadcast(ctx, data, excludedConnectionId)
+}
+
+// broadcast encodes the data once and sends the same message to all the subscribers except
+// the excluded connection, if any.
+func (c *publicChannel) broadcast(ctx context.Context, data interface{}, excludedConnectionId string) {
+	// only broadcasts that are part of a trace are traced, the internal broadcasts to the
+	// dashboard would otherwise start a new trace every time.
+	span := trace.SpanFromContext(ctx)
+	if span.SpanContext().IsValid() {
+		ctx, span = tracing.Tracer().Start(ctx, "larasockets.channel.broadcast", trace.WithAttributes(
+			attribute.String("larasockets.channel", c.Name()),
+			attribute.String("larasockets.excluded_connection_id", excludedConnectionId),
+		))
+		defer span.End()
+	}
+
+	connections := c.Connections()
+	span.SetAttributes(attribute.Int("larasockets.subscribers", len(connections)))
+	if len(connections) == 0 {
+		return
+	}
+
+	message, err := larasockets.NewEncodedMessage(data)
+	if err != nil {
+		log.Printf("error encoding broadcast message: %s", err.Error())
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "error encoding broadcast message")
+		return
+	}
+
+	message.SetContext(ctx)
+
+	// framing the message once only pays off when it is written to more than one connection.
+	if len(connections) > 1 {
+		if err := message.Prepare(); err != nil {
+			log.Printf("error preparing broadcast message: %s", err.Error())
+		}
+	}
+
+	for _, conn := range connections {
 		if conn.Id() == excludedConnectionId {
 			continue
 		}
 
-		conn.Send(data)
+		conn.Send(message)
+		span.AddEvent("enqueue", trace.WithAttributes(attribute.String("larasockets.connection_id", conn.Id())))
+	}
+}
+
+// addConnection adds the connection to the subscribers of the channel. It returns false if
+// the connection was already subscribed, and whether the channel had no subscribers before.
+func (c *publicChannel) addConnection(conn larasockets.Connection) (added bool, occupied bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.connections[conn.Id()]; ok {
+		return false, false
+	}
+
+	occupied = len(c.connections) == 0
+	c.connections[conn.Id()] = conn
+	return true, occupied
+}
+
+func (c *publicChannel) subscriptionSucceeded() interface{} {
+	return struct {
+		Event   string      `json:"event"`
+		Channel string      `json:"channel"`
+		Data    interface{} `json:"data"`
+	}{
+		Event:   "pusher_internal:subscription_succeeded",
+		Channel: c.Name(),
+		Data:    "{}",
 	}
 }
 
@@ -0,0 +1,143 @@
+package channels
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/websocket"
+	"github.com/iamsayantan/larasockets"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// benchmarkConnection writes the messages to a websocket connection the way the server does.
+type benchmarkConnection struct {
+	id string
+	ws *websocket.Conn
+}
+
+func (c *benchmarkConnection) Id() string                    { return c.id }
+func (c *benchmarkConnection) App() *larasockets.Application { return nil }
+func (c *benchmarkConnection) Receive()                      {}
+func (c *benchmarkConnection) Close()                        {}
+
+func (c *benchmarkConnection) Send(data interface{}) {
+	message, err := larasockets.NewEncodedMessage(data)
+	if err != nil {
+		panic(err)
+	}
+
+	if frame := message.PreparedFrame(); frame != nil {
+		err = c.ws.WritePreparedMessage(frame)
+	} else {
+		err = c.ws.WriteMessage(websocket.TextMessage, message.Payload())
+	}
+
+	if err != nil {
+		panic(err)
+	}
+}
+
+func (c *benchmarkConnection) Disconnect(ctx context.Context, code int, message string) {}
+
+func (c *benchmarkConnection) Info() larasockets.ConnectionInfo {
+	return larasockets.ConnectionInfo{Id: c.id}
+}
+
+// newBenchmarkConnections returns n server side connections, the client side of each reads and
+// discards everything written to it.
+func newBenchmarkConnections(b *testing.B, n int) []*benchmarkConnection {
+	b.Helper()
+
+	upgrader := websocket.Upgrader{}
+	accepted := make(chan *websocket.Conn)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+
+		accepted <- ws
+	}))
+	b.Cleanup(server.Close)
+
+	url := "ws" + strings.TrimPrefix(server.URL, "http")
+	connections := make([]*benchmarkConnection, n)
+	for i := range connections {
+		client, _, err := websocket.DefaultDialer.Dial(url, nil)
+		if err != nil {
+			b.Fatalf("error connecting to the test server: %s", err.Error())
+		}
+
+		go func() {
+			for {
+				_, reader, err := client.NextReader()
+				if err != nil {
+					return
+				}
+
+				_, _ = io.Copy(ioutil.Discard, reader)
+			}
+		}()
+
+		ws := <-accepted
+		b.Cleanup(func() {
+			_ = ws.Close()
+			_ = client.Close()
+		})
+
+		connections[i] = &benchmarkConnection{id: fmt.Sprintf("%d.%d", i, i), ws: ws}
+	}
+
+	return connections
+}
+
+// plainBroadcast sends the data to every connection on its own, each of them encodes and frames
+// the message again.
+func plainBroadcast(connections []*benchmarkConnection, data interface{}) {
+	for _, conn := range connections {
+		payload, err := json.Marshal(data)
+		if err != nil {
+			panic(err)
+		}
+
+		if err := conn.ws.WriteMessage(websocket.TextMessage, payload); err != nil {
+			panic(err)
+		}
+	}
+}
+
+func BenchmarkBroadcast(b *testing.B) {
+	data := map[string]interface{}{
+		"event":   "order.shipped",
+		"channel": "orders",
+		"data":    strings.Repeat(`{"id":1,"status":"shipped"}`, 20),
+	}
+
+	for _, subscribers := range []int{1, 10, 100, 500} {
+		connections := newBenchmarkConnections(b, subscribers)
+
+		channel := newPublicChannel("orders")
+		for _, conn := range connections {
+			channel.Subscribe(conn, nil)
+		}
+
+		b.Run(fmt.Sprintf("prepared/%d", subscribers), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				channel.Broadcast(context.Background(), data)
+			}
+		})
+
+		b.Run(fmt.Sprintf("plain/%d", subscribers), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				plainBroadcast(connections, data)
+			}
+		})
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"github.com/glebarez/sqlite"
+	"github.com/iamsayantan/larasockets/app_managers"
+	"github.com/iamsayantan/larasockets/config"
+	"github.com/iamsayantan/larasockets/statistics"
+	"github.com/iamsayantan/larasockets/statistics/stores"
+	"github.com/iamsayantan/larasockets/user_managers"
+	"gorm.io/driver/mysql"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+// openDatabase connects to the sql database of the configured driver and migrates its tables.
+// It returns nil when the driver does not use an sql database.
+func openDatabase(databaseConfig config.DatabaseConfig) (*gorm.DB, error) {
+	var dialector gorm.Dialector
+	switch databaseConfig.Driver {
+	case config.DatabaseDriverSQLite:
+		dialector = sqlite.Open(databaseConfig.Path)
+	case config.DatabaseDriverMySQL:
+		dsn := fmt.Sprintf("%s:%s@tcp(%s:%s)/%s?charset=utf8mb4&parseTime=true",
+			databaseConfig.Username,
+			databaseConfig.Password,
+			databaseConfig.Host,
+			databaseConfig.Port,
+			databaseConfig.Db,
+		)
+
+		dialector = mysql.Open(dsn)
+	case config.DatabaseDriverPostgres:
+		sslMode := databaseConfig.SSLMode
+		if sslMode == "" {
+			sslMode = "disable"
+		}
+
+		dsn := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=%s",
+			databaseConfig.Host,
+			databaseConfig.Port,
+			databaseConfig.Username,
+			databaseConfig.Password,
+			databaseConfig.Db,
+			sslMode,
+		)
+
+		dialector = postgres.Open(dsn)
+	default:
+		return nil, nil
+	}
+
+	db, err := gorm.Open(dialector, &gorm.Config{})
+	if err != nil {
+		return nil, fmt.Errorf("could not connect to the database: %w", err)
+	}
+
+	tables := []interface{}{&app_managers.LarasocketsApplication{}, &user_managers.LarasocketsUser{}}
+
+	// the postgres statistics storage migrates its tables itself.
+	if databaseConfig.Driver != config.DatabaseDriverPostgres {
+		tables = append(tables, &stores.LarasocketsStatistic{})
+		tables = append(tables, stores.RollupTables()...)
+	}
+
+	if err = db.AutoMigrate(tables...); err != nil {
+		return nil, fmt.Errorf("error migrating the database tables: %w", err)
+	}
+
+	return db, nil
+}
+
+// newStatsStorage returns the statistics storage of the configured driver.
+func newStatsStorage(databaseConfig config.DatabaseConfig, db *gorm.DB) (statistics.StatsStorage, error) {
+	switch databaseConfig.Driver {
+	case config.DatabaseDriverNone:
+		return stores.NewNullStorage(), nil
+	case config.DatabaseDriverMemory:
+		return stores.NewMemoryStorage(databaseConfig.MemoryCapacity), nil
+	case config.DatabaseDriverPostgres:
+		return stores.NewPostgresStorage(db, databaseConfig.Rollup.Retention, databaseConfig.WriteBuffer)
+	default:
+		return stores.NewDatabaseStorage(db, databaseConfig.Rollup.Retention, databaseConfig.WriteBuffer), nil
+	}
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"github.com/iamsayantan/larasockets"
+	"io"
+	"strings"
+)
+
+// runHashPassword is the hash-password subcommand, it reads a password from the standard input
+// and writes its bcrypt hash, to be used as the passwordhash of a user in the configuration file.
+//
+//	echo -n 'secret' | larasockets hash-password
+func runHashPassword(stdin io.Reader, stdout io.Writer) error {
+	password, err := bufio.NewReader(stdin).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return err
+	}
+
+	password = strings.TrimRight(password, "\r\n")
+	if password == "" {
+		return errors.New("the password can not be empty")
+	}
+
+	hash, err := larasockets.HashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintln(stdout, hash)
+	return err
+}
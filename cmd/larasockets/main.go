@@ -1,20 +1,35 @@
 package main
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"flag"
 	"fmt"
+	"github.com/fsnotify/fsnotify"
+	"github.com/iamsayantan/larasockets"
+	"github.com/iamsayantan/larasockets/alerting"
 	"github.com/iamsayantan/larasockets/app_managers"
 	"github.com/iamsayantan/larasockets/channel_managers"
 	"github.com/iamsayantan/larasockets/config"
 	"github.com/iamsayantan/larasockets/server"
+	"github.com/iamsayantan/larasockets/socket_ids"
+	"github.com/iamsayantan/larasockets/statistics"
 	"github.com/iamsayantan/larasockets/statistics/collectors"
 	"github.com/iamsayantan/larasockets/statistics/listeners"
 	"github.com/iamsayantan/larasockets/statistics/stores"
+	"github.com/iamsayantan/larasockets/tracing"
+	"github.com/iamsayantan/larasockets/user_managers"
+	"github.com/mitchellh/mapstructure"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/spf13/viper"
 	"go.uber.org/zap"
-	"gorm.io/driver/mysql"
-	"gorm.io/gorm"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 )
 
 var (
@@ -27,7 +42,37 @@ func main() {
 
 	viper.SetConfigName("config")
 	viper.SetConfigType("yaml")
+	viper.SetDefault("appmanager", config.AppManagerConfig)
+	viper.SetDefault("usermanager", config.UserManagerConfig)
+	viper.SetDefault("database.driver", config.DatabaseDriverMySQL)
+	viper.SetDefault("database.memorycapacity", 17280)
+	viper.SetDefault("database.dumpinterval", "5s")
+	viper.SetDefault("database.writebuffer.batchsize", 100)
+	viper.SetDefault("database.writebuffer.flushinterval", "1s")
+	viper.SetDefault("database.writebuffer.capacity", 10000)
+	viper.SetDefault("database.writebuffer.spillcapacity", 50000)
+	viper.SetDefault("database.rollup.interval", "1m")
+	viper.SetDefault("database.rollup.retention.raw", "48h")
+	viper.SetDefault("database.rollup.retention.minute", "336h")
+	viper.SetDefault("database.rollup.retention.hour", "2160h")
+	viper.SetDefault("database.rollup.retention.day", "0s")
 	viper.SetDefault("server.port", "8005")
+	viper.SetDefault("server.sendqueue.size", 256)
+	viper.SetDefault("server.sendqueue.policy", config.SlowConsumerDropOldest)
+	viper.SetDefault("server.sendqueue.closecode", 4100)
+	viper.SetDefault("server.shutdown.timeout", "30s")
+	viper.SetDefault("server.shutdown.batchsize", 500)
+	viper.SetDefault("server.shutdown.batchinterval", "1s")
+	viper.SetDefault("server.metrics.enabled", false)
+	viper.SetDefault("server.metrics.path", "/metrics")
+	viper.SetDefault("server.dashboard.enabled", true)
+	viper.SetDefault("server.dashboard.path", "/ui")
+	viper.SetDefault("server.dashboard.tokenttl", "24h")
+	viper.SetDefault("server.dashboard.appsecretlogin", false)
+	viper.SetDefault("tracing.exporter", config.TracingExporterNone)
+	viper.SetDefault("tracing.servicename", "larasockets")
+	viper.SetDefault("tracing.sampleratio", 1)
+	viper.SetDefault("alerting.webhook.timeout", "5s")
 
 	viper.AddConfigPath(*configPath)
 
@@ -36,13 +81,22 @@ func main() {
 		panic(err.Error())
 	}
 
+	// hashing a password does not need the configuration file.
+	if flag.Arg(0) == "hash-password" {
+		if err := runHashPassword(os.Stdin, os.Stdout); err != nil {
+			logger.Fatal("error hashing the password", zap.String("error", err.Error()))
+		}
+
+		return
+	}
+
 	var larasocketConfig config.LarasocketsConfig
 	if err := viper.ReadInConfig(); err != nil {
 		logger.Fatal("error reading configuration file", zap.String("error", err.Error()))
 		return
 	}
 
-	err = viper.Unmarshal(&larasocketConfig)
+	err = unmarshalConfig(&larasocketConfig)
 	if err != nil {
 		logger.Fatal("error unmarshalling configuration file", zap.String("error", err.Error()))
 		return
@@ -53,46 +107,237 @@ func main() {
 		return
 	}
 
-	dsn := fmt.Sprintf("%s:%s@tcp(%s:%s)/%s?charset=utf8mb4&parseTime=true",
-		larasocketConfig.Database.Username,
-		larasocketConfig.Database.Password,
-		larasocketConfig.Database.Host,
-		larasocketConfig.Database.Port,
-		larasocketConfig.Database.Db,
-	)
+	if flag.Arg(0) == "usage" {
+		if err := runUsage(larasocketConfig, flag.Args()[1:], os.Stdout); err != nil {
+			logger.Fatal("error exporting the usage", zap.String("error", err.Error()))
+		}
 
-	db, err := gorm.Open(mysql.Open(dsn), &gorm.Config{})
+		return
+	}
+
+	shutdownTracing, err := tracing.Setup(context.Background(), larasocketConfig.Tracing)
 	if err != nil {
-		panic(fmt.Sprintf("Could not connect to the database: %s", err.Error()))
+		logger.Fatal("error setting up tracing", zap.String("error", err.Error()))
+		return
 	}
 
-	err = db.AutoMigrate(&stores.LarasocketsStatistic{})
+	db, err := openDatabase(larasocketConfig.Database)
 	if err != nil {
-		logger.Error("error migrating the statistics table.", zap.String("error", err.Error()))
+		logger.Fatal("error opening the database", zap.String("error", err.Error()))
 		return
 	}
 
-	appManager := app_managers.NewConfigManager(larasocketConfig.Apps)
+	var appManager larasockets.ApplicationManager
+	var configAppManager app_managers.ConfigManager
+	if larasocketConfig.AppManager == config.AppManagerDatabase {
+		appManager, err = app_managers.NewDatabaseManager(db, larasocketConfig.Apps)
+		if err != nil {
+			logger.Fatal("error loading applications from the database", zap.String("error", err.Error()))
+		}
+	} else {
+		configAppManager = app_managers.NewConfigManager(larasocketConfig.Apps)
+		appManager = configAppManager
+	}
+
+	var userManager larasockets.UserManager
+	if larasocketConfig.UserManager == config.UserManagerDatabase {
+		userManager, err = user_managers.NewDatabaseManager(db, larasocketConfig.Users)
+		if err != nil {
+			logger.Fatal("error loading dashboard users from the database", zap.String("error", err.Error()))
+		}
+	} else {
+		userManager = user_managers.NewConfigManager(larasocketConfig.Users)
+	}
+
+	if larasocketConfig.Server.Dashboard.Enabled && len(userManager.All()) == 0 && !larasocketConfig.Server.Dashboard.AppSecretLogin {
+		logger.Warn("no dashboard users configured, nobody can log in to the dashboard")
+	}
+
+	if larasocketConfig.Server.Dashboard.TokenKey == "" {
+		larasocketConfig.Server.Dashboard.TokenKey, err = randomTokenKey()
+		if err != nil {
+			logger.Fatal("error generating the dashboard token key", zap.String("error", err.Error()))
+		}
+
+		logger.Warn("no dashboard token key configured, dashboard tokens will not survive a restart or work across nodes")
+	}
+
 	channelManager := channel_managers.NewLocalManager(appManager, logger)
 
-	statsStore := stores.NewDatabaseStorage(db)
-	statsCollector := collectors.NewMemoryCollector(channelManager, statsStore)
-	statsCollector.RegisterStatsListener(listeners.NewConcurrentConnectionListener(channelManager))
+	statsStore, err := newStatsStorage(larasocketConfig.Database, db)
+	if err != nil {
+		logger.Fatal("error setting up the statistics storage", zap.String("error", err.Error()))
+		return
+	}
+
+	var rollups *stores.Rollups
+	if larasocketConfig.Database.IsSQL() {
+		rollups = stores.NewRollups(db, larasocketConfig.Database.Rollup)
+		rollups.Start()
+	}
+
+	statsCollector := collectors.NewMemoryCollector(channelManager, statsStore, larasocketConfig.Database.DumpInterval)
+	statsCollector.RegisterStatsListener(listeners.NewConcurrentConnectionListener(channelManager), statistics.DefaultListenerBuffer)
+	statsCollector.RegisterStatsListener(listeners.NewCurrentStatsListener(channelManager), statistics.DefaultListenerBuffer)
+
+	var metricsHandler http.Handler
+	if larasocketConfig.Server.Metrics.Enabled {
+		registry := prometheus.NewRegistry()
+		registry.MustRegister(prometheus.NewGoCollector(), prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}))
+
+		statsCollector, err = collectors.NewPrometheusCollector(statsCollector, channelManager, registry)
+		if err != nil {
+			logger.Fatal("error registering the prometheus metrics", zap.String("error", err.Error()))
+			return
+		}
 
-	srv := server.NewServer(logger, channelManager, statsCollector, statsStore)
+		metricsHandler = promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+	}
+
+	var alertManager *alerting.Manager
+	if alertingConfig := larasocketConfig.Alerting; len(alertingConfig.Rules) > 0 {
+		notifiers := []alerting.Notifier{alerting.NewDashboardNotifier(channelManager)}
+		if alertingConfig.Webhook.URL != "" {
+			notifiers = append(notifiers, alerting.NewWebhookNotifier(alertingConfig.Webhook, statsCollector, logger))
+		}
+
+		alertManager = alerting.NewManager(alertingConfig.Rules, channelManager, larasocketConfig.Database.DumpInterval, notifiers...)
+		statsCollector.RegisterStatsListener(alertManager, statistics.DefaultListenerBuffer)
+	}
+
+	socketIds := socket_ids.NewSequentialGenerator(larasocketConfig.Server.NodeId)
+
+	srv := server.NewServer(logger, larasocketConfig.Server, channelManager, socketIds, statsCollector, statsStore, userManager)
 	logger.Info("starting larasockets server", zap.String("port", larasocketConfig.Server.Port))
 
-	if larasocketConfig.Server.TLS {
-		err = http.ListenAndServeTLS(fmt.Sprintf(":%s", larasocketConfig.Server.Port),
-			larasocketConfig.Server.Certificate,
-			larasocketConfig.Server.Key,
-			srv,
-		)
-	} else {
-		err = http.ListenAndServe(fmt.Sprintf(":%s", larasocketConfig.Server.Port), srv)
+	if configAppManager != nil {
+		watchAppsConfig(logger, configAppManager, srv)
 	}
 
-	if err != nil {
-		logger.Fatal("error starting server", zap.String("error", err.Error()))
+	var metricsServer *http.Server
+	if metricsHandler != nil {
+		metricsConfig := larasocketConfig.Server.Metrics
+		if metricsConfig.Port == "" {
+			srv.Mount(metricsConfig.Path, metricsHandler)
+		} else {
+			metricsMux := http.NewServeMux()
+			metricsMux.Handle(metricsConfig.Path, metricsHandler)
+			metricsServer = &http.Server{
+				Addr:    fmt.Sprintf(":%s", metricsConfig.Port),
+				Handler: metricsMux,
+			}
+
+			logger.Info("starting metrics server", zap.String("port", metricsConfig.Port))
+			go func() {
+				if err := metricsServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+					logger.Fatal("error starting metrics server", zap.String("error", err.Error()))
+				}
+			}()
+		}
+	}
+
+	httpServer := &http.Server{
+		Addr:    fmt.Sprintf(":%s", larasocketConfig.Server.Port),
+		Handler: srv,
+	}
+
+	go func() {
+		var err error
+		if larasocketConfig.Server.TLS {
+			err = httpServer.ListenAndServeTLS(larasocketConfig.Server.Certificate, larasocketConfig.Server.Key)
+		} else {
+			err = httpServer.ListenAndServe()
+		}
+
+		if err != nil && err != http.ErrServerClosed {
+			logger.Fatal("error starting server", zap.String("error", err.Error()))
+		}
+	}()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+
+	sig := <-signals
+	logger.Info("shutting down larasockets server", zap.String("signal", sig.String()))
+
+	ctx, cancel := context.WithTimeout(context.Background(), larasocketConfig.Server.Shutdown.Timeout)
+	defer cancel()
+
+	srv.Shutdown(ctx)
+	if err := httpServer.Shutdown(ctx); err != nil {
+		logger.Error("error shutting down http server", zap.String("error", err.Error()))
+	}
+
+	if metricsServer != nil {
+		if err := metricsServer.Shutdown(ctx); err != nil {
+			logger.Error("error shutting down metrics server", zap.String("error", err.Error()))
+		}
+	}
+
+	if rollups != nil {
+		rollups.Stop()
+	}
+
+	if alertManager != nil {
+		alertManager.Stop()
 	}
+
+	statsStore.Close()
+
+	if err := shutdownTracing(ctx); err != nil {
+		logger.Error("error flushing the traces", zap.String("error", err.Error()))
+	}
+
+	logger.Info("larasockets server stopped")
+}
+
+// unmarshalConfig decodes the configuration file. On top of the durations viper understands, it
+// decodes RFC 3339 timestamps such as the expiry of the previous app secrets.
+func unmarshalConfig(larasocketConfig *config.LarasocketsConfig) error {
+	return viper.Unmarshal(larasocketConfig, viper.DecodeHook(mapstructure.ComposeDecodeHookFunc(
+		mapstructure.StringToTimeDurationHookFunc(),
+		mapstructure.StringToTimeHookFunc(time.RFC3339),
+		mapstructure.StringToSliceHookFunc(","),
+	)))
+}
+
+// randomTokenKey returns a random key to sign the dashboard tokens with, for when none is configured.
+func randomTokenKey() (string, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(key), nil
+}
+
+// watchAppsConfig reloads the apps whenever the configuration file changes. Connections of the
+// apps that were removed from the file are closed, an invalid file is logged and ignored.
+func watchAppsConfig(logger *zap.Logger, appManager app_managers.ConfigManager, srv *server.Server) {
+	viper.OnConfigChange(func(event fsnotify.Event) {
+		var larasocketConfig config.LarasocketsConfig
+		if err := unmarshalConfig(&larasocketConfig); err != nil {
+			logger.Error("error unmarshalling changed configuration file, ignoring the change", zap.String("error", err.Error()))
+			return
+		}
+
+		if err := larasocketConfig.Validate(); err != nil {
+			logger.Error("error validating changed configuration file, ignoring the change", zap.String("error", err.Error()))
+			return
+		}
+
+		removedApps := appManager.Reload(larasocketConfig.Apps)
+		for _, appId := range removedApps {
+			// see https://pusher.com/docs/channels/library_auth_reference/pusher-websockets-protocol#error-codes
+			srv.DisconnectApp(appId, 4001, "application does not exist")
+		}
+
+		logger.Info("applications reloaded from the configuration file",
+			zap.String("file", event.Name),
+			zap.Int("applications", len(larasocketConfig.Apps)),
+			zap.Strings("removed_applications", removedApps),
+		)
+	})
+
+	viper.WatchConfig()
 }
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"github.com/iamsayantan/larasockets/config"
+	"github.com/iamsayantan/larasockets/server/handlers"
+	"github.com/iamsayantan/larasockets/statistics"
+	"io"
+	"os"
+	"time"
+)
+
+// runUsage is the usage subcommand, it writes the usage report of an app read from the database,
+// the same report the usage endpoint exports, without starting the server.
+//
+//	larasockets -config /etc/larasockets usage -app 1 -from 2021-06-01 -to 2021-06-30 -format csv
+func runUsage(larasocketConfig config.LarasocketsConfig, args []string, stdout io.Writer) error {
+	flags := flag.NewFlagSet("usage", flag.ContinueOnError)
+	appId := flags.String("app", "", "Id of the application")
+	from := flags.String("from", "", "Start of the report, a date, unix timestamp or RFC 3339 time, the start of the month by default")
+	to := flags.String("to", "", "End of the report, a date includes the whole day, now by default")
+	format := flags.String("format", "csv", "Format of the report, csv or json")
+	output := flags.String("output", "", "File the report is written to instead of the standard output")
+
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if *appId == "" {
+		return errors.New("the -app flag is required")
+	}
+
+	if *format != "csv" && *format != "json" {
+		return errors.New("the format must be either csv or json")
+	}
+
+	if !larasocketConfig.Database.IsSQL() {
+		return errors.New("the usage report needs an sql database driver")
+	}
+
+	startTime, endTime, err := statistics.ParseUsageRange(*from, *to, time.Now())
+	if err != nil {
+		return err
+	}
+
+	db, err := openDatabase(larasocketConfig.Database)
+	if err != nil {
+		return err
+	}
+
+	store, err := newStatsStorage(larasocketConfig.Database, db)
+	if err != nil {
+		return err
+	}
+
+	defer store.Close()
+
+	out := stdout
+	if *output != "" {
+		file, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+
+		defer file.Close()
+		out = file
+	}
+
+	report := statistics.NewUsageReport(store, *appId, startTime, endTime)
+	if *format == "csv" {
+		return report.WriteCSV(out)
+	}
+
+	encoder := json.NewEncoder(out)
+	encoder.SetIndent("", "  ")
+
+	return encoder.Encode(handlers.UsageReportResponse(report))
+}
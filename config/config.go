@@ -1,22 +1,86 @@
 package config
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// Application managers larasockets can load the apps from.
+const (
+	// AppManagerConfig serves the apps listed in the configuration file.
+	AppManagerConfig = "config"
+	// AppManagerDatabase serves the apps stored in the database, they can be changed through
+	// the admin api. The apps listed in the configuration file are created on start up if
+	// they do not exist yet.
+	AppManagerDatabase = "database"
+)
+
+// User managers larasockets can load the dashboard users from.
+const (
+	// UserManagerConfig serves the users listed in the configuration file.
+	UserManagerConfig = "config"
+	// UserManagerDatabase serves the users stored in the database, they can be changed through
+	// the admin api. The users listed in the configuration file are created on start up if
+	// they do not exist yet.
+	UserManagerDatabase = "database"
+)
 
 type LarasocketsConfig struct {
-	Apps     []AppConfig
+	AppManager  string
+	Apps        []AppConfig
+	UserManager string
+	// Users are the dashboard users, see UserManager.
+	Users    []UserConfig
 	Server   ServerConfig
 	Database DatabaseConfig
+	Tracing  TracingConfig
+	Alerting AlertingConfig
 }
 
 func (c LarasocketsConfig) Validate() error {
-	if len(c.Apps) == 0 {
-		return errors.New("at least one application needs to be configured")
+	switch c.AppManager {
+	case AppManagerConfig:
+		if len(c.Apps) == 0 {
+			return errors.New("at least one application needs to be configured")
+		}
+	case AppManagerDatabase:
+		if !c.Database.IsSQL() {
+			return errors.New("the database app manager needs an sql database driver")
+		}
+	default:
+		return fmt.Errorf("unknown app manager %q", c.AppManager)
+	}
+
+	switch c.UserManager {
+	case UserManagerConfig:
+	case UserManagerDatabase:
+		if !c.Database.IsSQL() {
+			return errors.New("the database user manager needs an sql database driver")
+		}
+	default:
+		return fmt.Errorf("unknown user manager %q", c.UserManager)
 	}
 
 	for _, app := range c.Apps {
-		if err := app.validate(); err != nil {
+		if err := app.Validate(); err != nil {
+			return err
+		}
+	}
+
+	usernames := make(map[string]bool)
+	for _, user := range c.Users {
+		if err := user.Validate(); err != nil {
 			return err
 		}
+
+		if usernames[user.Username] {
+			return fmt.Errorf("user %q is defined twice", user.Username)
+		}
+
+		usernames[user.Username] = true
 	}
 
 	if err := c.Server.validate(); err != nil {
@@ -27,6 +91,14 @@ func (c LarasocketsConfig) Validate() error {
 		return err
 	}
 
+	if err := c.Tracing.validate(); err != nil {
+		return err
+	}
+
+	if err := c.Alerting.validate(); err != nil {
+		return err
+	}
+
 	return nil
 }
 
@@ -35,13 +107,15 @@ type AppConfig struct {
 	Name                 string
 	Key                  string
 	Secret               string
+	SecretVersion        string
+	PreviousSecrets      []SecretConfig
 	Capacity             int
 	EnableClientMessages bool
 	EnableStatistics     bool
 	AllowedOrigins       []string
 }
 
-func (a *AppConfig) validate() error {
+func (a *AppConfig) Validate() error {
 	if a.ID == "" {
 		return errors.New("app id can not be empty")
 	}
@@ -54,25 +128,283 @@ func (a *AppConfig) validate() error {
 		return errors.New("application secret can not be empty")
 	}
 
+	for _, previousSecret := range a.PreviousSecrets {
+		if err := previousSecret.validate(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Roles of the dashboard users.
+const (
+	RoleViewer   = "viewer"
+	RoleOperator = "operator"
+	RoleAdmin    = "admin"
+)
+
+// AllApps is the app id of a role that applies to every app.
+const AllApps = "*"
+
+// UserConfig is a dashboard user.
+type UserConfig struct {
+	Username string
+	// PasswordHash is the bcrypt hash of the password, see the hash-password command.
+	PasswordHash string
+	Roles        []UserRoleConfig
+}
+
+// UserRoleConfig grants the user a role for the app, or for every app when App is AllApps.
+type UserRoleConfig struct {
+	App  string
+	Role string
+}
+
+func (u UserConfig) Validate() error {
+	if u.Username == "" {
+		return errors.New("username can not be empty")
+	}
+
+	if u.PasswordHash == "" {
+		return fmt.Errorf("password hash of user %q can not be empty", u.Username)
+	}
+
+	for _, role := range u.Roles {
+		if role.App == "" {
+			return fmt.Errorf("app of a role of user %q can not be empty", u.Username)
+		}
+
+		switch role.Role {
+		case RoleViewer, RoleOperator, RoleAdmin:
+		default:
+			return fmt.Errorf("unknown role %q of user %q", role.Role, u.Username)
+		}
+	}
+
+	return nil
+}
+
+// SecretConfig is a previous secret of an app, which is still accepted until it expires.
+// This lets the clients and backends of an app move to a new secret one by one.
+type SecretConfig struct {
+	// Version identifies the secret in the logs.
+	Version   string
+	Secret    string
+	ExpiresAt time.Time
+}
+
+func (s SecretConfig) validate() error {
+	if s.Secret == "" {
+		return errors.New("previous application secret can not be empty")
+	}
+
+	if s.ExpiresAt.IsZero() {
+		return errors.New("previous application secret must have an expiry")
+	}
+
 	return nil
 }
 
 type ServerConfig struct {
+	// NodeId identifies this server in a cluster, it is the prefix of all the socket ids
+	// issued by the node. A random prefix is used when it is not set.
+	NodeId      uint64
 	Port        string
 	TLS         bool
 	Key         string
 	Certificate string
+	SendQueue   SendQueueConfig
+	Shutdown    ShutdownConfig
+	Admin       AdminConfig
+	Metrics     MetricsConfig
+	Dashboard   DashboardConfig
+	// TrustedProxies are the addresses, or CIDR ranges, of the proxies in front of the server.
+	// The remote address of a connection made through them is read from X-Forwarded-For.
+	TrustedProxies []string
+}
+
+// TrustedProxyNetworks parses the trusted proxies, a single address is a network of its own.
+func (s ServerConfig) TrustedProxyNetworks() ([]*net.IPNet, error) {
+	networks := make([]*net.IPNet, 0, len(s.TrustedProxies))
+	for _, proxy := range s.TrustedProxies {
+		if !strings.Contains(proxy, "/") {
+			ip := net.ParseIP(proxy)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q", proxy)
+			}
+
+			bits := 8 * net.IPv6len
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 8*net.IPv4len
+			}
+
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, network, err := net.ParseCIDR(proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q", proxy)
+		}
+
+		networks = append(networks, network)
+	}
+
+	return networks, nil
+}
+
+// DashboardConfig configures the web dashboard embedded in the binary and the logins to the
+// dashboard api.
+type DashboardConfig struct {
+	Enabled bool
+	// Path is the path the dashboard is served under, it must not clash with the api routes.
+	Path string
+	// TokenKey is the key the access tokens are signed with, it has to be the same on every
+	// node. A random key is used when it is not set, the tokens are invalid after a restart then.
+	TokenKey string
+	// TokenTTL is the time an access token is valid for.
+	TokenTTL time.Duration
+	// AppSecretLogin lets anyone knowing the secret of an app log in as an admin of the app. It
+	// is off by default and only meant to keep the dashboard usable while moving to user
+	// accounts, turn it off again once the users are set up.
+	AppSecretLogin bool
+}
+
+// MetricsConfig configures the prometheus metrics endpoint. The metrics are off by default, they
+// are not authenticated and should be served on a separate Port.
+type MetricsConfig struct {
+	Enabled bool
+	// Path is the path the metrics are served on.
+	Path string
+	// Port makes the metrics available on a separate listener instead of the public one, so
+	// they can be kept out of reach of the clients.
+	Port string
 }
 
+// AdminConfig configures the admin api. The admin api is only served when a token is set.
+type AdminConfig struct {
+	// Token is the bearer token admin requests must be authorized with.
+	Token string
+}
+
+// Policies for a connection whose send queue is full.
+const (
+	// SlowConsumerDropOldest discards the oldest queued message to make room for the new one.
+	SlowConsumerDropOldest = "drop_oldest"
+	// SlowConsumerDropNewest discards the message that did not fit in the queue.
+	SlowConsumerDropNewest = "drop_newest"
+	// SlowConsumerDisconnect closes the connection with SendQueueConfig.CloseCode.
+	SlowConsumerDisconnect = "disconnect"
+)
+
+// SendQueueConfig configures the outbound message queue of every websocket connection.
+type SendQueueConfig struct {
+	// Size is the number of messages that can wait to be written to a connection.
+	Size int
+	// Policy is one of the SlowConsumer* policies, applied when the queue is full.
+	Policy string
+	// CloseCode is the pusher close code used by the disconnect policy, either 4100
+	// (over capacity, reconnect with back off) or 4201 (connection is not responding).
+	CloseCode int
+}
+
+// Database drivers the statistics can be stored with.
+const (
+	// DatabaseDriverNone discards the statistics.
+	DatabaseDriverNone = "none"
+	// DatabaseDriverMemory keeps the latest statistics of every app in memory.
+	DatabaseDriverMemory = "memory"
+	// DatabaseDriverSQLite stores the statistics in the sqlite database file at Path.
+	DatabaseDriverSQLite = "sqlite"
+	// DatabaseDriverMySQL stores the statistics in a mysql database.
+	DatabaseDriverMySQL = "mysql"
+	// DatabaseDriverPostgres stores the statistics in a postgres database.
+	DatabaseDriverPostgres = "postgres"
+)
+
 type DatabaseConfig struct {
+	Driver   string
 	Host     string
 	Port     string
 	Username string
 	Password string
 	Db       string
+	// SSLMode is the sslmode of the postgres connection, it defaults to "disable".
+	SSLMode string
+	// Path is the database file of the sqlite driver.
+	Path string
+	// MemoryCapacity is the number of statistics kept per app by the memory driver.
+	MemoryCapacity int
+	// DumpInterval is the time between two dumps of the collected statistics to the storage,
+	// every dump stores one statistic per active app.
+	DumpInterval time.Duration
+	// Rollup configures the rollups and the retention of the sql drivers.
+	Rollup RollupConfig
+	// WriteBuffer configures how the sql drivers buffer their writes.
+	WriteBuffer WriteBufferConfig
+}
+
+// WriteBufferConfig configures the buffer the statistics are written to an sql database from.
+type WriteBufferConfig struct {
+	// BatchSize is the number of statistics written in one transaction.
+	BatchSize int
+	// FlushInterval is the time between two writes, the buffer is also written once a batch
+	// is full.
+	FlushInterval time.Duration
+	// Capacity is the number of statistics kept in memory while the database is unavailable,
+	// the oldest ones are dropped after that.
+	Capacity int
+	// SpillPath is a file the statistics that could not be written are moved to, so they survive
+	// a restart. The statistics are only kept in memory when it is not set.
+	SpillPath string
+	// SpillCapacity is the number of statistics the spill file can hold.
+	SpillCapacity int
+}
+
+func (w WriteBufferConfig) validate() error {
+	if w.BatchSize <= 0 {
+		return errors.New("statistics write batch size must be greater than zero")
+	}
+
+	if w.FlushInterval <= 0 {
+		return errors.New("statistics write flush interval must be greater than zero")
+	}
+
+	if w.Capacity <= 0 {
+		return errors.New("statistics write buffer capacity must be greater than zero")
+	}
+
+	if w.SpillPath != "" && w.SpillCapacity <= 0 {
+		return errors.New("statistics spill capacity must be greater than zero")
+	}
+
+	return nil
+}
+
+// IsSQL reports whether the driver stores into an sql database, which the database app
+// manager needs as well.
+func (d DatabaseConfig) IsSQL() bool {
+	return d.Driver == DatabaseDriverSQLite || d.Driver == DatabaseDriverMySQL || d.Driver == DatabaseDriverPostgres
 }
 
 func (s ServerConfig) validate() error {
+	if err := s.SendQueue.validate(); err != nil {
+		return err
+	}
+
+	if err := s.Shutdown.validate(); err != nil {
+		return err
+	}
+
+	if err := s.Dashboard.validate(); err != nil {
+		return err
+	}
+
+	if _, err := s.TrustedProxyNetworks(); err != nil {
+		return err
+	}
+
 	if !s.TLS {
 		return nil
 	}
@@ -88,7 +420,93 @@ func (s ServerConfig) validate() error {
 	return nil
 }
 
+func (d DashboardConfig) validate() error {
+	if d.TokenTTL <= 0 {
+		return errors.New("dashboard token ttl must be greater than zero")
+	}
+
+	if !d.Enabled {
+		return nil
+	}
+
+	if !strings.HasPrefix(d.Path, "/") || d.Path == "/" || strings.HasSuffix(d.Path, "/") {
+		return errors.New("dashboard path must start with a slash and can not end with one")
+	}
+
+	for _, reserved := range []string{"/app", "/apps", "/admin", "/dashboard", "/status"} {
+		if d.Path == reserved || strings.HasPrefix(d.Path, reserved+"/") {
+			return fmt.Errorf("dashboard path can not be under %s", reserved)
+		}
+	}
+
+	return nil
+}
+
+// ShutdownConfig configures how the connections are drained when the server is stopped.
+type ShutdownConfig struct {
+	// Timeout is the time the server waits for the connections to drain before it exits.
+	Timeout time.Duration
+	// BatchSize is the number of connections that are asked to reconnect at once.
+	BatchSize int
+	// BatchInterval is the maximum pause between two batches, the actual pause is jittered.
+	BatchInterval time.Duration
+}
+
+func (s ShutdownConfig) validate() error {
+	if s.Timeout <= 0 {
+		return errors.New("shutdown timeout must be greater than zero")
+	}
+
+	if s.BatchSize <= 0 {
+		return errors.New("shutdown batch size must be greater than zero")
+	}
+
+	return nil
+}
+
+func (q SendQueueConfig) validate() error {
+	if q.Size <= 0 {
+		return errors.New("send queue size must be greater than zero")
+	}
+
+	switch q.Policy {
+	case SlowConsumerDropOldest, SlowConsumerDropNewest:
+	case SlowConsumerDisconnect:
+		if q.CloseCode != 4100 && q.CloseCode != 4201 {
+			return errors.New("send queue close code must be either 4100 or 4201")
+		}
+	default:
+		return fmt.Errorf("unknown slow consumer policy %q", q.Policy)
+	}
+
+	return nil
+}
+
 func (d DatabaseConfig) validate() error {
+	if d.DumpInterval < time.Second {
+		return errors.New("statistics dump interval must be at least one second")
+	}
+
+	switch d.Driver {
+	case DatabaseDriverNone:
+		return nil
+	case DatabaseDriverMemory:
+		if d.MemoryCapacity <= 0 {
+			return errors.New("database memory capacity must be greater than zero")
+		}
+
+		return nil
+	case DatabaseDriverSQLite:
+		if d.Path == "" {
+			return errors.New("database path is required")
+		}
+
+		return d.validateSQL()
+	case DatabaseDriverMySQL, DatabaseDriverPostgres:
+	default:
+		return fmt.Errorf("unknown database driver %q", d.Driver)
+	}
+
 	if d.Host == "" {
 		return errors.New("database host is required")
 	}
@@ -101,5 +519,170 @@ func (d DatabaseConfig) validate() error {
 		return errors.New("database name is required")
 	}
 
+	return d.validateSQL()
+}
+
+func (d DatabaseConfig) validateSQL() error {
+	if err := d.Rollup.validate(); err != nil {
+		return err
+	}
+
+	return d.WriteBuffer.validate()
+}
+
+// RollupConfig configures how the statistics stored in an sql database are aggregated into
+// minute, hour and day rollups and how long every resolution is kept.
+type RollupConfig struct {
+	// Interval is the time between two runs of the rollups and the pruning.
+	Interval  time.Duration
+	Retention RetentionConfig
+}
+
+// RetentionConfig is the time the statistics of every resolution are kept, the raw statistics
+// are the ones stored every few seconds. A retention of zero keeps the statistics forever.
+type RetentionConfig struct {
+	Raw    time.Duration
+	Minute time.Duration
+	Hour   time.Duration
+	Day    time.Duration
+}
+
+func (r RollupConfig) validate() error {
+	if r.Interval <= 0 {
+		return errors.New("statistics rollup interval must be greater than zero")
+	}
+
+	if r.Retention.Raw < 0 || r.Retention.Minute < 0 || r.Retention.Hour < 0 || r.Retention.Day < 0 {
+		return errors.New("statistics retention can not be negative")
+	}
+
+	return nil
+}
+
+// Exporters the traces can be sent to.
+const (
+	// TracingExporterNone disables tracing.
+	TracingExporterNone = "none"
+	// TracingExporterStdout writes the spans to the standard output, meant for local testing.
+	TracingExporterStdout = "stdout"
+	// TracingExporterFile writes the spans to the file at Path, meant for local testing.
+	TracingExporterFile = "file"
+	// TracingExporterOTLP sends the spans to an OpenTelemetry collector over OTLP/HTTP.
+	TracingExporterOTLP = "otlp"
+)
+
+// TracingConfig configures the OpenTelemetry tracing of the events triggered through the api.
+type TracingConfig struct {
+	Exporter    string
+	ServiceName string
+	// Endpoint is the host and port of the OTLP collector. When it is empty the standard
+	// OTEL_EXPORTER_OTLP_* environment variables are used.
+	Endpoint string
+	// Insecure sends the spans to the OTLP collector without TLS.
+	Insecure bool
+	// Path is the file the spans are written to by the file exporter.
+	Path string
+	// SampleRatio is the fraction of the traces started by larasockets that are recorded. Traces
+	// continued from an incoming request follow the sampling decision of the caller.
+	SampleRatio float64
+}
+
+func (t TracingConfig) validate() error {
+	switch t.Exporter {
+	case TracingExporterNone, TracingExporterStdout, TracingExporterOTLP:
+	case TracingExporterFile:
+		if t.Path == "" {
+			return errors.New("the file tracing exporter needs a path")
+		}
+	default:
+		return fmt.Errorf("unknown tracing exporter %q", t.Exporter)
+	}
+
+	if t.SampleRatio < 0 || t.SampleRatio > 1 {
+		return errors.New("tracing sample ratio must be between 0 and 1")
+	}
+
+	return nil
+}
+
+// Metrics of the statistics alert rules can watch. The rates are per minute, averaged over a dump
+// interval of the statistics.
+const (
+	AlertMetricConcurrentConnections = "concurrent_connections"
+	// AlertMetricCapacityPercent is the concurrent connections in percent of the capacity of the
+	// app, it is never above the threshold for apps without a capacity.
+	AlertMetricCapacityPercent            = "capacity_percent"
+	AlertMetricApiMessagesPerMinute       = "api_messages_per_minute"
+	AlertMetricWebsocketMessagesPerMinute = "websocket_messages_per_minute"
+	AlertMetricDroppedMessagesPerMinute   = "dropped_messages_per_minute"
+	// AlertMetricWebhookFailures is the number of failed attempts to deliver an alert webhook of
+	// the app within a dump interval.
+	AlertMetricWebhookFailures    = "webhook_failures"
+	AlertMetricPeakSendQueueDepth = "peak_send_queue_depth"
+	AlertMetricDeliveryLatencyP99 = "delivery_latency_p99_ms"
+)
+
+// AlertingConfig configures the alerts raised when the statistics of an app cross a threshold.
+type AlertingConfig struct {
+	Rules   []AlertRuleConfig
+	Webhook AlertWebhookConfig
+}
+
+// AlertRuleConfig is an alert that fires when Metric is above Threshold and resolves once it is
+// back at or below it.
+type AlertRuleConfig struct {
+	Name string
+	// Apps are the ids of the apps the rule applies to, every app when it is empty.
+	Apps      []string
+	Metric    string
+	Threshold float64
+	// For is the time the metric has to stay above the threshold before the alert fires, so a
+	// single spike does not raise an alert.
+	For time.Duration
+}
+
+// AlertWebhookConfig configures the http endpoint the alerts are posted to. The body is signed
+// with Secret, the hex encoded HMAC-SHA256 is sent in the X-Larasockets-Signature header.
+type AlertWebhookConfig struct {
+	// URL is the endpoint, the alerts are only sent to the dashboard channels when it is empty.
+	URL     string
+	Secret  string
+	Timeout time.Duration
+}
+
+func (a AlertingConfig) validate() error {
+	names := make(map[string]bool)
+	for _, rule := range a.Rules {
+		if rule.Name == "" {
+			return errors.New("alert rule name can not be empty")
+		}
+
+		if names[rule.Name] {
+			return fmt.Errorf("alert rule %q is defined twice", rule.Name)
+		}
+
+		names[rule.Name] = true
+
+		switch rule.Metric {
+		case AlertMetricConcurrentConnections, AlertMetricCapacityPercent, AlertMetricApiMessagesPerMinute,
+			AlertMetricWebsocketMessagesPerMinute, AlertMetricDroppedMessagesPerMinute, AlertMetricWebhookFailures,
+			AlertMetricPeakSendQueueDepth, AlertMetricDeliveryLatencyP99:
+		default:
+			return fmt.Errorf("unknown metric %q of alert rule %q", rule.Metric, rule.Name)
+		}
+
+		if rule.For < 0 {
+			return fmt.Errorf("the duration of alert rule %q can not be negative", rule.Name)
+		}
+	}
+
+	if a.Webhook.URL != "" && a.Webhook.Secret == "" {
+		return errors.New("alert webhook secret is required")
+	}
+
+	if a.Webhook.URL != "" && a.Webhook.Timeout <= 0 {
+		return errors.New("alert webhook timeout must be greater than zero")
+	}
+
 	return nil
 }
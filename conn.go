@@ -1,5 +1,10 @@
 package larasockets
 
+import (
+	"context"
+	"time"
+)
+
 // Connection interface defines the method for an individual connection to the server
 type Connection interface {
 	// Id returns the unique identifier for the particular
@@ -9,7 +14,8 @@ type Connection interface {
 	// App returns the application the connection was made for
 	App() *Application
 
-	// Send will send the given data back to the client
+	// Send will send the given data back to the client. The data can also be an
+	// *EncodedMessage, in which case it is sent without being serialized again.
 	Send(data interface{})
 
 	// Receive reads the data from the connection
@@ -17,4 +23,44 @@ type Connection interface {
 
 	// Close closes the current connection
 	Close()
+
+	// Disconnect sends a pusher error with the given code to the client, waits until the
+	// queued messages are written or ctx is done and then closes the connection with the
+	// same code.
+	Disconnect(ctx context.Context, code int, message string)
+
+	// Info returns the metadata of the connection and its traffic so far. The channels of the
+	// connection are not included, they are known to the channel manager.
+	Info() ConnectionInfo
+}
+
+// ConnectionInfo describes a connection, it is shown to the support engineers inspecting the
+// connections of an app.
+type ConnectionInfo struct {
+	Id    string
+	AppId string
+	// RemoteIP is the address of the client, read from X-Forwarded-For behind trusted proxies.
+	RemoteIP  string
+	UserAgent string
+	Origin    string
+	// Client, ClientVersion and Protocol are the client library as reported by the query of
+	// the websocket url, e.g. ?protocol=7&client=js&version=7.0.3.
+	Client        string
+	ClientVersion string
+	Protocol      string
+	ConnectedAt   time.Time
+	// LastActivityAt is the last time a message or a pong was received from the client.
+	LastActivityAt   time.Time
+	MessagesReceived int
+	MessagesSent     int
+	BytesReceived    int
+	BytesSent        int
+	Channels         []string
+}
+
+// SocketIdGenerator mints the socket id of every new connection. Socket ids must be unique
+// across the whole deployment and follow the "<digits>.<digits>" format of pusher.
+type SocketIdGenerator interface {
+	// Generate returns a new socket id.
+	Generate() string
 }
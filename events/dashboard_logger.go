@@ -1,6 +1,7 @@
 package events
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"github.com/iamsayantan/larasockets"
@@ -28,22 +29,28 @@ type DashboardLogDetails struct {
 	EventPayload string
 }
 
+// DashboardLogEntry is an entry of the dashboard log of an app.
+type DashboardLogEntry struct {
+	Type         EventType `json:"type"`
+	Time         int64     `json:"time"`
+	EventName    string    `json:"event_name"`
+	ChannelName  string    `json:"channel_name"`
+	ConnectionId string    `json:"connection_id"`
+	Payload      string    `json:"payload"`
+}
+
+// DashboardLogChannel returns the name of the channel the log of the app is broadcast on.
+func DashboardLogChannel(appId string) string {
+	return fmt.Sprintf("private-websockets-dashboard-%s", appId)
+}
+
+// LogEvent broadcasts the event to the dashboard log of the app and keeps it in RecentLogs.
 func LogEvent(cm larasockets.ChannelManager, eventType EventType, details DashboardLogDetails) {
 	if details.AppId == "" {
 		return
 	}
 
-	dashboardLogChannelName := fmt.Sprintf("private-websockets-dashboard-%s", details.AppId)
-	logChannel := cm.FindOrCreateChannel(details.AppId, dashboardLogChannelName)
-
-	logEventPayload := struct {
-		Type         EventType `json:"type"`
-		Time         int64     `json:"time"`
-		EventName    string    `json:"event_name"`
-		ChannelName  string    `json:"channel_name"`
-		ConnectionId string    `json:"connection_id"`
-		Payload      string    `json:"payload"`
-	}{
+	entry := DashboardLogEntry{
 		Type:         eventType,
 		Time:         time.Now().Unix(),
 		EventName:    details.EventName,
@@ -52,16 +59,52 @@ func LogEvent(cm larasockets.ChannelManager, eventType EventType, details Dashbo
 		Payload:      details.EventPayload,
 	}
 
-	payloadData, err := json.Marshal(logEventPayload)
+	dashboardLogChannelName := DashboardLogChannel(details.AppId)
+	msg, err := logMessage(dashboardLogChannelName, entry)
+
+	// the entry is broadcast before the next one is kept, a dashboard joining the log gets every
+	// entry once, either replayed by SubscribeToLog or live.
+	RecentLogs.Add(details.AppId, entry, func() {
+		if err != nil {
+			return
+		}
+
+		cm.FindOrCreateChannel(details.AppId, dashboardLogChannelName).Broadcast(context.Background(), msg)
+	})
+}
+
+// SubscribeToLog subscribes the connection to the dashboard log of the app with subscribe and
+// then sends it the latest entries of the log, so the dashboard does not start out empty. No
+// entry is logged in between, the live entries only arrive after the replayed ones.
+func SubscribeToLog(conn larasockets.Connection, appId string, subscribe func() error) error {
+	var err error
+	filter := LogFilter{Limit: DashboardLogReplaySize}
+	RecentLogs.Replay(appId, filter, func(entries []DashboardLogEntry) {
+		if err = subscribe(); err != nil {
+			return
+		}
+
+		for _, entry := range entries {
+			if msg, err := logMessage(DashboardLogChannel(appId), entry); err == nil {
+				conn.Send(msg)
+			}
+		}
+	})
+
+	return err
+}
+
+func logMessage(channelName string, entry DashboardLogEntry) (messages.PusherEventPayload, error) {
+	payloadData, err := json.Marshal(entry)
 	if err != nil {
-		return
+		return messages.PusherEventPayload{}, err
 	}
 
 	msg := messages.PusherEventPayload{
 		Event:   "log",
-		Channel: dashboardLogChannelName,
+		Channel: channelName,
 		Data:    string(payloadData),
 	}
 
-	logChannel.Broadcast(msg)
+	return msg, nil
 }
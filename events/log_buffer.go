@@ -0,0 +1,152 @@
+package events
+
+import "sync"
+
+const (
+	// DashboardLogSize is the number of log entries kept per app, the oldest entries are
+	// overwritten after that.
+	DashboardLogSize = 1000
+	// DashboardLogReplaySize is the number of entries replayed to a dashboard joining the log.
+	DashboardLogReplaySize = 100
+)
+
+// RecentLogs keeps the latest dashboard log entries of every app, so they can still be looked at
+// when nobody was watching the live log.
+var RecentLogs = NewLogBuffer(DashboardLogSize)
+
+// LogFilter selects the entries of a dashboard log. Empty fields match every entry, a Limit of
+// zero returns all the matching entries.
+type LogFilter struct {
+	Type         EventType
+	ChannelName  string
+	ConnectionId string
+	Limit        int
+}
+
+func (f LogFilter) matches(entry DashboardLogEntry) bool {
+	return (f.Type == "" || entry.Type == f.Type) &&
+		(f.ChannelName == "" || entry.ChannelName == f.ChannelName) &&
+		(f.ConnectionId == "" || entry.ConnectionId == f.ConnectionId)
+}
+
+// LogBuffer is a ring buffer of the dashboard log entries per app.
+type LogBuffer struct {
+	capacity int
+
+	mu   sync.RWMutex
+	apps map[string]*logRing
+}
+
+type logRing struct {
+	mu      sync.Mutex
+	entries []DashboardLogEntry
+	// next is the index the next entry is written to once the ring is full.
+	next int
+}
+
+func NewLogBuffer(capacity int) *LogBuffer {
+	return &LogBuffer{capacity: capacity, apps: make(map[string]*logRing)}
+}
+
+// Add appends the entry to the log of the app, replacing the oldest entry when the log is full.
+// publish, if not nil, is called before the next entry can be added or the log is replayed, so
+// the entries are published in the order of the log.
+func (b *LogBuffer) Add(appId string, entry DashboardLogEntry, publish func()) {
+	ring := b.ring(appId)
+
+	ring.mu.Lock()
+	defer ring.mu.Unlock()
+
+	if len(ring.entries) < b.capacity {
+		ring.entries = append(ring.entries, entry)
+	} else {
+		ring.entries[ring.next] = entry
+		ring.next = (ring.next + 1) % b.capacity
+	}
+
+	if publish != nil {
+		publish()
+	}
+}
+
+// Find returns the latest entries of the app that match the filter, the oldest first.
+func (b *LogBuffer) Find(appId string, filter LogFilter) []DashboardLogEntry {
+	b.mu.RLock()
+	ring, ok := b.apps[appId]
+	b.mu.RUnlock()
+
+	if !ok {
+		return make([]DashboardLogEntry, 0)
+	}
+
+	ring.mu.Lock()
+	defer ring.mu.Unlock()
+
+	return ring.find(filter)
+}
+
+// Replay calls replay with the entries Find returns for the filter. No entry is added to the log
+// of the app until replay returns, so whatever replay starts listening to the log from then on
+// only sees the entries that come after the replayed ones.
+func (b *LogBuffer) Replay(appId string, filter LogFilter, replay func(entries []DashboardLogEntry)) {
+	ring := b.ring(appId)
+
+	ring.mu.Lock()
+	defer ring.mu.Unlock()
+
+	replay(ring.find(filter))
+}
+
+// find must be called with the lock of the ring held.
+func (ring *logRing) find(filter LogFilter) []DashboardLogEntry {
+	found := make([]DashboardLogEntry, 0)
+
+	// walk the ring from the newest entry back, so the limit keeps the latest entries.
+	size := len(ring.entries)
+	for i := 0; i < size; i++ {
+		entry := ring.entries[(ring.next-1-i+2*size)%size]
+		if !filter.matches(entry) {
+			continue
+		}
+
+		found = append(found, entry)
+		if filter.Limit > 0 && len(found) == filter.Limit {
+			break
+		}
+	}
+
+	for i, j := 0, len(found)-1; i < j; i, j = i+1, j-1 {
+		found[i], found[j] = found[j], found[i]
+	}
+
+	return found
+}
+
+// Forget removes the log of the app.
+func (b *LogBuffer) Forget(appId string) {
+	b.mu.Lock()
+	delete(b.apps, appId)
+	b.mu.Unlock()
+}
+
+func (b *LogBuffer) ring(appId string) *logRing {
+	b.mu.RLock()
+	ring, ok := b.apps[appId]
+	b.mu.RUnlock()
+
+	if ok {
+		return ring
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if ring, ok := b.apps[appId]; ok {
+		return ring
+	}
+
+	ring = &logRing{entries: make([]DashboardLogEntry, 0)}
+	b.apps[appId] = ring
+
+	return ring
+}
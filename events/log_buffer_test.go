@@ -0,0 +1,113 @@
+package events
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func addEntries(b *LogBuffer, appId string, from, to int) {
+	for i := from; i <= to; i++ {
+		b.Add(appId, DashboardLogEntry{Type: ApiMessage, EventName: fmt.Sprintf("%d", i)}, nil)
+	}
+}
+
+func assertEntries(t *testing.T, entries []DashboardLogEntry, expected ...string) {
+	t.Helper()
+
+	names := make([]string, len(entries))
+	for i, entry := range entries {
+		names[i] = entry.EventName
+	}
+
+	if fmt.Sprint(names) != fmt.Sprint(expected) {
+		t.Fatalf("expected the entries %v, got %v", expected, names)
+	}
+}
+
+func TestLogBufferFindWrapsAround(t *testing.T) {
+	tests := []struct {
+		added    int
+		expected []string
+	}{
+		{added: 0, expected: []string{}},
+		{added: 2, expected: []string{"1", "2"}},
+		{added: 4, expected: []string{"1", "2", "3", "4"}},
+		{added: 5, expected: []string{"2", "3", "4", "5"}},
+		{added: 7, expected: []string{"4", "5", "6", "7"}},
+		{added: 8, expected: []string{"5", "6", "7", "8"}},
+		{added: 11, expected: []string{"8", "9", "10", "11"}},
+	}
+
+	for _, tt := range tests {
+		b := NewLogBuffer(4)
+		addEntries(b, "1", 1, tt.added)
+
+		assertEntries(t, b.Find("1", LogFilter{}), tt.expected...)
+	}
+}
+
+func TestLogBufferFindLimitKeepsTheLatest(t *testing.T) {
+	b := NewLogBuffer(4)
+	addEntries(b, "1", 1, 6)
+
+	assertEntries(t, b.Find("1", LogFilter{Limit: 2}), "5", "6")
+	assertEntries(t, b.Find("1", LogFilter{Limit: 4}), "3", "4", "5", "6")
+	assertEntries(t, b.Find("1", LogFilter{Limit: 10}), "3", "4", "5", "6")
+}
+
+func TestLogBufferFindFilters(t *testing.T) {
+	b := NewLogBuffer(10)
+	b.Add("1", DashboardLogEntry{Type: Connected, EventName: "a", ConnectionId: "1.1"}, nil)
+	b.Add("1", DashboardLogEntry{Type: Subscribed, EventName: "b", ChannelName: "one", ConnectionId: "1.1"}, nil)
+	b.Add("1", DashboardLogEntry{Type: Subscribed, EventName: "c", ChannelName: "two", ConnectionId: "2.2"}, nil)
+	b.Add("1", DashboardLogEntry{Type: ApiMessage, EventName: "d", ChannelName: "one"}, nil)
+	b.Add("1", DashboardLogEntry{Type: Subscribed, EventName: "e", ChannelName: "one", ConnectionId: "2.2"}, nil)
+	b.Add("2", DashboardLogEntry{Type: Subscribed, EventName: "f", ChannelName: "one", ConnectionId: "1.1"}, nil)
+
+	tests := []struct {
+		filter   LogFilter
+		expected []string
+	}{
+		{filter: LogFilter{Type: Subscribed}, expected: []string{"b", "c", "e"}},
+		{filter: LogFilter{ChannelName: "one"}, expected: []string{"b", "d", "e"}},
+		{filter: LogFilter{ConnectionId: "1.1"}, expected: []string{"a", "b"}},
+		{filter: LogFilter{Type: Subscribed, ChannelName: "one"}, expected: []string{"b", "e"}},
+		{filter: LogFilter{Type: Subscribed, ChannelName: "one", Limit: 1}, expected: []string{"e"}},
+		{filter: LogFilter{Type: Vacated}, expected: []string{}},
+	}
+
+	for _, tt := range tests {
+		assertEntries(t, b.Find("1", tt.filter), tt.expected...)
+	}
+
+	assertEntries(t, b.Find("3", LogFilter{}))
+
+	b.Forget("1")
+	assertEntries(t, b.Find("1", LogFilter{}))
+	assertEntries(t, b.Find("2", LogFilter{}), "f")
+}
+
+func TestLogBufferReplayHoldsTheLog(t *testing.T) {
+	b := NewLogBuffer(4)
+	addEntries(b, "1", 1, 2)
+
+	added := make(chan struct{})
+	b.Replay("1", LogFilter{}, func(entries []DashboardLogEntry) {
+		assertEntries(t, entries, "1", "2")
+
+		go func() {
+			defer close(added)
+			b.Add("1", DashboardLogEntry{EventName: "3"}, func() {})
+		}()
+
+		select {
+		case <-added:
+			t.Fatal("expected the entry to wait for the replay")
+		case <-time.After(50 * time.Millisecond):
+		}
+	})
+
+	<-added
+	assertEntries(t, b.Find("1", LogFilter{}), "1", "2", "3")
+}
@@ -1,7 +1,95 @@
 package larasockets
 
+import (
+	"context"
+	"encoding/json"
+	"github.com/gorilla/websocket"
+	"sync"
+	"time"
+)
+
 // PusherMessage interface defines a single method Respond which must be implemented
 // by all the messages coming from client.
 type PusherMessage interface {
-	Respond()
+	Respond() error
+}
+
+// EncodedMessage is an outgoing message that has already been serialized to json. The same
+// EncodedMessage can be handed to any number of connections, so a broadcast marshals the
+// payload only once no matter how many subscribers the channel has.
+type EncodedMessage struct {
+	payload []byte
+	// ctx carries the trace and the trigger time of the request the message was sent for, if any.
+	ctx context.Context
+
+	prepareOnce sync.Once
+	prepared    *websocket.PreparedMessage
+}
+
+// NewEncodedMessage serializes data to json. If data is already an *EncodedMessage it is
+// returned as it is.
+func NewEncodedMessage(data interface{}) (*EncodedMessage, error) {
+	if message, ok := data.(*EncodedMessage); ok {
+		return message, nil
+	}
+
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+
+	return &EncodedMessage{payload: payload}, nil
+}
+
+// Payload returns the serialized message. The returned slice is shared between all the
+// connections the message is sent to and must not be modified.
+func (m *EncodedMessage) Payload() []byte {
+	return m.payload
+}
+
+// Prepare builds the websocket frame for the message up front, so connections writing the
+// message do not have to frame it again. It is meant for messages sent to many connections.
+func (m *EncodedMessage) Prepare() error {
+	var err error
+	m.prepareOnce.Do(func() {
+		m.prepared, err = websocket.NewPreparedMessage(websocket.TextMessage, m.payload)
+	})
+
+	return err
+}
+
+// PreparedFrame returns the prepared websocket frame of the message, or nil if Prepare
+// was not called.
+func (m *EncodedMessage) PreparedFrame() *websocket.PreparedMessage {
+	return m.prepared
+}
+
+// SetContext attaches the context of the request the message is sent for, so the connections
+// writing the message can continue its trace and measure its delivery latency. It must be called
+// before the message is sent.
+func (m *EncodedMessage) SetContext(ctx context.Context) {
+	m.ctx = ctx
+}
+
+// Context returns the context attached with SetContext, or an empty context.
+func (m *EncodedMessage) Context() context.Context {
+	if m.ctx == nil {
+		return context.Background()
+	}
+
+	return m.ctx
+}
+
+type triggerTimeKey struct{}
+
+// ContextWithTriggerTime returns a copy of ctx that carries the time the api request that triggers
+// the messages was received.
+func ContextWithTriggerTime(ctx context.Context, t time.Time) context.Context {
+	return context.WithValue(ctx, triggerTimeKey{}, t)
+}
+
+// TriggerTimeFromContext returns the time set by ContextWithTriggerTime.
+func TriggerTimeFromContext(ctx context.Context) (time.Time, bool) {
+	t, ok := ctx.Value(triggerTimeKey{}).(time.Time)
+	return t, ok
 }
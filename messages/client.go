@@ -8,8 +8,9 @@ type pusherChannelClientMessage struct {
 	payload        PusherIncomingMessagePayload
 }
 
-func (p *pusherChannelClientMessage) Respond() {
+func (p *pusherChannelClientMessage) Respond() error {
 	//panic("implement me")
+	return nil
 }
 
 func newPusherClientMessage(conn larasockets.Connection, cm larasockets.ChannelManager, payload PusherIncomingMessagePayload) larasockets.PusherMessage {
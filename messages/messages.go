@@ -10,6 +10,8 @@ import (
 type PusherIncomingMessagePayload struct {
 	Event string          `json:"event"`
 	Data  json.RawMessage `json:"data"`
+	// Channel is the channel a client event is sent to, it is empty for the protocol messages.
+	Channel string `json:"channel,omitempty"`
 }
 
 type PusherOutgoingMessagePayload struct {
@@ -10,7 +10,7 @@ type pusherChannelProtocolMessage struct {
 	pusherChannelClientMessage
 }
 
-func (p *pusherChannelProtocolMessage) Respond() {
+func (p *pusherChannelProtocolMessage) Respond() error {
 	payload := p.payload
 
 	events := strings.SplitAfter(payload.Event, ":")
@@ -18,22 +18,24 @@ func (p *pusherChannelProtocolMessage) Respond() {
 
 	switch eventName {
 	case "subscribe":
-		p.handleSubscription()
+		return p.handleSubscription()
 	case "unsubscribe":
 		p.handleUnSubscribe()
 	case "ping":
 		p.handlePing()
 	}
+
+	return nil
 }
 
-func (p *pusherChannelProtocolMessage) handleSubscription() {
+func (p *pusherChannelProtocolMessage) handleSubscription() error {
 	var payload PusherSubscriptionPayload
 	err := json.Unmarshal(p.payload.Data, &payload)
 	if err != nil {
-		return
+		return &larasockets.SubscriptionError{Reason: "invalid_payload", Message: err.Error()}
 	}
 
-	p.channelManager.SubscribeToChannel(p.connection, payload.Channel, payload)
+	return p.channelManager.SubscribeToChannel(p.connection, payload.Channel, payload)
 }
 
 func (p *pusherChannelProtocolMessage) handleUnSubscribe() {
@@ -2,15 +2,22 @@ package server
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"github.com/gorilla/websocket"
 	"github.com/iamsayantan/larasockets"
+	"github.com/iamsayantan/larasockets/config"
 	"github.com/iamsayantan/larasockets/events"
 	"github.com/iamsayantan/larasockets/messages"
 	"github.com/iamsayantan/larasockets/statistics"
+	"github.com/iamsayantan/larasockets/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
-	"math/rand"
-	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -35,6 +42,14 @@ var (
 
 // Connection encapsulates each incoming connection to our server.
 type Connection struct {
+	// the traffic of the connection and its last activity, in unix nanoseconds, are accessed
+	// atomically and are kept first so they are aligned on 32 bit platforms.
+	lastActivity     int64
+	messagesReceived int64
+	messagesSent     int64
+	bytesReceived    int64
+	bytesSent        int64
+
 	// id is the unique identifier for this connection.
 	id string
 	// app represents the application to which connection was made.
@@ -45,24 +60,48 @@ type Connection struct {
 	websocketConn *websocket.Conn
 	hub           *Hub
 
-	// channel for outbound messages
-	sendCh chan []byte
+	// sendCh is the bounded queue of outbound messages, sendQueue decides what happens
+	// when a message does not fit in it.
+	sendCh    chan *larasockets.EncodedMessage
+	sendQueue config.SendQueueConfig
 	// closeCh will be closed when the connection closes
-	closeCh chan bool
+	closeCh   chan struct{}
+	closeOnce sync.Once
+	// slowConsumerOnce makes sure a slow consumer is only disconnected once.
+	slowConsumerOnce sync.Once
+	// drainCh is closed by Disconnect, the write pump then writes the messages left in the
+	// queue followed by disconnectMessage and a close frame with closeCode. Both are set
+	// before drainCh is closed.
+	drainCh           chan struct{}
+	drainOnce         sync.Once
+	disconnectMessage *larasockets.EncodedMessage
+	closeCode         int32
+
+	// info is the metadata of the connection, it does not change once the connection is made.
+	info larasockets.ConnectionInfo
 }
 
-// NewConnection generates a new Connection instance from the raw websocket connection.
-func NewConnection(hub *Hub, app *larasockets.Application, conn *websocket.Conn, collector statistics.StatsCollector, logger *zap.Logger) larasockets.Connection {
-	connId := generateIdForConnection()
+// NewConnection generates a new Connection instance from the raw websocket connection, info holds
+// the metadata of the request the connection was made with.
+func NewConnection(hub *Hub, app *larasockets.Application, conn *websocket.Conn, info larasockets.ConnectionInfo, sendQueue config.SendQueueConfig, collector statistics.StatsCollector, logger *zap.Logger) larasockets.Connection {
+	connId := hub.socketIds.Generate()
+	info.Id = connId
+	info.AppId = app.Id()
+	info.ConnectedAt = time.Now()
+
 	newConn := &Connection{
+		lastActivity:  info.ConnectedAt.UnixNano(),
+		info:          info,
 		id:            connId,
 		app:           app,
 		hub:           hub,
 		collector:     collector,
 		websocketConn: conn,
 		logger:        logger.With(zap.String("application_id", app.Id()), zap.String("connection_id", connId)),
-		sendCh:        make(chan []byte),
-		closeCh:       make(chan bool),
+		sendCh:        make(chan *larasockets.EncodedMessage, sendQueue.Size),
+		sendQueue:     sendQueue,
+		closeCh:       make(chan struct{}),
+		drainCh:       make(chan struct{}),
 	}
 
 	go newConn.Receive()
@@ -84,8 +123,19 @@ func (c *Connection) Id() string {
 	return c.id
 }
 
+func (c *Connection) Info() larasockets.ConnectionInfo {
+	info := c.info
+	info.LastActivityAt = time.Unix(0, atomic.LoadInt64(&c.lastActivity))
+	info.MessagesReceived = int(atomic.LoadInt64(&c.messagesReceived))
+	info.MessagesSent = int(atomic.LoadInt64(&c.messagesSent))
+	info.BytesReceived = int(atomic.LoadInt64(&c.bytesReceived))
+	info.BytesSent = int(atomic.LoadInt64(&c.bytesSent))
+
+	return info
+}
+
 func (c *Connection) Send(data interface{}) {
-	message, err := json.Marshal(data)
+	message, err := larasockets.NewEncodedMessage(data)
 	if err != nil {
 		c.logger.Error("error marshaling data to json",
 			zap.String("error", err.Error()),
@@ -93,8 +143,64 @@ func (c *Connection) Send(data interface{}) {
 		return
 	}
 
-	c.collector.HandleWebsocketMessage(c.App().Id())
-	c.sendCh <- message
+	c.enqueue(message)
+}
+
+// enqueue puts the message in the send queue without ever blocking the caller, which is
+// usually broadcasting to many other connections as well. When the queue is full the
+// configured slow consumer policy is applied. Messages sent once Disconnect was called are
+// discarded, the connection is closing anyway.
+func (c *Connection) enqueue(message *larasockets.EncodedMessage) {
+	for {
+		select {
+		case <-c.closeCh:
+			return
+		case <-c.drainCh:
+			return
+		default:
+		}
+
+		select {
+		case c.sendCh <- message:
+			return
+		default:
+		}
+
+		switch c.sendQueue.Policy {
+		case config.SlowConsumerDropNewest:
+			c.collector.HandleDroppedMessage(c.App().Id())
+			return
+		case config.SlowConsumerDisconnect:
+			c.collector.HandleDroppedMessage(c.App().Id())
+			c.slowConsumerOnce.Do(func() {
+				// the close frame waits for the message the write pump is writing, which must
+				// not hold up the caller.
+				go c.disconnectSlowConsumer()
+			})
+			return
+		default:
+			// drop the oldest message and try again, the queue might already have been drained
+			// by the write pump in the meantime.
+			select {
+			case <-c.sendCh:
+				c.collector.HandleDroppedMessage(c.App().Id())
+			default:
+			}
+		}
+	}
+}
+
+// disconnectSlowConsumer closes a connection that can not keep up with its messages. The close
+// frame is a control message, so it is written in between the messages of the write pump.
+func (c *Connection) disconnectSlowConsumer() {
+	c.logger.Warn("send queue is full, disconnecting slow consumer",
+		zap.Int("close_code", c.sendQueue.CloseCode),
+	)
+
+	closeMessage := websocket.FormatCloseMessage(c.sendQueue.CloseCode, "send queue is full")
+	_ = c.websocketConn.WriteControl(websocket.CloseMessage, closeMessage, time.Now().Add(writeWait))
+
+	c.Close()
 }
 
 func (c *Connection) Receive() {
@@ -106,6 +212,7 @@ func (c *Connection) Receive() {
 	_ = c.websocketConn.SetReadDeadline(time.Now().Add(pongWait))
 
 	c.websocketConn.SetPongHandler(func(string) error {
+		atomic.StoreInt64(&c.lastActivity, time.Now().UnixNano())
 		_ = c.websocketConn.SetReadDeadline(time.Now().Add(pongWait))
 		return nil
 	})
@@ -127,6 +234,10 @@ func (c *Connection) Receive() {
 			return
 		}
 
+		atomic.StoreInt64(&c.lastActivity, time.Now().UnixNano())
+		atomic.AddInt64(&c.messagesReceived, 1)
+		atomic.AddInt64(&c.bytesReceived, int64(len(message)))
+
 		message = bytes.TrimSpace(bytes.Replace(message, newline, space, -1))
 		err = json.Unmarshal(message, &pusherMessagePayload)
 		if err != nil {
@@ -139,9 +250,39 @@ func (c *Connection) Receive() {
 			zap.String("event", pusherMessagePayload.Event),
 		)
 
-		c.collector.HandleWebsocketMessage(c.App().Id())
+		c.collector.HandleIncomingWebsocketMessage(c.App().Id(), len(message))
+		if strings.HasPrefix(pusherMessagePayload.Event, "client-") {
+			// the data of client events is usually a json encoded string, it is logged decoded.
+			eventPayload := string(pusherMessagePayload.Data)
+			_ = json.Unmarshal(pusherMessagePayload.Data, &eventPayload)
+
+			events.LogEvent(c.hub.channelManger, events.WebsocketMessage, events.DashboardLogDetails{
+				AppId:        c.App().Id(),
+				ChannelName:  pusherMessagePayload.Channel,
+				EventName:    pusherMessagePayload.Event,
+				ConnectionId: c.Id(),
+				EventPayload: eventPayload,
+			})
+
+			subscribers := 0
+			if channel := c.hub.channelManger.FindChannel(c.App().Id(), pusherMessagePayload.Channel); channel != nil {
+				subscribers = len(channel.Connections())
+			}
+
+			c.collector.HandleChannelMessage(c.App().Id(), pusherMessagePayload.Channel, pusherMessagePayload.Event, len(eventPayload), subscribers)
+		}
+
 		pusherMessage := messages.NewPusherMessage(c, c.hub.channelManger, pusherMessagePayload)
-		pusherMessage.Respond()
+		if err := pusherMessage.Respond(); err != nil {
+			c.logger.Info("error responding to websocket message",
+				zap.String("event", pusherMessagePayload.Event),
+				zap.String("error", err.Error()),
+			)
+
+			if subscriptionErr, ok := err.(*larasockets.SubscriptionError); ok {
+				c.collector.HandleSubscriptionFailure(c.App().Id(), subscriptionErr.Reason)
+			}
+		}
 	}
 }
 
@@ -164,27 +305,13 @@ func (c *Connection) writePump() {
 				// if we fail to read message from the send channel of the client, that means the hub
 				// closed the connection. so we just inform the client that the connection has been closed.
 				_ = c.websocketConn.WriteMessage(websocket.CloseMessage, []byte{})
+				return
 			}
 
-			w, err := c.websocketConn.NextWriter(websocket.TextMessage)
-			if err != nil {
-				c.logger.Error("error acquiring next writer",
-					zap.String("error", err.Error()),
-				)
-				continue
-			}
-
-			c.logger.Info("sending message",
-				zap.String("message_payload", string(message)),
-			)
-
-			_, _ = w.Write(message)
-			if err = w.Close(); err != nil {
-				c.logger.Error("error closing the writer",
-					zap.String("error", err.Error()),
-				)
-				continue
-			}
+			c.writeQueuedMessage(message)
+		case <-c.drainCh:
+			c.drain()
+			return
 		case <-ticker.C:
 			_ = c.websocketConn.SetWriteDeadline(time.Now().Add(writeWait))
 			if err := c.websocketConn.WriteMessage(websocket.PingMessage, []byte("PING")); err != nil {
@@ -199,26 +326,136 @@ func (c *Connection) writePump() {
 	}
 }
 
-func (c *Connection) Close() {
-	c.hub.RemoveConnection(c)
-	c.closeCh <- true
-	_ = c.websocketConn.Close()
+// writeQueuedMessage writes a message taken from the send queue and collects its statistics.
+func (c *Connection) writeQueuedMessage(message *larasockets.EncodedMessage) {
+	c.collector.HandleSendQueueDepth(c.App().Id(), len(c.sendCh)+1)
+	c.logger.Debug("sending message",
+		zap.ByteString("message_payload", message.Payload()),
+	)
 
-	c.collector.HandleDisconnection(c.App().Id())
-	events.LogEvent(c.hub.channelManger, events.Disconnected, events.DashboardLogDetails{
-		AppId:        c.App().Id(),
-		ChannelName:  "",
-		EventName:    "",
-		ConnectionId: c.Id(),
-		EventPayload: "",
+	writeStart := time.Now()
+	if err := c.writeMessage(message); err != nil {
+		c.logger.Error("error writing message",
+			zap.String("error", err.Error()),
+		)
+		return
+	}
+
+	c.collector.HandleWriteDuration(c.App().Id(), time.Since(writeStart))
+	if triggeredAt, ok := larasockets.TriggerTimeFromContext(message.Context()); ok {
+		c.collector.HandleDeliveryLatency(c.App().Id(), time.Since(triggeredAt))
+	}
+
+	atomic.AddInt64(&c.messagesSent, 1)
+	atomic.AddInt64(&c.bytesSent, int64(len(message.Payload())))
+	c.collector.HandleWebsocketMessage(c.App().Id(), len(message.Payload()))
+}
+
+// drain writes the messages that are in the send queue when Disconnect was called, then the error
+// of Disconnect and the close frame. The shutdown is signalled apart from the queue, so the slow consumer policies can
+// never drop it, and messages queued in the meantime can not hold it up.
+func (c *Connection) drain() {
+	// the queue is received from without blocking, a full queue is drained by enqueue as well.
+	for queued := len(c.sendCh); queued > 0; queued-- {
+		select {
+		case message, ok := <-c.sendCh:
+			if !ok {
+				queued = 0
+				continue
+			}
+
+			_ = c.websocketConn.SetWriteDeadline(time.Now().Add(writeWait))
+			c.writeQueuedMessage(message)
+		default:
+			queued = 0
+		}
+	}
+
+	if c.disconnectMessage != nil {
+		_ = c.websocketConn.SetWriteDeadline(time.Now().Add(writeWait))
+		c.writeQueuedMessage(c.disconnectMessage)
+	}
+
+	closeCode := int(atomic.LoadInt32(&c.closeCode))
+	_ = c.websocketConn.SetWriteDeadline(time.Now().Add(writeWait))
+	_ = c.websocketConn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(closeCode, ""))
+}
+
+// writeMessage writes a single message to the websocket connection. Messages that were prepared
+// for a broadcast are written with their shared frame, everything else is framed here. If the
+// message belongs to a trace, the write is traced as part of it.
+func (c *Connection) writeMessage(message *larasockets.EncodedMessage) error {
+	ctx := message.Context()
+	if !trace.SpanContextFromContext(ctx).IsValid() {
+		return c.writeFrame(message)
+	}
+
+	_, span := tracing.Tracer().Start(ctx, "larasockets.connection.write", trace.WithAttributes(
+		attribute.String("larasockets.app_id", c.App().Id()),
+		attribute.String("larasockets.connection_id", c.Id()),
+		attribute.Int("larasockets.send_queue_depth", len(c.sendCh)),
+	))
+	defer span.End()
+
+	if err := c.writeFrame(message); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "error writing message")
+		return err
+	}
+
+	span.AddEvent("write", trace.WithAttributes(attribute.Int("larasockets.bytes", len(message.Payload()))))
+	return nil
+}
+
+func (c *Connection) writeFrame(message *larasockets.EncodedMessage) error {
+	if frame := message.PreparedFrame(); frame != nil {
+		return c.websocketConn.WritePreparedMessage(frame)
+	}
+
+	return c.websocketConn.WriteMessage(websocket.TextMessage, message.Payload())
+}
+
+func (c *Connection) Disconnect(ctx context.Context, code int, message string) {
+	defer c.Close()
+
+	c.drainOnce.Do(func() {
+		// the error is written by the write pump after the queue, so a full queue can not
+		// drop it or turn it into the close code of the slow consumer policy.
+		disconnectMessage, err := larasockets.NewEncodedMessage(messages.NewPusherErrorMessage(message, code))
+		if err != nil {
+			c.logger.Error("error marshaling data to json", zap.String("error", err.Error()))
+		}
+
+		c.disconnectMessage = disconnectMessage
+		atomic.StoreInt32(&c.closeCode, int32(code))
+		close(c.drainCh)
+	})
+
+	select {
+	case <-c.closeCh:
+	case <-ctx.Done():
+	}
+}
+
+// Close closes the connection. It is safe to call Close multiple times and from multiple
+// goroutines, only the first call has any effect.
+func (c *Connection) Close() {
+	c.closeOnce.Do(func() {
+		c.hub.RemoveConnection(c)
+		close(c.closeCh)
+		_ = c.websocketConn.Close()
+
+		c.collector.HandleDisconnection(c.App().Id())
+		events.LogEvent(c.hub.channelManger, events.Disconnected, events.DashboardLogDetails{
+			AppId:        c.App().Id(),
+			ChannelName:  "",
+			EventName:    "",
+			ConnectionId: c.Id(),
+			EventPayload: "",
+		})
 	})
 }
 
 func (c *Connection) App() *larasockets.Application {
 	return c.app
 }
-
-func generateIdForConnection() string {
-	rand.Seed(time.Now().UnixNano())
-	return strconv.Itoa(rand.Intn(100000)) + "." + strconv.Itoa(rand.Intn(1000000000))
-}
@@ -0,0 +1,259 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/gorilla/websocket"
+	"github.com/iamsayantan/larasockets"
+	"github.com/iamsayantan/larasockets/channel_managers"
+	"github.com/iamsayantan/larasockets/config"
+	"github.com/iamsayantan/larasockets/socket_ids"
+	"github.com/iamsayantan/larasockets/statistics"
+	"github.com/iamsayantan/larasockets/statistics/collectors"
+	"github.com/iamsayantan/larasockets/statistics/stores"
+	"go.uber.org/zap"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// stallSize is the size of the message that stalls the write pump, it is far larger than the
+// socket buffers, so writing it blocks until the client reads.
+const stallSize = 32 << 20
+
+type testConnection struct {
+	conn      *Connection
+	client    *websocket.Conn
+	collector statistics.StatsCollector
+}
+
+// newTestConnection returns a connection with the send queue and the client it is connected to.
+// The client does not read anything until readAll is called.
+func newTestConnection(t *testing.T, sendQueue config.SendQueueConfig) *testConnection {
+	t.Helper()
+
+	cm := channel_managers.NewLocalManager(nil, zap.NewNop())
+	hub := NewHub(zap.NewNop(), cm, socket_ids.NewSequentialGenerator(0))
+	app := larasockets.NewApplication(config.AppConfig{ID: "1", Key: "key1", Secret: "secret1"})
+
+	collector := collectors.NewMemoryCollector(cm, stores.NewNullStorage(), time.Hour)
+	t.Cleanup(collector.Stop)
+
+	upgrader := websocket.Upgrader{}
+	accepted := make(chan *Connection, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+
+		accepted <- NewConnection(hub, app, ws, larasockets.ConnectionInfo{}, sendQueue, collector, zap.NewNop()).(*Connection)
+	}))
+	t.Cleanup(server.Close)
+
+	client, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("error connecting to the test server: %s", err.Error())
+	}
+	t.Cleanup(func() { _ = client.Close() })
+
+	tc := &testConnection{conn: <-accepted, client: client, collector: collector}
+	t.Cleanup(tc.conn.Close)
+
+	return tc
+}
+
+// stall sends a message the client does not read, the write pump is stuck writing it and the
+// send queue fills up from now on.
+func (tc *testConnection) stall(t *testing.T) {
+	t.Helper()
+
+	tc.conn.Send(strings.Repeat("x", stallSize))
+	for deadline := time.Now().Add(5 * time.Second); len(tc.conn.sendCh) != 0; {
+		if time.Now().After(deadline) {
+			t.Fatal("the write pump did not pick up the stalling message")
+		}
+
+		time.Sleep(time.Millisecond)
+	}
+
+	time.Sleep(50 * time.Millisecond)
+}
+
+// sendNumbers sends the messages {"n":from} up to {"n":to}.
+func (tc *testConnection) sendNumbers(from, to int) {
+	for n := from; n <= to; n++ {
+		tc.conn.Send(map[string]int{"n": n})
+	}
+}
+
+// readAll reads until the connection is closed, it returns the messages after the stalling one
+// and the close code.
+func (tc *testConnection) readAll(t *testing.T) ([]string, int) {
+	t.Helper()
+
+	_ = tc.client.SetReadDeadline(time.Now().Add(10 * time.Second))
+
+	received := make([]string, 0)
+	for {
+		_, message, err := tc.client.ReadMessage()
+		if err != nil {
+			if closeErr, ok := err.(*websocket.CloseError); ok {
+				return received, closeErr.Code
+			}
+
+			t.Fatalf("error reading from the connection after %d messages: %s", len(received), err.Error())
+		}
+
+		if len(received) == 0 && len(message) > stallSize {
+			received = append(received, "stall")
+			continue
+		}
+
+		received = append(received, string(message))
+	}
+}
+
+func (tc *testConnection) droppedMessages() int {
+	stats := tc.collector.GetAppStatistics("1")
+	return stats.DroppedMessages()
+}
+
+func disconnectError(t *testing.T, message string) int {
+	t.Helper()
+
+	var payload struct {
+		Event string `json:"event"`
+		Data  struct {
+			Code int `json:"code"`
+		} `json:"data"`
+	}
+
+	if err := json.Unmarshal([]byte(message), &payload); err != nil || payload.Event != "pusher:error" {
+		t.Fatalf("expected a pusher:error message, got %q", message)
+	}
+
+	return payload.Data.Code
+}
+
+func assertMessages(t *testing.T, received []string, expected ...string) {
+	t.Helper()
+
+	if len(received) < len(expected) {
+		t.Fatalf("expected the messages %v, got %v", expected, received)
+	}
+
+	for i := range expected {
+		if received[i] != expected[i] {
+			t.Fatalf("expected the messages %v, got %v", expected, received)
+		}
+	}
+}
+
+func TestSendQueueDropNewest(t *testing.T) {
+	tc := newTestConnection(t, config.SendQueueConfig{Size: 4, Policy: config.SlowConsumerDropNewest})
+	tc.stall(t)
+	tc.sendNumbers(1, 7)
+
+	if dropped := tc.droppedMessages(); dropped != 3 {
+		t.Fatalf("expected 3 dropped messages, got %d", dropped)
+	}
+
+	// the queue is full, the error of the disconnect must still be written.
+	go tc.conn.Disconnect(context.Background(), 4200, "server is shutting down")
+
+	received, closeCode := tc.readAll(t)
+	assertMessages(t, received, "stall", `{"n":1}`, `{"n":2}`, `{"n":3}`, `{"n":4}`)
+	if len(received) != 6 || disconnectError(t, received[5]) != 4200 || closeCode != 4200 {
+		t.Fatalf("expected the queued messages followed by the error 4200 and close code 4200, got %v and %d", received, closeCode)
+	}
+}
+
+func TestSendQueueDropOldest(t *testing.T) {
+	tc := newTestConnection(t, config.SendQueueConfig{Size: 4, Policy: config.SlowConsumerDropOldest})
+	tc.stall(t)
+	tc.sendNumbers(1, 7)
+
+	if dropped := tc.droppedMessages(); dropped != 3 {
+		t.Fatalf("expected 3 dropped messages, got %d", dropped)
+	}
+
+	go tc.conn.Disconnect(context.Background(), 4200, "server is shutting down")
+
+	received, closeCode := tc.readAll(t)
+	assertMessages(t, received, "stall", `{"n":4}`, `{"n":5}`, `{"n":6}`, `{"n":7}`)
+	if len(received) != 6 || disconnectError(t, received[5]) != 4200 || closeCode != 4200 {
+		t.Fatalf("expected the queued messages followed by the error 4200 and close code 4200, got %v and %d", received, closeCode)
+	}
+}
+
+func TestSendQueueDisconnect(t *testing.T) {
+	tc := newTestConnection(t, config.SendQueueConfig{Size: 4, Policy: config.SlowConsumerDisconnect, CloseCode: 4100})
+	tc.stall(t)
+
+	sent := make(chan struct{})
+	go func() {
+		defer close(sent)
+		tc.sendNumbers(1, 7)
+	}()
+
+	// the close frame waits for the stalled write, sending must not wait for it.
+	select {
+	case <-sent:
+	case <-time.After(time.Second):
+		t.Fatal("sending to a slow consumer blocked the caller")
+	}
+
+	if dropped := tc.droppedMessages(); dropped != 3 {
+		t.Fatalf("expected 3 dropped messages, got %d", dropped)
+	}
+
+	received, closeCode := tc.readAll(t)
+	assertMessages(t, received, "stall")
+	if closeCode != 4100 {
+		t.Fatalf("expected close code 4100, got %d", closeCode)
+	}
+
+	select {
+	case <-tc.conn.closeCh:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the slow consumer to be closed")
+	}
+}
+
+func TestDisconnectWithFullQueueKeepsItsCode(t *testing.T) {
+	tc := newTestConnection(t, config.SendQueueConfig{Size: 4, Policy: config.SlowConsumerDisconnect, CloseCode: 4100})
+	tc.stall(t)
+	tc.sendNumbers(1, 4)
+
+	disconnected := make(chan struct{})
+	go func() {
+		defer close(disconnected)
+		tc.conn.Disconnect(context.Background(), 4200, "server is shutting down")
+	}()
+
+	// messages sent while the connection is disconnecting must not trigger the policy.
+	for !isClosed(tc.conn.drainCh) {
+		time.Sleep(time.Millisecond)
+	}
+	tc.sendNumbers(5, 7)
+
+	received, closeCode := tc.readAll(t)
+	assertMessages(t, received, "stall", `{"n":1}`, `{"n":2}`, `{"n":3}`, `{"n":4}`)
+	if len(received) != 6 || disconnectError(t, received[5]) != 4200 || closeCode != 4200 {
+		t.Fatalf("expected the queued messages followed by the error 4200 and close code 4200, got %v and %d", received, closeCode)
+	}
+
+	<-disconnected
+}
+
+func isClosed(ch chan struct{}) bool {
+	select {
+	case <-ch:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,29 @@
+package dashboard
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+	"path"
+	"strings"
+)
+
+//go:embed static
+var static embed.FS
+
+// Handler serves the single page dashboard under prefix. The dashboard talks to the api routes of
+// the server, so it has to be served by the same server. Paths that are not a file of the
+// dashboard get its index page, the page does its own routing.
+func Handler(prefix string) http.Handler {
+	files, _ := fs.Sub(static, "static")
+	fileServer := http.StripPrefix(prefix, http.FileServer(http.FS(files)))
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := strings.TrimPrefix(path.Clean(strings.TrimPrefix(r.URL.Path, prefix)), "/")
+		if _, err := fs.Stat(files, name); name != "" && err != nil {
+			r.URL.Path = prefix + "/"
+		}
+
+		fileServer.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,43 @@
+package dashboard
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHandlerFallsBackToTheIndexPage(t *testing.T) {
+	handler := Handler("/dashboard")
+
+	tests := []struct {
+		path        string
+		contentType string
+	}{
+		{path: "/dashboard/", contentType: "text/html"},
+		{path: "/dashboard/style.css", contentType: "text/css"},
+		{path: "/dashboard/app.js", contentType: "javascript"},
+		{path: "/dashboard/apps/1/logs", contentType: "text/html"},
+		{path: "/dashboard/missing.js", contentType: "text/html"},
+		{path: "/dashboard/../../go.mod", contentType: "text/html"},
+	}
+
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+
+		if rec.Code != http.StatusOK {
+			t.Fatalf("expected status %d for %s, got %d", http.StatusOK, tt.path, rec.Code)
+		}
+
+		if contentType := rec.Header().Get("Content-Type"); !strings.Contains(contentType, tt.contentType) {
+			t.Fatalf("expected %s to be served as %s, got %s", tt.path, tt.contentType, contentType)
+		}
+
+		// the index page is served for every path that is not a file of the dashboard.
+		isIndex := strings.Contains(rec.Body.String(), "<title>Larasockets Dashboard</title>")
+		if isIndex != (tt.contentType == "text/html") {
+			t.Fatalf("expected the index page only for paths that are not a file, got it for %s: %t", tt.path, isIndex)
+		}
+	}
+}
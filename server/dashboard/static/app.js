@@ -0,0 +1,444 @@
+(function () {
+    'use strict';
+
+    var MAX_LOG_ROWS = 500;
+    var session = JSON.parse(sessionStorage.getItem('larasockets-session') || 'null');
+    var socket = null;
+    var reconnectTimer = null;
+    var statsTimer = null;
+
+    function $(id) {
+        return document.getElementById(id);
+    }
+
+    function api(method, path, body, contentType) {
+        var headers = {};
+        if (session) {
+            headers['Authorization'] = session.access_token;
+        }
+
+        if (body !== undefined) {
+            headers['Content-Type'] = contentType || 'application/json';
+        }
+
+        return fetch(path, {method: method, headers: headers, body: body}).then(function (response) {
+            return response.text().then(function (text) {
+                var data = text ? JSON.parse(text) : {};
+                if (response.status === 401 && session) {
+                    logout();
+                }
+
+                if (!response.ok) {
+                    throw new Error(data.message || response.statusText);
+                }
+
+                return data;
+            });
+        });
+    }
+
+    // Login
+    //
+    // Users log in with their username and password and get the apps they have a role for, an
+    // app secret login is an admin of that one app.
+
+    function showLogin() {
+        $('login-view').hidden = false;
+        $('app-view').hidden = true;
+        $('session').hidden = true;
+
+        api('GET', '/dashboard/apps').then(function (response) {
+            var select = $('secret-app');
+            select.innerHTML = '';
+            (response.data || []).forEach(function (app) {
+                var option = document.createElement('option');
+                option.value = app.app_id;
+                option.textContent = app.app_name + ' (' + app.app_id + ')';
+                select.appendChild(option);
+            });
+        }).catch(function (err) {
+            $('secret-error').textContent = err.message;
+        });
+    }
+
+    $('login-form').addEventListener('submit', function (e) {
+        e.preventDefault();
+        $('login-error').textContent = '';
+
+        var request = {username: $('login-username').value, password: $('login-password').value};
+        api('POST', '/dashboard/login', JSON.stringify(request)).then(function (response) {
+            if (response.data.apps.length === 0) {
+                throw new Error('You do not have access to any app.');
+            }
+
+            session = {username: response.data.username, access_token: response.data.access_token, apps: response.data.apps};
+            $('login-password').value = '';
+            selectApp(session.apps[0].app_id);
+        }).catch(function (err) {
+            $('login-error').textContent = err.message;
+        });
+    });
+
+    $('secret-form').addEventListener('submit', function (e) {
+        e.preventDefault();
+        $('secret-error').textContent = '';
+
+        var request = {app_id: $('secret-app').value, app_secret: $('secret-secret').value};
+        api('POST', '/dashboard/apps/authorize', JSON.stringify(request)).then(function (response) {
+            session = {
+                access_token: response.data.access_token,
+                apps: [{
+                    app_id: response.data.app_id,
+                    app_name: $('secret-app').selectedOptions[0].textContent,
+                    app_key: response.data.api_key,
+                    role: 'admin'
+                }]
+            };
+            $('secret-secret').value = '';
+            selectApp(response.data.app_id);
+        }).catch(function (err) {
+            $('secret-error').textContent = err.message;
+        });
+    });
+
+    // selectApp switches the dashboard to one of the apps of the session.
+    function selectApp(appId) {
+        var app = session.apps.filter(function (app) {
+            return app.app_id === appId;
+        })[0];
+
+        session.app_id = app.app_id;
+        session.app_name = app.app_name;
+        session.api_key = app.app_key;
+        session.role = app.role;
+        sessionStorage.setItem('larasockets-session', JSON.stringify(session));
+
+        disconnect();
+        $('log-rows').innerHTML = '';
+        $('alerts').innerHTML = '';
+        showApp();
+    }
+
+    $('session-app').addEventListener('change', function () {
+        selectApp($('session-app').value);
+    });
+
+    function disconnect() {
+        clearTimeout(reconnectTimer);
+        clearInterval(statsTimer);
+        if (socket) {
+            socket.onclose = null;
+            socket.close();
+            socket = null;
+        }
+    }
+
+    function logout() {
+        session = null;
+        sessionStorage.removeItem('larasockets-session');
+        disconnect();
+        showLogin();
+    }
+
+    $('logout').addEventListener('click', logout);
+
+    function showApp() {
+        $('login-view').hidden = true;
+        $('app-view').hidden = false;
+        $('session').hidden = false;
+        $('session-user').textContent = session.username || '';
+        $('session-role').textContent = session.role;
+
+        var select = $('session-app');
+        select.innerHTML = '';
+        session.apps.forEach(function (app) {
+            var option = document.createElement('option');
+            option.value = app.app_id;
+            option.textContent = app.app_name;
+            select.appendChild(option);
+        });
+        select.value = session.app_id;
+        select.disabled = session.apps.length < 2;
+
+        // viewers can not trigger events, the server refuses it anyway.
+        $('trigger-card').hidden = session.role === 'viewer';
+
+        connect();
+        loadStats();
+        clearInterval(statsTimer);
+        statsTimer = setInterval(loadStats, 60000);
+    }
+
+    // Websocket connection, speaking the pusher protocol.
+
+    function channels() {
+        return {
+            log: 'private-websockets-dashboard-' + session.app_id,
+            currentStats: 'private-app-' + session.app_id + '-current-stats',
+            concurrentConnections: 'private-app-' + session.app_id + '-stats-concurrent-connections',
+            alerts: 'private-app-' + session.app_id + '-alerts'
+        };
+    }
+
+    function setSocketState(state) {
+        $('socket-state').textContent = state;
+        $('socket-state').className = 'badge' + (state === 'connected' ? ' connected' : '');
+    }
+
+    function connect() {
+        var scheme = location.protocol === 'https:' ? 'wss://' : 'ws://';
+        socket = new WebSocket(scheme + location.host + '/app/' + session.api_key + '?protocol=7&client=dashboard');
+        setSocketState('connecting');
+
+        socket.onmessage = function (message) {
+            var payload = JSON.parse(message.data);
+            var data = payload.data;
+            if (typeof data === 'string') {
+                try {
+                    data = JSON.parse(data);
+                } catch (e) {
+                    // the data of client events does not have to be json
+                }
+            }
+
+            handleEvent(payload.event, payload.channel, data);
+        };
+
+        socket.onclose = function () {
+            setSocketState('disconnected');
+            reconnectTimer = setTimeout(connect, 3000);
+        };
+    }
+
+    function send(event, data) {
+        socket.send(JSON.stringify({event: event, data: data}));
+    }
+
+    function subscribe(socketId, channel) {
+        var body = 'socket_id=' + encodeURIComponent(socketId) + '&channel_name=' + encodeURIComponent(channel);
+        api('POST', '/apps/' + session.app_id + '/authorize-channels', body, 'application/x-www-form-urlencoded').then(function (response) {
+            send('pusher:subscribe', {channel: channel, auth: response.auth});
+        }).catch(function (err) {
+            addLog({type: 'error', time: Date.now() / 1000, channel_name: channel, payload: err.message});
+        });
+    }
+
+    function handleEvent(event, channel, data) {
+        var names = channels();
+
+        switch (event) {
+            case 'pusher:connection_established':
+                setSocketState('connected');
+                Object.keys(names).forEach(function (key) {
+                    subscribe(data.socket_id, names[key]);
+                });
+                return;
+            case 'pusher:ping':
+                send('pusher:pong', {});
+                return;
+        }
+
+        if (channel === names.log && event === 'log') {
+            addLog(data);
+        } else if (channel === names.currentStats && event === 'update') {
+            $('stat-latency').textContent = formatNumber(data.delivery_latency_p99_ms) + ' ms';
+        } else if (channel === names.concurrentConnections && event === 'update') {
+            $('stat-concurrent').textContent = data.concurrent_connections;
+        } else if (channel === names.alerts && event === 'alert') {
+            addAlert(data);
+        }
+    }
+
+    // Event log
+
+    function addLog(entry) {
+        var row = document.createElement('tr');
+        [
+            new Date(entry.time * 1000).toLocaleTimeString(),
+            entry.type,
+            entry.channel_name,
+            entry.event_name,
+            entry.connection_id,
+            entry.payload
+        ].forEach(function (value) {
+            var cell = document.createElement('td');
+            cell.textContent = value || '';
+            row.appendChild(cell);
+        });
+
+        var rows = $('log-rows');
+        rows.insertBefore(row, rows.firstChild);
+        while (rows.childNodes.length > MAX_LOG_ROWS) {
+            rows.removeChild(rows.lastChild);
+        }
+
+        filterRow(row);
+    }
+
+    function filterRow(row) {
+        var filter = $('log-filter').value.toLowerCase();
+        row.hidden = filter !== '' && row.textContent.toLowerCase().indexOf(filter) === -1;
+    }
+
+    $('log-filter').addEventListener('input', function () {
+        Array.prototype.forEach.call($('log-rows').childNodes, filterRow);
+    });
+
+    $('log-clear').addEventListener('click', function () {
+        $('log-rows').innerHTML = '';
+    });
+
+    // Alerts
+
+    function addAlert(alert) {
+        var item = document.createElement('li');
+        item.className = alert.status;
+        item.textContent = '[' + alert.status + '] ' + alert.rule + ': ' + alert.metric + ' ' +
+            formatNumber(alert.value) + ' (threshold ' + formatNumber(alert.threshold) + ') since ' +
+            new Date(alert.started_at).toLocaleString();
+
+        var alerts = $('alerts');
+        alerts.insertBefore(item, alerts.firstChild);
+    }
+
+    // Trigger event
+
+    $('trigger-form').addEventListener('submit', function (e) {
+        e.preventDefault();
+
+        var request = {
+            channel: $('trigger-channel').value,
+            event: $('trigger-event').value,
+            data: $('trigger-data').value
+        };
+
+        $('trigger-result').textContent = '';
+        api('POST', '/apps/' + session.app_id + '/trigger-events', JSON.stringify(request)).then(function () {
+            $('trigger-result').textContent = 'Event sent.';
+        }).catch(function (err) {
+            $('trigger-result').textContent = err.message;
+        });
+    });
+
+    // Statistics
+
+    function loadStats() {
+        api('GET', '/apps/' + session.app_id + '/daily-stats').then(function (response) {
+            var stats = response.data;
+            $('stat-concurrent').textContent = stats.concurrent_connection;
+            $('stat-peak').textContent = stats.peak_connections;
+            $('stat-websocket').textContent = stats.websocket_messages;
+            $('stat-api').textContent = stats.api_messages;
+            $('stat-dropped').textContent = stats.dropped_messages;
+            $('stat-latency').textContent = formatNumber(stats.delivery_latency.p99) + ' ms';
+        }).catch(function () {
+        });
+
+        var to = Math.floor(Date.now() / 1000);
+        var from = to - parseInt($('graph-range').value, 10);
+        api('GET', '/apps/' + session.app_id + '/graph?from=' + from + '&to=' + to).then(function (response) {
+            var graph = response.data;
+            drawGraph($('graph-connections'), graph.peak_connection_stats.x, [
+                {label: 'peak', color: '#3182ce', values: graph.peak_connection_stats.y},
+                {label: 'concurrent', color: '#38a169', values: graph.concurrent_connection_stats.y}
+            ]);
+            drawGraph($('graph-messages'), graph.api_stats.x, [
+                {label: 'api', color: '#d69e2e', values: graph.api_stats.y},
+                {label: 'websocket', color: '#805ad5', values: graph.websocket_message_stats.y}
+            ]);
+            drawGraph($('graph-bandwidth'), graph.bytes_sent_stats.x, [
+                {label: 'sent', color: '#dd6b20', values: graph.bytes_sent_stats.y},
+                {label: 'received', color: '#319795', values: graph.bytes_received_stats.y}
+            ]);
+            drawGraph($('graph-latency'), graph.delivery_latency_stats.x, [
+                {label: 'p50', color: '#38a169', values: graph.delivery_latency_stats.p50},
+                {label: 'p95', color: '#d69e2e', values: graph.delivery_latency_stats.p95},
+                {label: 'p99', color: '#e53e3e', values: graph.delivery_latency_stats.p99}
+            ]);
+        }).catch(function () {
+        });
+    }
+
+    $('graph-range').addEventListener('change', loadStats);
+
+    // drawGraph draws a line per series on the canvas, x holds the unix timestamps of the values.
+    function drawGraph(canvas, x, series) {
+        var ratio = window.devicePixelRatio || 1;
+        var width = canvas.clientWidth;
+        var height = canvas.clientHeight;
+        canvas.width = width * ratio;
+        canvas.height = height * ratio;
+
+        var ctx = canvas.getContext('2d');
+        ctx.scale(ratio, ratio);
+        ctx.clearRect(0, 0, width, height);
+        ctx.font = '11px sans-serif';
+
+        var padding = {top: 20, right: 10, bottom: 20, left: 50};
+        var plotWidth = width - padding.left - padding.right;
+        var plotHeight = height - padding.top - padding.bottom;
+
+        var max = 0;
+        series.forEach(function (s) {
+            (s.values || []).forEach(function (value) {
+                max = Math.max(max, value);
+            });
+        });
+        max = max || 1;
+
+        ctx.strokeStyle = '#e4e7eb';
+        ctx.fillStyle = '#616e7c';
+        for (var i = 0; i <= 4; i++) {
+            var y = padding.top + plotHeight - plotHeight * i / 4;
+            ctx.beginPath();
+            ctx.moveTo(padding.left, y);
+            ctx.lineTo(width - padding.right, y);
+            ctx.stroke();
+            ctx.fillText(formatNumber(max * i / 4), 4, y + 4);
+        }
+
+        if (x.length > 0) {
+            ctx.fillText(new Date(x[0] * 1000).toLocaleString(), padding.left, height - 4);
+            var last = new Date(x[x.length - 1] * 1000).toLocaleString();
+            ctx.fillText(last, width - padding.right - ctx.measureText(last).width, height - 4);
+        }
+
+        var legendX = padding.left;
+        series.forEach(function (s) {
+            ctx.fillStyle = s.color;
+            ctx.fillText(s.label, legendX, 12);
+            legendX += ctx.measureText(s.label).width + 12;
+
+            ctx.strokeStyle = s.color;
+            ctx.beginPath();
+            (s.values || []).forEach(function (value, index) {
+                var px = padding.left + (x.length > 1 ? plotWidth * index / (x.length - 1) : 0);
+                var py = padding.top + plotHeight - plotHeight * value / max;
+                if (index === 0) {
+                    ctx.moveTo(px, py);
+                } else {
+                    ctx.lineTo(px, py);
+                }
+            });
+            ctx.stroke();
+        });
+    }
+
+    function formatNumber(value) {
+        if (value >= 1000000) {
+            return (value / 1000000).toFixed(1) + 'M';
+        }
+
+        if (value >= 1000) {
+            return (value / 1000).toFixed(1) + 'k';
+        }
+
+        return Math.round(value * 100) / 100;
+    }
+
+    if (session && session.apps) {
+        showApp();
+    } else {
+        showLogin();
+    }
+})();
@@ -0,0 +1,193 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/go-chi/chi"
+	"github.com/iamsayantan/larasockets"
+	"github.com/iamsayantan/larasockets/config"
+	"github.com/iamsayantan/larasockets/events"
+	"github.com/iamsayantan/larasockets/server/handlers/dto"
+	"github.com/iamsayantan/larasockets/server/rendering"
+	"go.uber.org/zap"
+	"net/http"
+)
+
+// AppDisconnector disconnects all the connections made to an application.
+type AppDisconnector interface {
+	DisconnectApp(appId string, code int, message string)
+}
+
+func NewAdminHandler(store larasockets.ApplicationStore, disconnector AppDisconnector, logger *zap.Logger) *AdminHandler {
+	return &AdminHandler{appStore: store, disconnector: disconnector, logger: logger.With(zap.String("handler", "AdminHandler"))}
+}
+
+// URL /admin/apps
+type AdminHandler struct {
+	appStore     larasockets.ApplicationStore
+	disconnector AppDisconnector
+
+	logger *zap.Logger
+}
+
+func (h *AdminHandler) AllApps(w http.ResponseWriter, r *http.Request) {
+	appResponses := make([]dto.AdminApplicationResponse, 0)
+	for _, app := range h.appStore.All() {
+		appResponses = append(appResponses, adminApplicationResponse(app, false))
+	}
+
+	for _, app := range h.appStore.Disabled() {
+		appResponses = append(appResponses, adminApplicationResponse(app, true))
+	}
+
+	rendering.RenderSuccessWithData(w, "success", http.StatusOK, appResponses)
+}
+
+func (h *AdminHandler) CreateApp(w http.ResponseWriter, r *http.Request) {
+	var appRequest dto.AdminApplicationRequest
+	if err := json.NewDecoder(r.Body).Decode(&appRequest); err != nil {
+		rendering.RenderError(w, fmt.Sprintf("invalid request: %s", err.Error()), http.StatusBadRequest)
+		return
+	}
+
+	// the key and the secret are generated when they are not given.
+	if appRequest.AppKey == "" {
+		appRequest.AppKey = randomToken(10)
+	}
+
+	if appRequest.AppSecret == "" {
+		appRequest.AppSecret = randomToken(20)
+	}
+
+	app, err := h.appStore.Create(appConfigFromRequest(appRequest))
+	if err != nil {
+		h.renderStoreError(w, err)
+		return
+	}
+
+	h.logger.Info("application created", zap.String("application_id", app.Id()))
+	rendering.RenderSuccessWithData(w, "success", http.StatusCreated, adminApplicationResponse(app, false))
+}
+
+func (h *AdminHandler) UpdateApp(w http.ResponseWriter, r *http.Request) {
+	var appRequest dto.AdminApplicationRequest
+	if err := json.NewDecoder(r.Body).Decode(&appRequest); err != nil {
+		rendering.RenderError(w, fmt.Sprintf("invalid request: %s", err.Error()), http.StatusBadRequest)
+		return
+	}
+
+	appRequest.AppId = chi.URLParam(r, "appId")
+	app, err := h.appStore.Update(appConfigFromRequest(appRequest))
+	if err != nil {
+		h.renderStoreError(w, err)
+		return
+	}
+
+	h.logger.Info("application updated", zap.String("application_id", app.Id()))
+	rendering.RenderSuccessWithData(w, "success", http.StatusOK, adminApplicationResponse(app, false))
+}
+
+func (h *AdminHandler) DisableApp(w http.ResponseWriter, r *http.Request) {
+	appId := chi.URLParam(r, "appId")
+	if err := h.appStore.SetDisabled(appId, true); err != nil {
+		h.renderStoreError(w, err)
+		return
+	}
+
+	// see https://pusher.com/docs/channels/library_auth_reference/pusher-websockets-protocol#error-codes
+	h.disconnector.DisconnectApp(appId, 4003, "application disabled")
+	h.logger.Info("application disabled", zap.String("application_id", appId))
+	rendering.RenderSuccess(w, "success", http.StatusOK)
+}
+
+func (h *AdminHandler) EnableApp(w http.ResponseWriter, r *http.Request) {
+	appId := chi.URLParam(r, "appId")
+	if err := h.appStore.SetDisabled(appId, false); err != nil {
+		h.renderStoreError(w, err)
+		return
+	}
+
+	h.logger.Info("application enabled", zap.String("application_id", appId))
+	rendering.RenderSuccess(w, "success", http.StatusOK)
+}
+
+func (h *AdminHandler) DeleteApp(w http.ResponseWriter, r *http.Request) {
+	appId := chi.URLParam(r, "appId")
+	if err := h.appStore.Delete(appId); err != nil {
+		h.renderStoreError(w, err)
+		return
+	}
+
+	h.disconnector.DisconnectApp(appId, 4001, "application does not exist")
+	events.RecentLogs.Forget(appId)
+	h.logger.Info("application deleted", zap.String("application_id", appId))
+	rendering.RenderSuccess(w, "success", http.StatusOK)
+}
+
+func (h *AdminHandler) renderStoreError(w http.ResponseWriter, err error) {
+	switch err {
+	case larasockets.ErrApplicationNotFound:
+		rendering.RenderError(w, err.Error(), http.StatusNotFound)
+	case larasockets.ErrApplicationExists:
+		rendering.RenderError(w, err.Error(), http.StatusConflict)
+	default:
+		h.logger.Error("error changing application", zap.String("error", err.Error()))
+		rendering.RenderError(w, err.Error(), http.StatusBadRequest)
+	}
+}
+
+func appConfigFromRequest(appRequest dto.AdminApplicationRequest) config.AppConfig {
+	previousSecrets := make([]config.SecretConfig, 0)
+	for _, secret := range appRequest.PreviousSecrets {
+		previousSecrets = append(previousSecrets, config.SecretConfig{
+			Version:   secret.Version,
+			Secret:    secret.Secret,
+			ExpiresAt: secret.ExpiresAt,
+		})
+	}
+
+	return config.AppConfig{
+		ID:                   appRequest.AppId,
+		Name:                 appRequest.AppName,
+		Key:                  appRequest.AppKey,
+		Secret:               appRequest.AppSecret,
+		SecretVersion:        appRequest.SecretVersion,
+		PreviousSecrets:      previousSecrets,
+		Capacity:             appRequest.Capacity,
+		EnableClientMessages: appRequest.EnableClientMessages,
+	}
+}
+
+func adminApplicationResponse(app *larasockets.Application, disabled bool) dto.AdminApplicationResponse {
+	// the first valid secret is always the current one.
+	previousSecrets := make([]dto.AdminSecretBody, 0)
+	for _, secret := range app.ValidSecrets()[1:] {
+		previousSecrets = append(previousSecrets, dto.AdminSecretBody{
+			Version:   secret.Version(),
+			Secret:    secret.Secret(),
+			ExpiresAt: secret.ExpiresAt(),
+		})
+	}
+
+	return dto.AdminApplicationResponse{
+		AppId:                app.Id(),
+		AppName:              app.Name(),
+		AppKey:               app.Key(),
+		AppSecret:            app.Secret(),
+		SecretVersion:        app.SecretVersion(),
+		PreviousSecrets:      previousSecrets,
+		Capacity:             app.Capacity(),
+		EnableClientMessages: app.ClientMessageEnabled(),
+		Disabled:             disabled,
+	}
+}
+
+// randomToken returns a random hex string of n bytes.
+func randomToken(n int) string {
+	b := make([]byte, n)
+	_, _ = rand.Read(b)
+
+	return hex.EncodeToString(b)
+}
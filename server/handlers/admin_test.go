@@ -0,0 +1,170 @@
+package handlers
+
+import (
+	"encoding/json"
+	"github.com/glebarez/sqlite"
+	"github.com/go-chi/chi"
+	"github.com/iamsayantan/larasockets"
+	"github.com/iamsayantan/larasockets/app_managers"
+	"github.com/iamsayantan/larasockets/config"
+	"github.com/iamsayantan/larasockets/server/handlers/dto"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type disconnectedApp struct {
+	appId string
+	code  int
+}
+
+type testDisconnector struct {
+	disconnected []disconnectedApp
+}
+
+func (d *testDisconnector) DisconnectApp(appId string, code int, message string) {
+	d.disconnected = append(d.disconnected, disconnectedApp{appId: appId, code: code})
+}
+
+func newTestAdminRouter(t *testing.T) (http.Handler, larasockets.ApplicationStore, *testDisconnector) {
+	t.Helper()
+
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "apps.db")), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatalf("error opening the database: %s", err.Error())
+	}
+
+	if err := db.AutoMigrate(&app_managers.LarasocketsApplication{}); err != nil {
+		t.Fatalf("error migrating the database: %s", err.Error())
+	}
+
+	store, err := app_managers.NewDatabaseManager(db, []config.AppConfig{{ID: "1", Key: "key1", Secret: "secret1"}})
+	if err != nil {
+		t.Fatalf("error creating the app store: %s", err.Error())
+	}
+
+	disconnector := &testDisconnector{}
+	handler := NewAdminHandler(store, disconnector, zap.NewNop())
+
+	r := chi.NewRouter()
+	r.Get("/admin/apps", handler.AllApps)
+	r.Post("/admin/apps", handler.CreateApp)
+	r.Put("/admin/apps/{appId}", handler.UpdateApp)
+	r.Post("/admin/apps/{appId}/disable", handler.DisableApp)
+	r.Post("/admin/apps/{appId}/enable", handler.EnableApp)
+	r.Delete("/admin/apps/{appId}", handler.DeleteApp)
+
+	return r, store, disconnector
+}
+
+// serve sends the request to the handler and decodes the data of the response into data, if it
+// is not nil.
+func serve(t *testing.T, handler http.Handler, method, url, body string, data interface{}) int {
+	t.Helper()
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(method, url, strings.NewReader(body)))
+
+	if data != nil {
+		response := struct {
+			Data interface{} `json:"data"`
+		}{Data: data}
+
+		if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+			t.Fatalf("error decoding the response %q: %s", rec.Body.String(), err.Error())
+		}
+	}
+
+	return rec.Code
+}
+
+func TestAdminHandlerCreateAndUpdate(t *testing.T) {
+	router, store, _ := newTestAdminRouter(t)
+
+	var created dto.AdminApplicationResponse
+	if code := serve(t, router, http.MethodPost, "/admin/apps", `{"app_id":"2","app_name":"two"}`, &created); code != http.StatusCreated {
+		t.Fatalf("expected status %d, got %d", http.StatusCreated, code)
+	}
+
+	if created.AppId != "2" || created.AppKey == "" || created.AppSecret == "" {
+		t.Fatalf("expected a key and a secret to be generated, got %+v", created)
+	}
+
+	if code := serve(t, router, http.MethodPost, "/admin/apps", `{"app_id":"2","app_key":"other","app_secret":"secret"}`, nil); code != http.StatusConflict {
+		t.Fatalf("expected status %d for an existing app, got %d", http.StatusConflict, code)
+	}
+
+	if code := serve(t, router, http.MethodPost, "/admin/apps", `{"app_id":`, nil); code != http.StatusBadRequest {
+		t.Fatalf("expected status %d for an invalid request, got %d", http.StatusBadRequest, code)
+	}
+
+	app := store.FindById("1")
+	var updated dto.AdminApplicationResponse
+	body := `{"app_key":"key1","app_secret":"rotated","secret_version":"v2","previous_secrets":[{"version":"v1","secret":"secret1","expires_at":"2999-01-01T00:00:00Z"}],"capacity":3}`
+	if code := serve(t, router, http.MethodPut, "/admin/apps/1", body, &updated); code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, code)
+	}
+
+	if updated.AppSecret != "rotated" || len(updated.PreviousSecrets) != 1 || updated.Capacity != 3 {
+		t.Fatalf("expected the app to be updated, got %+v", updated)
+	}
+
+	if app.Secret() != "rotated" {
+		t.Fatalf("expected the instance the connections hold to be updated, got secret %q", app.Secret())
+	}
+
+	if code := serve(t, router, http.MethodPut, "/admin/apps/3", `{"app_key":"key3","app_secret":"secret"}`, nil); code != http.StatusNotFound {
+		t.Fatalf("expected status %d for an unknown app, got %d", http.StatusNotFound, code)
+	}
+}
+
+func TestAdminHandlerDisableAndDelete(t *testing.T) {
+	router, store, disconnector := newTestAdminRouter(t)
+
+	if code := serve(t, router, http.MethodPost, "/admin/apps/1/disable", "", nil); code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, code)
+	}
+
+	if store.FindById("1") != nil {
+		t.Fatal("expected the disabled app not to be found")
+	}
+
+	var apps []dto.AdminApplicationResponse
+	serve(t, router, http.MethodGet, "/admin/apps", "", &apps)
+	if len(apps) != 1 || !apps[0].Disabled {
+		t.Fatalf("expected the app to be listed as disabled, got %+v", apps)
+	}
+
+	if code := serve(t, router, http.MethodPost, "/admin/apps/1/enable", "", nil); code != http.StatusOK || store.FindById("1") == nil {
+		t.Fatalf("expected the app to be enabled, got status %d", code)
+	}
+
+	if code := serve(t, router, http.MethodDelete, "/admin/apps/1", "", nil); code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, code)
+	}
+
+	if code := serve(t, router, http.MethodDelete, "/admin/apps/1", "", nil); code != http.StatusNotFound {
+		t.Fatalf("expected status %d for a deleted app, got %d", http.StatusNotFound, code)
+	}
+
+	if code := serve(t, router, http.MethodPost, "/admin/apps/1/disable", "", nil); code != http.StatusNotFound {
+		t.Fatalf("expected status %d for a deleted app, got %d", http.StatusNotFound, code)
+	}
+
+	// the sockets are closed when the app is disabled and when it is deleted, and only then.
+	expected := []disconnectedApp{{appId: "1", code: 4003}, {appId: "1", code: 4001}}
+	if len(disconnector.disconnected) != len(expected) {
+		t.Fatalf("expected the app to be disconnected %d times, got %+v", len(expected), disconnector.disconnected)
+	}
+
+	for i := range expected {
+		if disconnector.disconnected[i] != expected[i] {
+			t.Fatalf("expected disconnect %+v, got %+v", expected[i], disconnector.disconnected[i])
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/go-chi/chi"
+	"github.com/iamsayantan/larasockets"
+	"github.com/iamsayantan/larasockets/config"
+	"github.com/iamsayantan/larasockets/server/handlers/dto"
+	"github.com/iamsayantan/larasockets/server/rendering"
+	"go.uber.org/zap"
+	"net/http"
+	"sort"
+)
+
+func NewAdminUsersHandler(store larasockets.UserStore, logger *zap.Logger) *AdminUsersHandler {
+	return &AdminUsersHandler{userStore: store, logger: logger.With(zap.String("handler", "AdminUsersHandler"))}
+}
+
+// URL /admin/users
+type AdminUsersHandler struct {
+	userStore larasockets.UserStore
+
+	logger *zap.Logger
+}
+
+func (h *AdminUsersHandler) AllUsers(w http.ResponseWriter, r *http.Request) {
+	users := h.userStore.All()
+	sort.Slice(users, func(i, j int) bool {
+		return users[i].Username() < users[j].Username()
+	})
+
+	userResponses := make([]dto.AdminUserResponse, 0)
+	for _, user := range users {
+		userResponses = append(userResponses, adminUserResponse(user))
+	}
+
+	rendering.RenderSuccessWithData(w, "success", http.StatusOK, userResponses)
+}
+
+func (h *AdminUsersHandler) CreateUser(w http.ResponseWriter, r *http.Request) {
+	var userRequest dto.AdminUserRequest
+	if err := json.NewDecoder(r.Body).Decode(&userRequest); err != nil {
+		rendering.RenderError(w, fmt.Sprintf("invalid request: %s", err.Error()), http.StatusBadRequest)
+		return
+	}
+
+	if userRequest.Password == "" {
+		rendering.RenderError(w, "password can not be empty", http.StatusBadRequest)
+		return
+	}
+
+	userConfig, err := userConfigFromRequest(userRequest)
+	if err != nil {
+		rendering.RenderError(w, "error hashing the password", http.StatusInternalServerError)
+		return
+	}
+
+	user, err := h.userStore.Create(userConfig)
+	if err != nil {
+		h.renderStoreError(w, err)
+		return
+	}
+
+	h.logger.Info("user created", zap.String("username", user.Username()))
+	rendering.RenderSuccessWithData(w, "success", http.StatusCreated, adminUserResponse(user))
+}
+
+// UpdateUser replaces the roles of the user, the password is only changed when one is given.
+func (h *AdminUsersHandler) UpdateUser(w http.ResponseWriter, r *http.Request) {
+	var userRequest dto.AdminUserRequest
+	if err := json.NewDecoder(r.Body).Decode(&userRequest); err != nil {
+		rendering.RenderError(w, fmt.Sprintf("invalid request: %s", err.Error()), http.StatusBadRequest)
+		return
+	}
+
+	userRequest.Username = chi.URLParam(r, "username")
+	userConfig, err := userConfigFromRequest(userRequest)
+	if err != nil {
+		rendering.RenderError(w, "error hashing the password", http.StatusInternalServerError)
+		return
+	}
+
+	user, err := h.userStore.Update(userConfig)
+	if err != nil {
+		h.renderStoreError(w, err)
+		return
+	}
+
+	h.logger.Info("user updated", zap.String("username", user.Username()))
+	rendering.RenderSuccessWithData(w, "success", http.StatusOK, adminUserResponse(user))
+}
+
+func (h *AdminUsersHandler) DeleteUser(w http.ResponseWriter, r *http.Request) {
+	username := chi.URLParam(r, "username")
+	if err := h.userStore.Delete(username); err != nil {
+		h.renderStoreError(w, err)
+		return
+	}
+
+	h.logger.Info("user deleted", zap.String("username", username))
+	rendering.RenderSuccess(w, "success", http.StatusOK)
+}
+
+func (h *AdminUsersHandler) renderStoreError(w http.ResponseWriter, err error) {
+	switch err {
+	case larasockets.ErrUserNotFound:
+		rendering.RenderError(w, err.Error(), http.StatusNotFound)
+	case larasockets.ErrUserExists:
+		rendering.RenderError(w, err.Error(), http.StatusConflict)
+	default:
+		h.logger.Error("error changing user", zap.String("error", err.Error()))
+		rendering.RenderError(w, err.Error(), http.StatusBadRequest)
+	}
+}
+
+// userConfigFromRequest hashes the password of the request, the hash is empty without a password.
+func userConfigFromRequest(userRequest dto.AdminUserRequest) (config.UserConfig, error) {
+	userConfig := config.UserConfig{Username: userRequest.Username, Roles: make([]config.UserRoleConfig, 0)}
+	for _, role := range userRequest.Roles {
+		userConfig.Roles = append(userConfig.Roles, config.UserRoleConfig{App: role.App, Role: role.Role})
+	}
+
+	if userRequest.Password == "" {
+		return userConfig, nil
+	}
+
+	hash, err := larasockets.HashPassword(userRequest.Password)
+	if err != nil {
+		return userConfig, err
+	}
+
+	userConfig.PasswordHash = hash
+	return userConfig, nil
+}
+
+func adminUserResponse(user *larasockets.User) dto.AdminUserResponse {
+	resp := dto.AdminUserResponse{Username: user.Username(), Roles: make([]dto.AdminUserRole, 0)}
+	for appId, role := range user.Roles() {
+		resp.Roles = append(resp.Roles, dto.AdminUserRole{App: appId, Role: string(role)})
+	}
+
+	sort.Slice(resp.Roles, func(i, j int) bool {
+		return resp.Roles[i].App < resp.Roles[j].App
+	})
+
+	return resp
+}
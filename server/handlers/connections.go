@@ -0,0 +1,172 @@
+package handlers
+
+import (
+	"encoding/json"
+	"github.com/go-chi/chi"
+	"github.com/iamsayantan/larasockets"
+	"github.com/iamsayantan/larasockets/server/handlers/dto"
+	"github.com/iamsayantan/larasockets/server/rendering"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultConnectionsPerPage = 50
+	maxConnectionsPerPage     = 500
+
+	// defaultDisconnectCode asks the client to reconnect right away.
+	defaultDisconnectCode    = 4200
+	defaultDisconnectMessage = "connection closed by an administrator"
+)
+
+// ConnectionInspector looks up and disconnects the connections of an app.
+type ConnectionInspector interface {
+	AppConnections(appId string) []larasockets.ConnectionInfo
+	DisconnectConnection(appId, connectionId string, code int, message string) bool
+}
+
+func NewConnectionsHandler(inspector ConnectionInspector) *ConnectionsHandler {
+	return &ConnectionsHandler{inspector: inspector}
+}
+
+// URL /apps/{appId}/connections
+type ConnectionsHandler struct {
+	inspector ConnectionInspector
+}
+
+// AllConnections lists the connections of the app, the oldest first, page by page. The list can be
+// filtered by the remote ip, the client library, a channel the connection is subscribed to and a
+// part of the user agent.
+func (h *ConnectionsHandler) AllConnections(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	page, err := positiveQueryInt(query.Get("page"), 1)
+	if err != nil {
+		rendering.RenderError(w, "invalid page parameter", http.StatusBadRequest)
+		return
+	}
+
+	perPage, err := positiveQueryInt(query.Get("per_page"), defaultConnectionsPerPage)
+	if err != nil || perPage > maxConnectionsPerPage {
+		rendering.RenderError(w, "per_page must be between 1 and "+strconv.Itoa(maxConnectionsPerPage), http.StatusBadRequest)
+		return
+	}
+
+	ip, client, channel := query.Get("ip"), query.Get("client"), query.Get("channel")
+	userAgent := strings.ToLower(query.Get("user_agent"))
+
+	matching := make([]larasockets.ConnectionInfo, 0)
+	for _, info := range h.inspector.AppConnections(chi.URLParam(r, "appId")) {
+		if ip != "" && info.RemoteIP != ip {
+			continue
+		}
+
+		if client != "" && info.Client != client {
+			continue
+		}
+
+		if channel != "" && !containsString(info.Channels, channel) {
+			continue
+		}
+
+		if userAgent != "" && !strings.Contains(strings.ToLower(info.UserAgent), userAgent) {
+			continue
+		}
+
+		matching = append(matching, info)
+	}
+
+	resp := dto.ConnectionList{
+		Total:       len(matching),
+		Page:        page,
+		PerPage:     perPage,
+		Connections: make([]dto.Connection, 0),
+	}
+
+	for i := (page - 1) * perPage; i < len(matching) && i < page*perPage; i++ {
+		resp.Connections = append(resp.Connections, connectionToDto(matching[i]))
+	}
+
+	rendering.RenderSuccessWithData(w, "success", http.StatusOK, resp)
+}
+
+// GetConnection shows a single connection of the app.
+func (h *ConnectionsHandler) GetConnection(w http.ResponseWriter, r *http.Request) {
+	socketId := chi.URLParam(r, "socketId")
+	for _, info := range h.inspector.AppConnections(chi.URLParam(r, "appId")) {
+		if info.Id == socketId {
+			rendering.RenderSuccessWithData(w, "success", http.StatusOK, connectionToDto(info))
+			return
+		}
+	}
+
+	rendering.RenderError(w, "connection not found", http.StatusNotFound)
+}
+
+// Disconnect closes a connection of the app with a pusher close code, by default the client is
+// asked to reconnect right away.
+func (h *ConnectionsHandler) Disconnect(w http.ResponseWriter, r *http.Request) {
+	disconnectRequest := dto.DisconnectRequest{Code: defaultDisconnectCode, Message: defaultDisconnectMessage}
+	if err := json.NewDecoder(r.Body).Decode(&disconnectRequest); err != nil && err != io.EOF {
+		rendering.RenderError(w, "invalid request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// see https://pusher.com/docs/channels/library_auth_reference/pusher-websockets-protocol#error-codes
+	if disconnectRequest.Code < 4000 || disconnectRequest.Code > 4299 {
+		rendering.RenderError(w, "code must be a pusher close code between 4000 and 4299", http.StatusBadRequest)
+		return
+	}
+
+	appId, socketId := chi.URLParam(r, "appId"), chi.URLParam(r, "socketId")
+	if !h.inspector.DisconnectConnection(appId, socketId, disconnectRequest.Code, disconnectRequest.Message) {
+		rendering.RenderError(w, "connection not found", http.StatusNotFound)
+		return
+	}
+
+	rendering.RenderSuccess(w, "success", http.StatusOK)
+}
+
+func connectionToDto(info larasockets.ConnectionInfo) dto.Connection {
+	return dto.Connection{
+		SocketId:         info.Id,
+		RemoteIP:         info.RemoteIP,
+		UserAgent:        info.UserAgent,
+		Origin:           info.Origin,
+		Client:           info.Client,
+		ClientVersion:    info.ClientVersion,
+		Protocol:         info.Protocol,
+		ConnectedAt:      info.ConnectedAt.Unix(),
+		LastActivityAt:   info.LastActivityAt.Unix(),
+		MessagesReceived: info.MessagesReceived,
+		MessagesSent:     info.MessagesSent,
+		BytesReceived:    info.BytesReceived,
+		BytesSent:        info.BytesSent,
+		Channels:         info.Channels,
+	}
+}
+
+func positiveQueryInt(value string, fallback int) (int, error) {
+	if value == "" {
+		return fallback, nil
+	}
+
+	parsed, err := strconv.Atoi(value)
+	if err != nil || parsed <= 0 {
+		return 0, strconv.ErrSyntax
+	}
+
+	return parsed, nil
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,214 @@
+package handlers
+
+import (
+	"fmt"
+	"github.com/go-chi/chi"
+	"github.com/iamsayantan/larasockets"
+	"github.com/iamsayantan/larasockets/server/handlers/dto"
+	"net/http"
+	"testing"
+)
+
+type disconnectedConnection struct {
+	appId        string
+	connectionId string
+	code         int
+	message      string
+}
+
+type testInspector struct {
+	connections  map[string][]larasockets.ConnectionInfo
+	disconnected []disconnectedConnection
+}
+
+func (i *testInspector) AppConnections(appId string) []larasockets.ConnectionInfo {
+	return i.connections[appId]
+}
+
+func (i *testInspector) DisconnectConnection(appId, connectionId string, code int, message string) bool {
+	for _, info := range i.connections[appId] {
+		if info.Id == connectionId {
+			i.disconnected = append(i.disconnected, disconnectedConnection{appId: appId, connectionId: connectionId, code: code, message: message})
+			return true
+		}
+	}
+
+	return false
+}
+
+// newTestConnectionsRouter returns the connections routes of app 1 with 12 connections. Every
+// third connection is made by pusher-js from 10.0.0.1, the others by laravel-echo from 10.0.0.2.
+// Even connections are subscribed to the chat channel.
+func newTestConnectionsRouter() (http.Handler, *testInspector) {
+	connections := make([]larasockets.ConnectionInfo, 0)
+	for i := 1; i <= 12; i++ {
+		info := larasockets.ConnectionInfo{
+			Id:        fmt.Sprintf("%d.%d", i, i),
+			AppId:     "1",
+			RemoteIP:  "10.0.0.2",
+			Client:    "laravel-echo",
+			UserAgent: "Mozilla/5.0 (X11; Linux x86_64) Firefox/120.0",
+			Channels:  []string{"presence-room"},
+		}
+
+		if i%3 == 0 {
+			info.RemoteIP, info.Client, info.UserAgent = "10.0.0.1", "pusher-js", "Mozilla/5.0 (iPhone) Safari/604.1"
+		}
+
+		if i%2 == 0 {
+			info.Channels = append(info.Channels, "chat")
+		}
+
+		connections = append(connections, info)
+	}
+
+	inspector := &testInspector{connections: map[string][]larasockets.ConnectionInfo{"1": connections}}
+	handler := NewConnectionsHandler(inspector)
+
+	r := chi.NewRouter()
+	r.Get("/apps/{appId}/connections", handler.AllConnections)
+	r.Get("/apps/{appId}/connections/{socketId}", handler.GetConnection)
+	r.Post("/apps/{appId}/connections/{socketId}/disconnect", handler.Disconnect)
+
+	return r, inspector
+}
+
+func socketIds(list dto.ConnectionList) []string {
+	ids := make([]string, len(list.Connections))
+	for i, connection := range list.Connections {
+		ids[i] = connection.SocketId
+	}
+
+	return ids
+}
+
+func TestConnectionsHandlerPagination(t *testing.T) {
+	router, _ := newTestConnectionsRouter()
+
+	tests := []struct {
+		query    string
+		status   int
+		total    int
+		expected []string
+	}{
+		{query: "", status: http.StatusOK, total: 12, expected: []string{"1.1", "2.2", "3.3", "4.4", "5.5", "6.6", "7.7", "8.8", "9.9", "10.10", "11.11", "12.12"}},
+		{query: "?per_page=5", status: http.StatusOK, total: 12, expected: []string{"1.1", "2.2", "3.3", "4.4", "5.5"}},
+		{query: "?per_page=5&page=3", status: http.StatusOK, total: 12, expected: []string{"11.11", "12.12"}},
+		{query: "?per_page=5&page=4", status: http.StatusOK, total: 12, expected: []string{}},
+		{query: "?per_page=500", status: http.StatusOK, total: 12, expected: []string{"1.1", "2.2", "3.3", "4.4", "5.5", "6.6", "7.7", "8.8", "9.9", "10.10", "11.11", "12.12"}},
+		{query: "?per_page=501", status: http.StatusBadRequest},
+		{query: "?per_page=0", status: http.StatusBadRequest},
+		{query: "?page=0", status: http.StatusBadRequest},
+		{query: "?page=-1", status: http.StatusBadRequest},
+		{query: "?page=one", status: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		var list dto.ConnectionList
+		var data interface{}
+		if tt.status == http.StatusOK {
+			data = &list
+		}
+
+		if code := serve(t, router, http.MethodGet, "/apps/1/connections"+tt.query, "", data); code != tt.status {
+			t.Fatalf("expected status %d for %q, got %d", tt.status, tt.query, code)
+		}
+
+		if tt.status != http.StatusOK {
+			continue
+		}
+
+		if list.Total != tt.total || fmt.Sprint(socketIds(list)) != fmt.Sprint(tt.expected) {
+			t.Fatalf("expected %v of %d connections for %q, got %v of %d", tt.expected, tt.total, tt.query, socketIds(list), list.Total)
+		}
+	}
+}
+
+func TestConnectionsHandlerFilters(t *testing.T) {
+	router, _ := newTestConnectionsRouter()
+
+	tests := []struct {
+		query    string
+		expected []string
+	}{
+		{query: "?ip=10.0.0.1", expected: []string{"3.3", "6.6", "9.9", "12.12"}},
+		{query: "?ip=10.0.0.3", expected: []string{}},
+		{query: "?client=pusher-js", expected: []string{"3.3", "6.6", "9.9", "12.12"}},
+		{query: "?channel=chat", expected: []string{"2.2", "4.4", "6.6", "8.8", "10.10", "12.12"}},
+		{query: "?channel=cha", expected: []string{}},
+		{query: "?user_agent=iphone", expected: []string{"3.3", "6.6", "9.9", "12.12"}},
+		{query: "?channel=chat&client=laravel-echo&user_agent=FIREFOX", expected: []string{"2.2", "4.4", "8.8", "10.10"}},
+		{query: "?channel=chat&ip=10.0.0.1&per_page=1&page=2", expected: []string{"12.12"}},
+	}
+
+	for _, tt := range tests {
+		var list dto.ConnectionList
+		if code := serve(t, router, http.MethodGet, "/apps/1/connections"+tt.query, "", &list); code != http.StatusOK {
+			t.Fatalf("expected status %d for %q, got %d", http.StatusOK, tt.query, code)
+		}
+
+		if fmt.Sprint(socketIds(list)) != fmt.Sprint(tt.expected) {
+			t.Fatalf("expected the connections %v for %q, got %v", tt.expected, tt.query, socketIds(list))
+		}
+	}
+
+	var list dto.ConnectionList
+	serve(t, router, http.MethodGet, "/apps/1/connections?channel=chat&ip=10.0.0.1&per_page=1&page=2", "", &list)
+	if list.Total != 2 {
+		t.Fatalf("expected the total to count the matching connections, got %d", list.Total)
+	}
+}
+
+func TestConnectionsHandlerGetConnection(t *testing.T) {
+	router, _ := newTestConnectionsRouter()
+
+	var connection dto.Connection
+	if code := serve(t, router, http.MethodGet, "/apps/1/connections/3.3", "", &connection); code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, code)
+	}
+
+	if connection.SocketId != "3.3" || connection.Client != "pusher-js" || connection.RemoteIP != "10.0.0.1" {
+		t.Fatalf("expected connection 3.3, got %+v", connection)
+	}
+
+	for _, url := range []string{"/apps/1/connections/13.13", "/apps/2/connections/3.3"} {
+		if code := serve(t, router, http.MethodGet, url, "", nil); code != http.StatusNotFound {
+			t.Fatalf("expected status %d for %s, got %d", http.StatusNotFound, url, code)
+		}
+	}
+}
+
+func TestConnectionsHandlerDisconnect(t *testing.T) {
+	router, inspector := newTestConnectionsRouter()
+
+	tests := []struct {
+		socketId string
+		body     string
+		status   int
+	}{
+		{socketId: "1.1", body: "", status: http.StatusOK},
+		{socketId: "2.2", body: `{"code":4000,"message":"bye"}`, status: http.StatusOK},
+		{socketId: "3.3", body: `{"code":4299}`, status: http.StatusOK},
+		{socketId: "4.4", body: `{"code":3999}`, status: http.StatusBadRequest},
+		{socketId: "4.4", body: `{"code":4300}`, status: http.StatusBadRequest},
+		{socketId: "4.4", body: `{"code":1000}`, status: http.StatusBadRequest},
+		{socketId: "4.4", body: `{"code":`, status: http.StatusBadRequest},
+		{socketId: "13.13", body: `{"code":4200}`, status: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		if code := serve(t, router, http.MethodPost, "/apps/1/connections/"+tt.socketId+"/disconnect", tt.body, nil); code != tt.status {
+			t.Fatalf("expected status %d for %s with %q, got %d", tt.status, tt.socketId, tt.body, code)
+		}
+	}
+
+	expected := []disconnectedConnection{
+		{appId: "1", connectionId: "1.1", code: defaultDisconnectCode, message: defaultDisconnectMessage},
+		{appId: "1", connectionId: "2.2", code: 4000, message: "bye"},
+		{appId: "1", connectionId: "3.3", code: 4299, message: defaultDisconnectMessage},
+	}
+
+	if fmt.Sprint(inspector.disconnected) != fmt.Sprint(expected) {
+		t.Fatalf("expected the disconnects %+v, got %+v", expected, inspector.disconnected)
+	}
+}
@@ -1,10 +1,12 @@
 package handlers
 
 import (
+	"crypto/subtle"
 	"encoding/json"
 	"fmt"
 	"github.com/dgrijalva/jwt-go"
 	"github.com/iamsayantan/larasockets"
+	"github.com/iamsayantan/larasockets/config"
 	"github.com/iamsayantan/larasockets/events"
 	"github.com/iamsayantan/larasockets/messages"
 	"github.com/iamsayantan/larasockets/server/handlers/dto"
@@ -13,18 +15,85 @@ import (
 	"github.com/iamsayantan/larasockets/statistics"
 	"github.com/pusher/pusher-http-go/v5"
 	"io/ioutil"
+	"log"
 	"net/http"
+	"net/url"
+	"sort"
+	"strings"
 	"time"
 )
 
-func NewDashboardHandler(cm larasockets.ChannelManager, c statistics.StatsCollector) *DashboardHandler {
-	return &DashboardHandler{appManager: cm.AppManager(), channelManager: cm, collector: c}
+// unknownUserPasswordHash is the hash of a random password with the cost of HashPassword.
+const unknownUserPasswordHash = "$2a$10$3iYvxupHKy5Xt.dHhyBhf.SejnYILLSAHi2qZGcGe.g45yVK2gYci"
+
+// unknownUser is the user whose password is checked when a login names no user.
+var unknownUser = larasockets.NewUserFromConfig(config.UserConfig{PasswordHash: unknownUserPasswordHash})
+
+func NewDashboardHandler(cm larasockets.ChannelManager, c statistics.StatsCollector, users larasockets.UserManager, cfg config.DashboardConfig) *DashboardHandler {
+	return &DashboardHandler{appManager: cm.AppManager(), channelManager: cm, collector: c, users: users, config: cfg}
 }
 
 type DashboardHandler struct {
 	appManager     larasockets.ApplicationManager
 	channelManager larasockets.ChannelManager
 	collector      statistics.StatsCollector
+	users          larasockets.UserManager
+	config         config.DashboardConfig
+}
+
+// Login authenticates a dashboard user and returns an access token along with the apps the user
+// has access to.
+func (h *DashboardHandler) Login(w http.ResponseWriter, r *http.Request) {
+	var loginRequest dto.LoginRequest
+	if err := json.NewDecoder(r.Body).Decode(&loginRequest); err != nil {
+		rendering.RenderError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// the password is checked for unknown users too, so a failed login takes the same time
+	// whether or not the username exists.
+	user := h.users.FindByUsername(loginRequest.Username)
+	checked := user
+	if checked == nil {
+		checked = unknownUser
+	}
+
+	if !checked.CheckPassword(loginRequest.Password) || user == nil {
+		rendering.RenderError(w, "invalid username or password", http.StatusUnauthorized)
+		return
+	}
+
+	accessToken, err := h.signToken(dto.ApplicationAuthorizationClaims{Username: user.Username()})
+	if err != nil {
+		rendering.RenderError(w, "error generating access token", http.StatusInternalServerError)
+		return
+	}
+
+	resp := dto.LoginResponse{
+		Username:    user.Username(),
+		AccessToken: accessToken,
+		Apps:        make([]dto.UserAppResponse, 0),
+	}
+
+	for _, app := range h.appManager.All() {
+		role := user.RoleFor(app.Id())
+		if role == "" {
+			continue
+		}
+
+		resp.Apps = append(resp.Apps, dto.UserAppResponse{
+			AppId:   app.Id(),
+			AppName: app.Name(),
+			AppKey:  app.Key(),
+			Role:    string(role),
+		})
+	}
+
+	sort.Slice(resp.Apps, func(i, j int) bool {
+		return resp.Apps[i].AppId < resp.Apps[j].AppId
+	})
+
+	rendering.RenderSuccessWithData(w, "success", http.StatusOK, resp)
 }
 
 func (h *DashboardHandler) AllApps(w http.ResponseWriter, r *http.Request) {
@@ -44,7 +113,14 @@ func (h *DashboardHandler) AllApps(w http.ResponseWriter, r *http.Request) {
 	rendering.RenderSuccessWithData(w, "success", http.StatusOK, appResponses)
 }
 
+// AuthorizeConnectionRequest logs in to an app with its secret, the access token is the one of an
+// admin of the app.
 func (h *DashboardHandler) AuthorizeConnectionRequest(w http.ResponseWriter, r *http.Request) {
+	if !h.config.AppSecretLogin {
+		rendering.RenderError(w, "app secret login is disabled", http.StatusForbidden)
+		return
+	}
+
 	var connectionRequest dto.ConnectionRequest
 
 	err := json.NewDecoder(r.Body).Decode(&connectionRequest)
@@ -59,21 +135,24 @@ func (h *DashboardHandler) AuthorizeConnectionRequest(w http.ResponseWriter, r *
 		return
 	}
 
-	if app.Secret() != connectionRequest.AppSecret {
+	secretVersion, valid := "", false
+	for _, secret := range app.ValidSecrets() {
+		if subtle.ConstantTimeCompare([]byte(secret.Secret()), []byte(connectionRequest.AppSecret)) == 1 {
+			secretVersion, valid = secret.Version(), true
+			break
+		}
+	}
+
+	if !valid {
 		rendering.RenderError(w, "invalid app secret", http.StatusBadRequest)
 		return
 	}
 
-	jwtExpirationTime := time.Now().Add(time.Hour * 24)
-	jwtClaims := dto.ApplicationAuthorizationClaims{
-		AppId: app.Id(),
-		StandardClaims: jwt.StandardClaims{
-			ExpiresAt: jwtExpirationTime.Unix(),
-		},
+	if secretVersion != app.SecretVersion() {
+		log.Printf("dashboard login to app %s with previous secret version %s", app.Id(), secretVersion)
 	}
 
-	jwtToken := jwt.NewWithClaims(jwt.SigningMethodHS256, jwtClaims)
-	accessToken, err := jwtToken.SignedString([]byte(app.Secret()))
+	accessToken, err := h.signToken(dto.ApplicationAuthorizationClaims{AppId: app.Id()})
 	if err != nil {
 		rendering.RenderError(w, "error generating access token", http.StatusInternalServerError)
 		return
@@ -104,6 +183,19 @@ func (h *DashboardHandler) AuthorizeChannelRequest(w http.ResponseWriter, r *htt
 		return
 	}
 
+	form, err := url.ParseQuery(string(params))
+	if err != nil {
+		rendering.RenderError(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	// viewers may only follow the channels the dashboard itself broadcasts on.
+	role := middlewares.GetAuthenticatedRoleFromContext(r.Context())
+	if !role.Allows(larasockets.RoleOperator) && !isDashboardChannel(appId, form.Get("channel_name")) {
+		rendering.RenderError(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
 	response, err := pusherClient.AuthenticatePrivateChannel(params)
 	if err != nil {
 		rendering.RenderError(w, "error authorizing channel", http.StatusBadRequest)
@@ -144,5 +236,21 @@ func (h *DashboardHandler) TriggerEvent(w http.ResponseWriter, r *http.Request)
 
 	h.collector.HandleApiMessage(appId)
 
-	channel.Broadcast(messagePayload)
+	channel.Broadcast(larasockets.ContextWithTriggerTime(r.Context(), time.Now()), messagePayload)
+	h.collector.HandleChannelMessage(appId, triggerEventRequest.Channel, triggerEventRequest.Event, len(triggerEventRequest.Data), len(channel.Connections()))
+}
+
+// signToken signs the claims with the token key of the server, the token expires after the
+// configured ttl.
+func (h *DashboardHandler) signToken(claims dto.ApplicationAuthorizationClaims) (string, error) {
+	claims.StandardClaims = jwt.StandardClaims{
+		IssuedAt:  time.Now().Unix(),
+		ExpiresAt: time.Now().Add(h.config.TokenTTL).Unix(),
+	}
+
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(h.config.TokenKey))
+}
+
+func isDashboardChannel(appId, channelName string) bool {
+	return channelName == events.DashboardLogChannel(appId) || strings.HasPrefix(channelName, fmt.Sprintf("private-app-%s-", appId))
 }
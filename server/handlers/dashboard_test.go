@@ -0,0 +1,75 @@
+package handlers
+
+import (
+	"github.com/go-chi/chi"
+	"github.com/iamsayantan/larasockets"
+	"github.com/iamsayantan/larasockets/app_managers"
+	"github.com/iamsayantan/larasockets/channel_managers"
+	"github.com/iamsayantan/larasockets/config"
+	"github.com/iamsayantan/larasockets/server/handlers/dto"
+	"github.com/iamsayantan/larasockets/user_managers"
+	"go.uber.org/zap"
+	"golang.org/x/crypto/bcrypt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestDashboardHandlerLogin(t *testing.T) {
+	passwordHash, err := larasockets.HashPassword("password")
+	if err != nil {
+		t.Fatalf("error hashing the password: %s", err.Error())
+	}
+
+	apps := app_managers.NewConfigManager([]config.AppConfig{
+		{ID: "1", Key: "key1", Secret: "secret1", Name: "one"},
+		{ID: "2", Key: "key2", Secret: "secret2", Name: "two"},
+	})
+
+	users := user_managers.NewConfigManager([]config.UserConfig{
+		{Username: "viewer", PasswordHash: passwordHash, Roles: []config.UserRoleConfig{{App: "2", Role: config.RoleViewer}}},
+	})
+
+	cm := channel_managers.NewLocalManager(apps, zap.NewNop())
+	handler := NewDashboardHandler(cm, nil, users, config.DashboardConfig{TokenKey: "token-key", TokenTTL: time.Hour})
+
+	r := chi.NewRouter()
+	r.Post("/dashboard/login", handler.Login)
+
+	tests := []struct {
+		body   string
+		status int
+	}{
+		{body: `{"username":"viewer","password":"wrong"}`, status: http.StatusUnauthorized},
+		{body: `{"username":"nobody","password":"password"}`, status: http.StatusUnauthorized},
+		{body: `{"username":"","password":""}`, status: http.StatusUnauthorized},
+		{body: `{"username":`, status: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		if code := serve(t, r, http.MethodPost, "/dashboard/login", tt.body, nil); code != tt.status {
+			t.Fatalf("expected status %d for %s, got %d", tt.status, tt.body, code)
+		}
+	}
+
+	var login dto.LoginResponse
+	if code := serve(t, r, http.MethodPost, "/dashboard/login", `{"username":"viewer","password":"password"}`, &login); code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, code)
+	}
+
+	if login.AccessToken == "" || len(login.Apps) != 1 || login.Apps[0].AppId != "2" || login.Apps[0].Role != config.RoleViewer {
+		t.Fatalf("expected a token for app 2, got %+v", login)
+	}
+}
+
+func TestUnknownUserPasswordHash(t *testing.T) {
+	// the dummy hash must be as expensive to check as the hashes of the users.
+	cost, err := bcrypt.Cost([]byte(unknownUserPasswordHash))
+	if err != nil || cost != bcrypt.DefaultCost {
+		t.Fatalf("expected the dummy hash to have cost %d, got %d (%v)", bcrypt.DefaultCost, cost, err)
+	}
+
+	if unknownUser.CheckPassword("") {
+		t.Fatal("expected the unknown user to never log in")
+	}
+}
@@ -0,0 +1,48 @@
+package dto
+
+import "time"
+
+type AdminApplicationRequest struct {
+	AppId                string            `json:"app_id"`
+	AppName              string            `json:"app_name"`
+	AppKey               string            `json:"app_key"`
+	AppSecret            string            `json:"app_secret"`
+	SecretVersion        string            `json:"secret_version"`
+	PreviousSecrets      []AdminSecretBody `json:"previous_secrets"`
+	Capacity             int               `json:"capacity"`
+	EnableClientMessages bool              `json:"enable_client_messages"`
+}
+
+type AdminSecretBody struct {
+	Version   string    `json:"version"`
+	Secret    string    `json:"secret"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+type AdminApplicationResponse struct {
+	AppId                string            `json:"app_id"`
+	AppName              string            `json:"app_name"`
+	AppKey               string            `json:"app_key"`
+	AppSecret            string            `json:"app_secret"`
+	SecretVersion        string            `json:"secret_version"`
+	PreviousSecrets      []AdminSecretBody `json:"previous_secrets"`
+	Capacity             int               `json:"capacity"`
+	EnableClientMessages bool              `json:"enable_client_messages"`
+	Disabled             bool              `json:"disabled"`
+}
+
+type AdminUserRequest struct {
+	Username string          `json:"username"`
+	Password string          `json:"password"`
+	Roles    []AdminUserRole `json:"roles"`
+}
+
+type AdminUserRole struct {
+	App  string `json:"app"`
+	Role string `json:"role"`
+}
+
+type AdminUserResponse struct {
+	Username string          `json:"username"`
+	Roles    []AdminUserRole `json:"roles"`
+}
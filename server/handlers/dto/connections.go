@@ -0,0 +1,30 @@
+package dto
+
+type Connection struct {
+	SocketId         string   `json:"socket_id"`
+	RemoteIP         string   `json:"remote_ip"`
+	UserAgent        string   `json:"user_agent"`
+	Origin           string   `json:"origin"`
+	Client           string   `json:"client"`
+	ClientVersion    string   `json:"client_version"`
+	Protocol         string   `json:"protocol"`
+	ConnectedAt      int64    `json:"connected_at"`     // unix timestamp
+	LastActivityAt   int64    `json:"last_activity_at"` // unix timestamp
+	MessagesReceived int      `json:"messages_received"`
+	MessagesSent     int      `json:"messages_sent"`
+	BytesReceived    int      `json:"bytes_received"`
+	BytesSent        int      `json:"bytes_sent"`
+	Channels         []string `json:"channels"`
+}
+
+type ConnectionList struct {
+	Total       int          `json:"total"`
+	Page        int          `json:"page"`
+	PerPage     int          `json:"per_page"`
+	Connections []Connection `json:"connections"`
+}
+
+type DisconnectRequest struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
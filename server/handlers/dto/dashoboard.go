@@ -19,9 +19,31 @@ type ApplicationResponse struct {
 	AppKey  string `json:"app_key"`
 }
 
+// ApplicationAuthorizationClaims are the claims of a dashboard access token, either of a user
+// login or of an app secret login to AppId.
 type ApplicationAuthorizationClaims struct {
 	jwt.StandardClaims
-	AppId string
+	AppId    string `json:"app_id,omitempty"`
+	Username string `json:"username,omitempty"`
+}
+
+type LoginRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+type LoginResponse struct {
+	Username    string            `json:"username"`
+	AccessToken string            `json:"access_token"`
+	Apps        []UserAppResponse `json:"apps"`
+}
+
+// UserAppResponse is an app the user has access to and the role of the user for it.
+type UserAppResponse struct {
+	AppId   string `json:"app_id"`
+	AppName string `json:"app_name"`
+	AppKey  string `json:"app_key"`
+	Role    string `json:"role"`
 }
 
 type DashboardEventTriggerRequest struct {
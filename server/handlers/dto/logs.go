@@ -0,0 +1,10 @@
+package dto
+
+type LogEntry struct {
+	Type         string `json:"type"`
+	Time         int64  `json:"time"` // unix timestamp
+	EventName    string `json:"event_name"`
+	ChannelName  string `json:"channel_name"`
+	ConnectionId string `json:"connection_id"`
+	Payload      string `json:"payload"`
+}
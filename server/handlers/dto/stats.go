@@ -1,10 +1,30 @@
 package dto
 
 type DailyStatSnapshot struct {
-	ConcurrentConnection int `json:"concurrent_connection"`
-	PeakConnections      int `json:"peak_connections"`
-	ApiMessages          int `json:"api_messages"`
-	WebsocketMessages    int `json:"websocket_messages"`
+	ConcurrentConnection int     `json:"concurrent_connection"`
+	PeakConnections      int     `json:"peak_connections"`
+	ApiMessages          int     `json:"api_messages"`
+	WebsocketMessages    int     `json:"websocket_messages"`
+	DroppedMessages      int     `json:"dropped_messages"`
+	PeakSendQueueDepth   int     `json:"peak_send_queue_depth"`
+	BytesReceived        int     `json:"bytes_received"`
+	BytesSent            int     `json:"bytes_sent"`
+	SubscriptionFailures int     `json:"subscription_failures"`
+	DeliveryLatency      Latency `json:"delivery_latency"`
+	WriteDuration        Latency `json:"write_duration"`
+}
+
+// LatencyAggregationMax is the aggregation of the latency percentiles of a period made up of
+// several stored statistics, the highest percentile among them is reported. It is an upper bound
+// of the real percentile of the period, not the exact value.
+const LatencyAggregationMax = "max"
+
+// Latency holds latency percentiles in milliseconds, see LatencyAggregationMax.
+type Latency struct {
+	P50         float64 `json:"p50"`
+	P95         float64 `json:"p95"`
+	P99         float64 `json:"p99"`
+	Aggregation string  `json:"aggregation"`
 }
 
 type StatisticsPlot struct {
@@ -12,8 +32,57 @@ type StatisticsPlot struct {
 	Y []int   `json:"y"` // value for the corresponding timestamp
 }
 
+// LatencyPlot holds the latency percentiles of every bucket in milliseconds, see
+// LatencyAggregationMax.
+type LatencyPlot struct {
+	X           []int64   `json:"x"` // array of unix timestamps
+	P50         []float64 `json:"p50"`
+	P95         []float64 `json:"p95"`
+	P99         []float64 `json:"p99"`
+	Aggregation string    `json:"aggregation"`
+}
+
 type StatisticsGraph struct {
-	ApiStats              StatisticsPlot `json:"api_stats"`
-	PeakConnectionStats   StatisticsPlot `json:"peak_connection_stats"`
-	WebsocketMessageStats StatisticsPlot `json:"websocket_message_stats"`
+	From                      int64          `json:"from"`
+	To                        int64          `json:"to"`
+	Interval                  int64          `json:"interval"` // bucket size in seconds
+	ApiStats                  StatisticsPlot `json:"api_stats"`
+	PeakConnectionStats       StatisticsPlot `json:"peak_connection_stats"`
+	ConcurrentConnectionStats StatisticsPlot `json:"concurrent_connection_stats"`
+	WebsocketMessageStats     StatisticsPlot `json:"websocket_message_stats"`
+	BytesReceivedStats        StatisticsPlot `json:"bytes_received_stats"`
+	BytesSentStats            StatisticsPlot `json:"bytes_sent_stats"`
+	DeliveryLatencyStats      LatencyPlot    `json:"delivery_latency_stats"`
+	WriteDurationStats        LatencyPlot    `json:"write_duration_stats"`
+}
+
+type TrafficStatistic struct {
+	Name            string `json:"name"`
+	Messages        int    `json:"messages"`
+	Bytes           int    `json:"bytes"`
+	PeakSubscribers int    `json:"peak_subscribers,omitempty"`
+}
+
+type TopTraffic struct {
+	From    int64              `json:"from"`
+	To      int64              `json:"to"`
+	Traffic []TrafficStatistic `json:"traffic"`
+}
+
+type UsageDay struct {
+	Date              string  `json:"date"`
+	ConnectionMinutes float64 `json:"connection_minutes"`
+	PeakConnections   int     `json:"peak_connections"`
+	WebsocketMessages int     `json:"websocket_messages"`
+	ApiMessages       int     `json:"api_messages"`
+	BytesReceived     int     `json:"bytes_received"`
+	BytesSent         int     `json:"bytes_sent"`
+}
+
+type UsageReport struct {
+	AppId string     `json:"app_id"`
+	From  int64      `json:"from"`
+	To    int64      `json:"to"`
+	Days  []UsageDay `json:"days"`
+	Total UsageDay   `json:"total"`
 }
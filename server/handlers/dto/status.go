@@ -0,0 +1,5 @@
+package dto
+
+type Status struct {
+	Status string `json:"status"`
+}
@@ -0,0 +1,59 @@
+package handlers
+
+import (
+	"github.com/go-chi/chi"
+	"github.com/iamsayantan/larasockets/events"
+	"github.com/iamsayantan/larasockets/server/handlers/dto"
+	"github.com/iamsayantan/larasockets/server/rendering"
+	"net/http"
+	"strconv"
+)
+
+const defaultLogLimit = 100
+
+func NewLogsHandler(logs *events.LogBuffer) *LogsHandler {
+	return &LogsHandler{logs: logs}
+}
+
+// URL /apps/{appId}/logs
+type LogsHandler struct {
+	logs *events.LogBuffer
+}
+
+// GetLogs returns the latest dashboard log entries of the app, the oldest first. The entries can
+// be filtered by type, channel and socket_id, limit is the number of entries returned.
+func (h *LogsHandler) GetLogs(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	limit := defaultLogLimit
+	if value := query.Get("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed <= 0 || parsed > events.DashboardLogSize {
+			rendering.RenderError(w, "limit must be between 1 and "+strconv.Itoa(events.DashboardLogSize), http.StatusBadRequest)
+			return
+		}
+
+		limit = parsed
+	}
+
+	filter := events.LogFilter{
+		Type:         events.EventType(query.Get("type")),
+		ChannelName:  query.Get("channel"),
+		ConnectionId: query.Get("socket_id"),
+		Limit:        limit,
+	}
+
+	entries := make([]dto.LogEntry, 0)
+	for _, entry := range h.logs.Find(chi.URLParam(r, "appId"), filter) {
+		entries = append(entries, dto.LogEntry{
+			Type:         string(entry.Type),
+			Time:         entry.Time,
+			EventName:    entry.EventName,
+			ChannelName:  entry.ChannelName,
+			ConnectionId: entry.ConnectionId,
+			Payload:      entry.Payload,
+		})
+	}
+
+	rendering.RenderSuccessWithData(w, "success", http.StatusOK, entries)
+}
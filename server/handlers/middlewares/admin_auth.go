@@ -0,0 +1,29 @@
+package middlewares
+
+import (
+	"crypto/subtle"
+	"github.com/iamsayantan/larasockets/server/rendering"
+	"net/http"
+	"strings"
+)
+
+func NewAdminAuthMiddleware(token string) *AdminAuthMiddleware {
+	return &AdminAuthMiddleware{token: token}
+}
+
+// AdminAuthMiddleware only lets through the requests that carry the admin token as a bearer token.
+type AdminAuthMiddleware struct {
+	token string
+}
+
+func (am *AdminAuthMiddleware) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if am.token == "" || subtle.ConstantTimeCompare([]byte(token), []byte(am.token)) != 1 {
+			rendering.RenderError(w, "Unauthenticated Access", http.StatusUnauthorized)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
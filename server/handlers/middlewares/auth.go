@@ -10,53 +10,105 @@ import (
 	"net/http"
 )
 
-const keyAuthAppId = 0
+const (
+	keyAuthAppId = iota
+	keyAuthRole
+)
 
-func NewAuthMiddleware(am larasockets.ApplicationManager) *AuthMiddleware {
-	return &AuthMiddleware{appManager: am}
+// NewAuthMiddleware returns the middleware of the dashboard api. The access tokens are signed
+// with tokenKey, the tokens of app secret logins are only accepted with appSecretLogin.
+func NewAuthMiddleware(am larasockets.ApplicationManager, users larasockets.UserManager, tokenKey []byte, appSecretLogin bool) *AuthMiddleware {
+	return &AuthMiddleware{appManager: am, users: users, tokenKey: tokenKey, appSecretLogin: appSecretLogin}
 }
 
+// AuthMiddleware lets through the requests for the app of the url whose access token grants at
+// least the role required by the route. The role of a user is looked up on every request, so a
+// change of the roles applies to the tokens already issued. An app secret login is an admin of
+// its app.
 type AuthMiddleware struct {
-	appManager larasockets.ApplicationManager
+	appManager     larasockets.ApplicationManager
+	users          larasockets.UserManager
+	tokenKey       []byte
+	appSecretLogin bool
 }
 
-func (am *AuthMiddleware) Handler(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		appId := chi.URLParam(r, "appId")
-		app := am.appManager.FindById(appId)
+// Require returns the middleware of the routes that need the role.
+func (am *AuthMiddleware) Require(required larasockets.Role) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			appId := chi.URLParam(r, "appId")
+			app := am.appManager.FindById(appId)
 
-		if app == nil {
-			rendering.RenderError(w, "Unauthenticated Access", http.StatusUnauthorized)
-			return
-		}
+			if app == nil {
+				rendering.RenderError(w, "Unauthenticated Access", http.StatusUnauthorized)
+				return
+			}
 
-		accessToken := r.Header.Get("Authorization")
-		if accessToken == "" {
-			rendering.RenderError(w, "Unauthenticated Access", http.StatusUnauthorized)
-			return
-		}
+			claims, ok := am.parseToken(r.Header.Get("Authorization"))
+			if !ok {
+				rendering.RenderError(w, "Unauthenticated Access", http.StatusUnauthorized)
+				return
+			}
+
+			role := am.role(claims, app.Id())
+			if role == "" {
+				rendering.RenderError(w, "Unauthenticated Access", http.StatusUnauthorized)
+				return
+			}
 
-		tokenClaims := &dto.ApplicationAuthorizationClaims{}
-		token, err := jwt.ParseWithClaims(accessToken, tokenClaims, func(token *jwt.Token) (interface{}, error) {
-			return []byte(app.Secret()), nil
+			if !role.Allows(required) {
+				rendering.RenderError(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+
+			ctx := r.Context()
+			ctx = context.WithValue(ctx, keyAuthAppId, app.Id())
+			ctx = context.WithValue(ctx, keyAuthRole, role)
+			next.ServeHTTP(w, r.WithContext(ctx))
 		})
+	}
+}
 
-		if err != nil {
-			rendering.RenderError(w, "Unauthenticated Access", http.StatusUnauthorized)
-			return
-		}
+func (am *AuthMiddleware) parseToken(accessToken string) (*dto.ApplicationAuthorizationClaims, bool) {
+	if accessToken == "" {
+		return nil, false
+	}
 
-		if !token.Valid {
-			rendering.RenderError(w, "Unauthenticated Access", http.StatusUnauthorized)
-			return
+	claims := &dto.ApplicationAuthorizationClaims{}
+	token, err := jwt.ParseWithClaims(accessToken, claims, func(token *jwt.Token) (interface{}, error) {
+		if token.Method != jwt.SigningMethodHS256 {
+			return nil, jwt.ErrSignatureInvalid
 		}
 
-		ctx := r.Context()
-		ctx = context.WithValue(ctx, keyAuthAppId, tokenClaims.AppId)
-		next.ServeHTTP(w, r.WithContext(ctx))
+		return am.tokenKey, nil
 	})
+
+	return claims, err == nil && token.Valid
+}
+
+// role returns the role the claims grant for the app, it is empty without access to the app.
+func (am *AuthMiddleware) role(claims *dto.ApplicationAuthorizationClaims, appId string) larasockets.Role {
+	if claims.Username != "" {
+		user := am.users.FindByUsername(claims.Username)
+		if user == nil {
+			return ""
+		}
+
+		return user.RoleFor(appId)
+	}
+
+	if am.appSecretLogin && claims.AppId == appId {
+		return larasockets.RoleAdmin
+	}
+
+	return ""
 }
 
 func GetAuthenticatedAppIdFromContext(ctx context.Context) string {
 	return ctx.Value(keyAuthAppId).(string)
 }
+
+// GetAuthenticatedRoleFromContext returns the role of the request for the app of the url.
+func GetAuthenticatedRoleFromContext(ctx context.Context) larasockets.Role {
+	return ctx.Value(keyAuthRole).(larasockets.Role)
+}
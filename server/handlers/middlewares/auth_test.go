@@ -0,0 +1,144 @@
+package middlewares
+
+import (
+	"github.com/dgrijalva/jwt-go"
+	"github.com/go-chi/chi"
+	"github.com/iamsayantan/larasockets"
+	"github.com/iamsayantan/larasockets/app_managers"
+	"github.com/iamsayantan/larasockets/config"
+	"github.com/iamsayantan/larasockets/server/handlers/dto"
+	"github.com/iamsayantan/larasockets/user_managers"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testTokenKey = []byte("token-key")
+
+func signTestToken(t *testing.T, key []byte, claims dto.ApplicationAuthorizationClaims) string {
+	t.Helper()
+
+	claims.StandardClaims = jwt.StandardClaims{ExpiresAt: time.Now().Add(time.Hour).Unix()}
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(key)
+	if err != nil {
+		t.Fatalf("error signing the token: %s", err.Error())
+	}
+
+	return token
+}
+
+// newTestAuthRouter returns a router with a viewer, an operator and an admin route for every app,
+// they respond with the role the middleware put in the context.
+func newTestAuthRouter(appSecretLogin bool) http.Handler {
+	apps := app_managers.NewConfigManager([]config.AppConfig{
+		{ID: "1", Key: "key1", Secret: "secret1"},
+		{ID: "2", Key: "key2", Secret: "secret2"},
+	})
+
+	users := user_managers.NewConfigManager([]config.UserConfig{
+		{Username: "viewer", Roles: []config.UserRoleConfig{{App: "1", Role: config.RoleViewer}}},
+		{Username: "operator", Roles: []config.UserRoleConfig{{App: "1", Role: config.RoleOperator}}},
+		{Username: "admin", Roles: []config.UserRoleConfig{{App: "1", Role: config.RoleAdmin}}},
+		{Username: "all-viewer", Roles: []config.UserRoleConfig{{App: config.AllApps, Role: config.RoleViewer}, {App: "2", Role: config.RoleOperator}}},
+	})
+
+	auth := NewAuthMiddleware(apps, users, testTokenKey, appSecretLogin)
+	respond := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-App-Id", GetAuthenticatedAppIdFromContext(r.Context()))
+		w.Header().Set("X-Role", string(GetAuthenticatedRoleFromContext(r.Context())))
+	})
+
+	r := chi.NewRouter()
+	r.With(auth.Require(larasockets.RoleViewer)).Get("/apps/{appId}/viewer", respond)
+	r.With(auth.Require(larasockets.RoleOperator)).Get("/apps/{appId}/operator", respond)
+	r.With(auth.Require(larasockets.RoleAdmin)).Get("/apps/{appId}/admin", respond)
+
+	return r
+}
+
+func TestAuthMiddlewareRequire(t *testing.T) {
+	users := func(username string) dto.ApplicationAuthorizationClaims {
+		return dto.ApplicationAuthorizationClaims{Username: username}
+	}
+
+	tests := []struct {
+		name           string
+		appSecretLogin bool
+		token          string
+		url            string
+		status         int
+		role           larasockets.Role
+	}{
+		{name: "viewer on a viewer route", token: signTestToken(t, testTokenKey, users("viewer")), url: "/apps/1/viewer", status: http.StatusOK, role: larasockets.RoleViewer},
+		{name: "viewer on an operator route", token: signTestToken(t, testTokenKey, users("viewer")), url: "/apps/1/operator", status: http.StatusForbidden},
+		{name: "viewer of another app", token: signTestToken(t, testTokenKey, users("viewer")), url: "/apps/2/viewer", status: http.StatusUnauthorized},
+		{name: "operator on an operator route", token: signTestToken(t, testTokenKey, users("operator")), url: "/apps/1/operator", status: http.StatusOK, role: larasockets.RoleOperator},
+		{name: "operator on an admin route", token: signTestToken(t, testTokenKey, users("operator")), url: "/apps/1/admin", status: http.StatusForbidden},
+		{name: "admin on a viewer route", token: signTestToken(t, testTokenKey, users("admin")), url: "/apps/1/viewer", status: http.StatusOK, role: larasockets.RoleAdmin},
+		{name: "admin on an admin route", token: signTestToken(t, testTokenKey, users("admin")), url: "/apps/1/admin", status: http.StatusOK, role: larasockets.RoleAdmin},
+		{name: "all apps role", token: signTestToken(t, testTokenKey, users("all-viewer")), url: "/apps/1/viewer", status: http.StatusOK, role: larasockets.RoleViewer},
+		{name: "all apps role below the route", token: signTestToken(t, testTokenKey, users("all-viewer")), url: "/apps/1/operator", status: http.StatusForbidden},
+		{name: "app role above all apps", token: signTestToken(t, testTokenKey, users("all-viewer")), url: "/apps/2/operator", status: http.StatusOK, role: larasockets.RoleOperator},
+		{name: "unknown user", token: signTestToken(t, testTokenKey, users("deleted")), url: "/apps/1/viewer", status: http.StatusUnauthorized},
+		{name: "unknown app", token: signTestToken(t, testTokenKey, users("admin")), url: "/apps/3/viewer", status: http.StatusUnauthorized},
+		{name: "no token", url: "/apps/1/viewer", status: http.StatusUnauthorized},
+		{name: "invalid token", token: "not-a-token", url: "/apps/1/viewer", status: http.StatusUnauthorized},
+		{name: "token signed with another key", token: signTestToken(t, []byte("other"), users("admin")), url: "/apps/1/viewer", status: http.StatusUnauthorized},
+		{
+			name:   "app secret token without app secret login",
+			token:  signTestToken(t, testTokenKey, dto.ApplicationAuthorizationClaims{AppId: "1"}),
+			url:    "/apps/1/viewer",
+			status: http.StatusUnauthorized,
+		},
+		{
+			name:           "app secret token",
+			appSecretLogin: true,
+			token:          signTestToken(t, testTokenKey, dto.ApplicationAuthorizationClaims{AppId: "1"}),
+			url:            "/apps/1/admin",
+			status:         http.StatusOK,
+			role:           larasockets.RoleAdmin,
+		},
+		{
+			name:           "app secret token of another app",
+			appSecretLogin: true,
+			token:          signTestToken(t, testTokenKey, dto.ApplicationAuthorizationClaims{AppId: "2"}),
+			url:            "/apps/1/viewer",
+			status:         http.StatusUnauthorized,
+		},
+		{
+			name:           "user token with app secret login",
+			appSecretLogin: true,
+			token:          signTestToken(t, testTokenKey, users("viewer")),
+			url:            "/apps/1/admin",
+			status:         http.StatusForbidden,
+		},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, tt.url, nil)
+		if tt.token != "" {
+			req.Header.Set("Authorization", tt.token)
+		}
+
+		rec := httptest.NewRecorder()
+		newTestAuthRouter(tt.appSecretLogin).ServeHTTP(rec, req)
+
+		if rec.Code != tt.status {
+			t.Fatalf("%s: expected status %d, got %d", tt.name, tt.status, rec.Code)
+		}
+
+		if tt.status != http.StatusOK {
+			continue
+		}
+
+		if role := larasockets.Role(rec.Header().Get("X-Role")); role != tt.role {
+			t.Fatalf("%s: expected role %q, got %q", tt.name, tt.role, role)
+		}
+
+		if appId, expected := rec.Header().Get("X-App-Id"), strings.Split(tt.url, "/")[2]; appId != expected {
+			t.Fatalf("%s: expected app %s, got %q", tt.name, expected, appId)
+		}
+	}
+}
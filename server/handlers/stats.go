@@ -1,11 +1,13 @@
 package handlers
 
 import (
+	"fmt"
 	"github.com/go-chi/chi"
 	"github.com/iamsayantan/larasockets/server/handlers/dto"
 	"github.com/iamsayantan/larasockets/server/rendering"
 	"github.com/iamsayantan/larasockets/statistics"
 	"net/http"
+	"strconv"
 	"time"
 )
 
@@ -13,6 +15,16 @@ func NewStatsHandler(store statistics.StatsStorage, collector statistics.StatsCo
 	return &StatsHandler{statsStore: store, statsCollector: collector}
 }
 
+const (
+	defaultGraphRange    = 30 * time.Minute
+	defaultGraphInterval = 5 * time.Second
+	// maxGraphBuckets limits the size of a graph, 30 days in hourly buckets are 720 buckets.
+	maxGraphBuckets = 10000
+
+	defaultTopRange = time.Hour
+	defaultTopLimit = 10
+)
+
 type StatsHandler struct {
 	statsStore     statistics.StatsStorage
 	statsCollector statistics.StatsCollector
@@ -20,64 +32,258 @@ type StatsHandler struct {
 
 func (h *StatsHandler) GetStatForToday(w http.ResponseWriter, r *http.Request) {
 	stat := h.statsStore.DailyStatForApp(chi.URLParam(r, "appId"))
+	if stat == nil {
+		stat = statistics.NewStatistic(chi.URLParam(r, "appId"))
+	}
+
 	currentStat := h.statsCollector.GetAppStatistics(chi.URLParam(r, "appId"))
 	resp := dto.DailyStatSnapshot{
 		ConcurrentConnection: currentStat.ConcurrentConnections(),
 		PeakConnections:      stat.PeakConnections(),
 		ApiMessages:          stat.ApiMessages(),
 		WebsocketMessages:    stat.WebsocketMessages(),
+		DroppedMessages:      stat.DroppedMessages(),
+		PeakSendQueueDepth:   stat.PeakSendQueueDepth(),
+		BytesReceived:        stat.BytesReceived(),
+		BytesSent:            stat.BytesSent(),
+		SubscriptionFailures: stat.SubscriptionFailures(),
+		DeliveryLatency:      latencyToDto(stat.DeliveryLatency()),
+		WriteDuration:        latencyToDto(stat.WriteDuration()),
 	}
 
 	rendering.RenderSuccessWithData(w, "success", http.StatusOK, resp)
 }
 
+// GetStatsForGraph returns the statistics between the from and to query parameters grouped into
+// buckets of interval. from and to are unix timestamps or RFC 3339 times, interval is a duration
+// such as 5m or 1h. By default the last 30 minutes are returned in buckets of 5 seconds.
 func (h *StatsHandler) GetStatsForGraph(w http.ResponseWriter, r *http.Request) {
-	endTime := time.Now()
-	startTime := endTime.Add(-time.Minute * 30)
+	appId := chi.URLParam(r, "appId")
+	query := r.URL.Query()
+
+	endTime, err := parseGraphTime(query.Get("to"), time.Now())
+	if err != nil {
+		rendering.RenderError(w, "invalid to parameter: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	startTime, err := parseGraphTime(query.Get("from"), endTime.Add(-defaultGraphRange))
+	if err != nil {
+		rendering.RenderError(w, "invalid from parameter: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	interval := defaultGraphInterval
+	if value := query.Get("interval"); value != "" {
+		if interval, err = time.ParseDuration(value); err != nil {
+			rendering.RenderError(w, "invalid interval parameter: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	if !startTime.Before(endTime) {
+		rendering.RenderError(w, "from must be before to", http.StatusBadRequest)
+		return
+	}
+
+	if interval < time.Second || interval%time.Second != 0 {
+		rendering.RenderError(w, "interval must be a whole number of seconds", http.StatusBadRequest)
+		return
+	}
+
+	if endTime.Sub(startTime)/interval > maxGraphBuckets {
+		rendering.RenderError(w, fmt.Sprintf("the range can not have more than %d buckets, use a larger interval", maxGraphBuckets), http.StatusBadRequest)
+		return
+	}
 
-	stats := h.statsStore.StatsByTimeRange(chi.URLParam(r, "appId"), startTime, endTime)
+	stats := h.statsStore.StatsForInterval(appId, startTime, endTime, interval)
 
-	var apiStats dto.StatisticsPlot
-	var peakConnectionStats dto.StatisticsPlot
-	var websocketMessageStats dto.StatisticsPlot
+	resp := dto.StatisticsGraph{
+		From:     startTime.Unix(),
+		To:       endTime.Unix(),
+		Interval: int64(interval / time.Second),
+	}
 
 	timestamps := stats.Timestamps()
-	for i := len(timestamps) - 1; i >= 0; i-- {
-		timestamp := timestamps[i]
+	plots := []*dto.StatisticsPlot{
+		&resp.ApiStats, &resp.PeakConnectionStats, &resp.ConcurrentConnectionStats, &resp.WebsocketMessageStats,
+		&resp.BytesReceivedStats, &resp.BytesSentStats,
+	}
+
+	for _, plot := range plots {
+		plot.X = timestamps
+		plot.Y = make([]int, 0, len(timestamps))
+	}
+
+	for _, plot := range []*dto.LatencyPlot{&resp.DeliveryLatencyStats, &resp.WriteDurationStats} {
+		plot.X = timestamps
+		plot.P50 = make([]float64, 0, len(timestamps))
+		plot.P95 = make([]float64, 0, len(timestamps))
+		plot.P99 = make([]float64, 0, len(timestamps))
+		plot.Aggregation = dto.LatencyAggregationMax
+	}
+
+	for _, timestamp := range timestamps {
 		stat := stats.Get(timestamp)
-		if stat == nil {
-			continue
-		}
 
-		if len(apiStats.X) == 0 {
-			apiStats.X = make([]int64, 0)
-			apiStats.Y = make([]int, 0)
-		}
+		resp.ApiStats.Y = append(resp.ApiStats.Y, stat.ApiMessages())
+		resp.PeakConnectionStats.Y = append(resp.PeakConnectionStats.Y, stat.PeakConnections())
+		resp.ConcurrentConnectionStats.Y = append(resp.ConcurrentConnectionStats.Y, stat.ConcurrentConnections())
+		resp.WebsocketMessageStats.Y = append(resp.WebsocketMessageStats.Y, stat.WebsocketMessages())
+		resp.BytesReceivedStats.Y = append(resp.BytesReceivedStats.Y, stat.BytesReceived())
+		resp.BytesSentStats.Y = append(resp.BytesSentStats.Y, stat.BytesSent())
+		appendLatency(&resp.DeliveryLatencyStats, stat.DeliveryLatency())
+		appendLatency(&resp.WriteDurationStats, stat.WriteDuration())
+	}
 
-		if len(peakConnectionStats.X) == 0 {
-			peakConnectionStats.X = make([]int64, 0)
-			peakConnectionStats.Y = make([]int, 0)
-		}
+	rendering.RenderSuccessWithData(w, "success", http.StatusOK, resp)
+}
 
-		if len(websocketMessageStats.X) == 0 {
-			websocketMessageStats.X = make([]int64, 0)
-			websocketMessageStats.Y = make([]int, 0)
-		}
+func latencyToDto(latency statistics.LatencyPercentiles) dto.Latency {
+	return dto.Latency{
+		P50:         milliseconds(latency.P50),
+		P95:         milliseconds(latency.P95),
+		P99:         milliseconds(latency.P99),
+		Aggregation: dto.LatencyAggregationMax,
+	}
+}
 
-		apiStats.X = append(apiStats.X, timestamp)
-		peakConnectionStats.X = append(peakConnectionStats.X, timestamp)
-		websocketMessageStats.X = append(websocketMessageStats.X, timestamp)
+func appendLatency(plot *dto.LatencyPlot, latency statistics.LatencyPercentiles) {
+	plot.P50 = append(plot.P50, milliseconds(latency.P50))
+	plot.P95 = append(plot.P95, milliseconds(latency.P95))
+	plot.P99 = append(plot.P99, milliseconds(latency.P99))
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
 
-		apiStats.Y = append(apiStats.Y, stat.ApiMessages())
-		peakConnectionStats.Y = append(peakConnectionStats.Y, stat.PeakConnections())
-		websocketMessageStats.Y = append(websocketMessageStats.Y, stat.WebsocketMessages())
+// parseGraphTime parses a unix timestamp or an RFC 3339 time, an empty value is the fallback.
+func parseGraphTime(value string, fallback time.Time) (time.Time, error) {
+	if value == "" {
+		return fallback, nil
 	}
 
-	resp := dto.StatisticsGraph{
-		ApiStats:              apiStats,
-		PeakConnectionStats:   peakConnectionStats,
-		WebsocketMessageStats: websocketMessageStats,
+	if timestamp, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(timestamp, 0), nil
+	}
+
+	return time.Parse(time.RFC3339, value)
+}
+
+// GetTopChannels returns the channels with the most messages between the from and to query
+// parameters, by default within the last hour. limit is the number of channels returned.
+func (h *StatsHandler) GetTopChannels(w http.ResponseWriter, r *http.Request) {
+	h.renderTopTraffic(w, r, statistics.TrafficChannel)
+}
+
+// GetTopEvents returns the event names with the most messages, like GetTopChannels.
+func (h *StatsHandler) GetTopEvents(w http.ResponseWriter, r *http.Request) {
+	h.renderTopTraffic(w, r, statistics.TrafficEvent)
+}
+
+func (h *StatsHandler) renderTopTraffic(w http.ResponseWriter, r *http.Request, kind string) {
+	query := r.URL.Query()
+
+	endTime, err := parseGraphTime(query.Get("to"), time.Now())
+	if err != nil {
+		rendering.RenderError(w, "invalid to parameter: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	startTime, err := parseGraphTime(query.Get("from"), endTime.Add(-defaultTopRange))
+	if err != nil {
+		rendering.RenderError(w, "invalid from parameter: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if !startTime.Before(endTime) {
+		rendering.RenderError(w, "from must be before to", http.StatusBadRequest)
+		return
+	}
+
+	limit := defaultTopLimit
+	if value := query.Get("limit"); value != "" {
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit <= 0 || limit > statistics.MaxTrackedTraffic {
+			rendering.RenderError(w, fmt.Sprintf("limit must be between 1 and %d", statistics.MaxTrackedTraffic), http.StatusBadRequest)
+			return
+		}
+	}
+
+	resp := dto.TopTraffic{
+		From:    startTime.Unix(),
+		To:      endTime.Unix(),
+		Traffic: make([]dto.TrafficStatistic, 0),
+	}
+
+	for _, traffic := range h.statsStore.TopTraffic(chi.URLParam(r, "appId"), kind, startTime, endTime, limit) {
+		resp.Traffic = append(resp.Traffic, dto.TrafficStatistic{
+			Name:            traffic.Name,
+			Messages:        traffic.Messages,
+			Bytes:           traffic.Bytes,
+			PeakSubscribers: traffic.PeakSubscribers,
+		})
 	}
 
 	rendering.RenderSuccessWithData(w, "success", http.StatusOK, resp)
 }
+
+// GetUsage exports the usage of the app between the from and to query parameters, by default the
+// current month, day by day. format is either json, the default, or csv.
+func (h *StatsHandler) GetUsage(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	startTime, endTime, err := statistics.ParseUsageRange(query.Get("from"), query.Get("to"), time.Now())
+	if err != nil {
+		rendering.RenderError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	format := query.Get("format")
+	if format != "" && format != "json" && format != "csv" {
+		rendering.RenderError(w, "format must be either json or csv", http.StatusBadRequest)
+		return
+	}
+
+	report := statistics.NewUsageReport(h.statsStore, chi.URLParam(r, "appId"), startTime, endTime)
+	if format != "csv" {
+		rendering.RenderSuccessWithData(w, "success", http.StatusOK, UsageReportResponse(report))
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"usage-%s-%s-%s.csv\"",
+		report.AppId, startTime.UTC().Format("20060102"), endTime.UTC().Format("20060102")))
+
+	_ = report.WriteCSV(w)
+}
+
+// UsageReportResponse converts the usage report into its json response.
+func UsageReportResponse(report statistics.UsageReport) dto.UsageReport {
+	resp := dto.UsageReport{
+		AppId: report.AppId,
+		From:  report.From.Unix(),
+		To:    report.To.Unix(),
+		Days:  make([]dto.UsageDay, 0, len(report.Days)),
+		Total: usageDayResponse("total", report.Total),
+	}
+
+	for _, day := range report.Days {
+		resp.Days = append(resp.Days, usageDayResponse(day.Day.Format("2006-01-02"), day))
+	}
+
+	return resp
+}
+
+func usageDayResponse(date string, day statistics.UsageDay) dto.UsageDay {
+	return dto.UsageDay{
+		Date:              date,
+		ConnectionMinutes: day.ConnectionMinutes,
+		PeakConnections:   day.PeakConnections,
+		WebsocketMessages: day.WebsocketMessages,
+		ApiMessages:       day.ApiMessages,
+		BytesReceived:     day.BytesReceived,
+		BytesSent:         day.BytesSent,
+	}
+}
@@ -0,0 +1,130 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"github.com/go-chi/chi"
+	"github.com/iamsayantan/larasockets/server/handlers/dto"
+	"github.com/iamsayantan/larasockets/statistics"
+	"github.com/iamsayantan/larasockets/statistics/stores"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// usageStore reports the day of the month as the peak connections of a day, along with 10
+// websocket messages and 2 api messages.
+type usageStore struct {
+	statistics.StatsStorage
+}
+
+func (s *usageStore) UsageForApp(appId string, startTime time.Time, endTime time.Time) *statistics.Statistic {
+	return statistics.NewStatisticWithData(appId, 0, startTime.UTC().Day(), 10, 2, 0, 0)
+}
+
+func newTestStatsRouter() http.Handler {
+	handler := NewStatsHandler(&usageStore{StatsStorage: stores.NewNullStorage()}, nil)
+
+	r := chi.NewRouter()
+	r.Get("/apps/{appId}/usage", handler.GetUsage)
+
+	return r
+}
+
+func TestStatsHandlerUsageJSON(t *testing.T) {
+	router := newTestStatsRouter()
+
+	var report dto.UsageReport
+	if code := serve(t, router, http.MethodGet, "/apps/1/usage?from=2026-01-30&to=2026-02-01", "", &report); code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, code)
+	}
+
+	from, to := time.Date(2026, 1, 30, 0, 0, 0, 0, time.UTC), time.Date(2026, 2, 2, 0, 0, 0, 0, time.UTC)
+	if report.AppId != "1" || report.From != from.Unix() || report.To != to.Unix() {
+		t.Fatalf("expected the usage of app 1 from %s until %s, got %+v", from, to, report)
+	}
+
+	expected := []dto.UsageDay{
+		{Date: "2026-01-30", PeakConnections: 30, WebsocketMessages: 10, ApiMessages: 2},
+		{Date: "2026-01-31", PeakConnections: 31, WebsocketMessages: 10, ApiMessages: 2},
+		{Date: "2026-02-01", PeakConnections: 1, WebsocketMessages: 10, ApiMessages: 2},
+	}
+
+	if len(report.Days) != len(expected) {
+		t.Fatalf("expected %d days, got %+v", len(expected), report.Days)
+	}
+
+	for i := range expected {
+		if report.Days[i] != expected[i] {
+			t.Fatalf("expected day %+v, got %+v", expected[i], report.Days[i])
+		}
+	}
+
+	total := dto.UsageDay{Date: "total", PeakConnections: 31, WebsocketMessages: 30, ApiMessages: 6}
+	if report.Total != total {
+		t.Fatalf("expected the total %+v, got %+v", total, report.Total)
+	}
+}
+
+func TestStatsHandlerUsageCSV(t *testing.T) {
+	rec := httptest.NewRecorder()
+	newTestStatsRouter().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/apps/1/usage?from=2026-01-31&to=2026-02-01&format=csv", nil))
+
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "text/csv" {
+		t.Fatalf("expected a csv response, got status %d and content type %q", rec.Code, rec.Header().Get("Content-Type"))
+	}
+
+	if disposition := rec.Header().Get("Content-Disposition"); disposition != `attachment; filename="usage-1-20260131-20260202.csv"` {
+		t.Fatalf("expected the report to be downloaded as a file, got %q", disposition)
+	}
+
+	records, err := csv.NewReader(rec.Body).ReadAll()
+	if err != nil {
+		t.Fatalf("error reading the csv: %s", err.Error())
+	}
+
+	expected := [][]string{
+		{"app_id", "date", "connection_minutes", "peak_connections", "websocket_messages", "api_messages", "bytes_received", "bytes_sent"},
+		{"1", "2026-01-31", "0.00", "31", "10", "2", "0", "0"},
+		{"1", "2026-02-01", "0.00", "1", "10", "2", "0", "0"},
+		{"1", "total", "0.00", "31", "20", "4", "0", "0"},
+	}
+
+	if len(records) != len(expected) {
+		t.Fatalf("expected %d rows, got %v", len(expected), records)
+	}
+
+	for i := range expected {
+		for j := range expected[i] {
+			if records[i][j] != expected[i][j] {
+				t.Fatalf("expected row %v, got %v", expected[i], records[i])
+			}
+		}
+	}
+}
+
+func TestStatsHandlerUsageValidatesTheRange(t *testing.T) {
+	router := newTestStatsRouter()
+
+	tests := []struct {
+		query  string
+		status int
+	}{
+		{query: "", status: http.StatusOK},
+		{query: "?from=2026-02-01&to=2026-02-01", status: http.StatusOK},
+		{query: "?from=1769904000&to=2026-02-03T00:00:00Z", status: http.StatusOK},
+		{query: "?from=2025-02-01&to=2026-01-31", status: http.StatusOK},
+		{query: "?from=2025-01-01&to=2026-01-31", status: http.StatusBadRequest},
+		{query: "?from=2026-02-02&to=2026-02-01", status: http.StatusBadRequest},
+		{query: "?from=2026-02-02T00:00:00Z&to=2026-02-02T00:00:00Z", status: http.StatusBadRequest},
+		{query: "?from=yesterday", status: http.StatusBadRequest},
+		{query: "?to=2026-13-01", status: http.StatusBadRequest},
+		{query: "?from=2026-02-01&to=2026-02-01&format=xml", status: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		if code := serve(t, router, http.MethodGet, "/apps/1/usage"+tt.query, "", nil); code != tt.status {
+			t.Fatalf("expected status %d for %q, got %d", tt.status, tt.query, code)
+		}
+	}
+}
@@ -0,0 +1,31 @@
+package handlers
+
+import (
+	"github.com/iamsayantan/larasockets/server/handlers/dto"
+	"github.com/iamsayantan/larasockets/server/rendering"
+	"github.com/iamsayantan/larasockets/statistics"
+	"net/http"
+)
+
+func NewStatusHandler(store statistics.StatsStorage) *StatusHandler {
+	return &StatusHandler{statsStore: store}
+}
+
+// URL /status
+type StatusHandler struct {
+	statsStore statistics.StatsStorage
+}
+
+// GetStatus reports the health of the statistics storage. It responds with 503 while the
+// statistics can not be stored, so it can be used as a health check. The endpoint is public, the
+// errors of the storage are only logged.
+func (h *StatusHandler) GetStatus(w http.ResponseWriter, r *http.Request) {
+	resp := dto.Status{Status: "healthy"}
+	statusCode := http.StatusOK
+	if !h.statsStore.Health().Healthy {
+		resp.Status = "unhealthy"
+		statusCode = http.StatusServiceUnavailable
+	}
+
+	rendering.RenderSuccessWithData(w, resp.Status, statusCode, resp)
+}
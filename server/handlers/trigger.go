@@ -1,8 +1,7 @@
 package handlers
 
 import (
-	"crypto/hmac"
-	"crypto/sha256"
+	"context"
 	"encoding/hex"
 	"encoding/json"
 	"errors"
@@ -11,10 +10,17 @@ import (
 	"github.com/iamsayantan/larasockets/events"
 	"github.com/iamsayantan/larasockets/messages"
 	"github.com/iamsayantan/larasockets/statistics"
+	"github.com/iamsayantan/larasockets/tracing"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 	"net/http"
 	"sort"
 	"strings"
+	"time"
 )
 
 // URL /apps/{appId}/events
@@ -40,25 +46,58 @@ func NewTriggerEventHandler(cm larasockets.ChannelManager, collector statistics.
 func (h *TriggerEventsHandler) HandleEvents(w http.ResponseWriter, r *http.Request) {
 	appId := chi.URLParam(r, "appId")
 	var bodyParams PusherServerEventPayload
-	err := h.verifySignature(r)
+
+	// continue the trace of the caller, laravel sends it in the W3C traceparent header.
+	ctx := larasockets.ContextWithTriggerTime(r.Context(), time.Now())
+	ctx = otel.GetTextMapPropagator().Extract(ctx, propagation.HeaderCarrier(r.Header))
+	ctx, span := tracing.Tracer().Start(ctx, "larasockets.trigger_events",
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(attribute.String("larasockets.app_id", appId)),
+	)
+	defer span.End()
+
+	app := h.channelManager.AppManager().FindById(appId)
+	if app == nil {
+		h.logger.Error("invalid appId. no app found with the given appId", zap.String("application_id", appId))
+		span.SetStatus(codes.Error, "application not found")
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	secretVersion, err := h.verifySignature(app, r)
 	if err != nil {
 		h.logger.Error("error verifying authentication signature", zap.String("error", err.Error()))
+		span.SetStatus(codes.Error, "invalid auth signature")
 		w.WriteHeader(http.StatusUnauthorized)
 		_, _ = w.Write([]byte(err.Error()))
+		return
+	}
+
+	if secretVersion != app.SecretVersion() {
+		h.logger.Info("request signed with a previous app secret",
+			zap.String("application_id", appId),
+			zap.String("secret_version", secretVersion),
+		)
 	}
 
 	err = json.NewDecoder(r.Body).Decode(&bodyParams)
 	if err != nil {
 		h.logger.Error("error decoding json request.", zap.String("error", err.Error()))
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "invalid request body")
 		w.WriteHeader(http.StatusBadRequest)
 		_, _ = w.Write([]byte(err.Error()))
 		return
 	}
 
 	h.collector.HandleApiMessage(appId)
+	span.SetAttributes(
+		attribute.String("larasockets.event", bodyParams.Name),
+		attribute.StringSlice("larasockets.channels", bodyParams.Channels),
+	)
 
 	for _, channelName := range bodyParams.Channels {
-		channel := h.channelManager.FindChannel(appId, channelName)
+		channel := h.findChannel(ctx, appId, channelName)
 		if channel == nil {
 			h.logger.Info("channel not found", zap.String("channel_name", channelName), zap.String("application_id", appId))
 			continue
@@ -79,24 +118,37 @@ func (h *TriggerEventsHandler) HandleEvents(w http.ResponseWriter, r *http.Reque
 		})
 
 		if bodyParams.SocketId == "" {
-			channel.Broadcast(payload)
+			channel.Broadcast(ctx, payload)
 		} else {
-			channel.BroadcastExcept(payload, bodyParams.SocketId)
+			channel.BroadcastExcept(ctx, payload, bodyParams.SocketId)
 		}
+
+		h.collector.HandleChannelMessage(appId, channelName, bodyParams.Name, len(bodyParams.Data), len(channel.Connections()))
 	}
 
 	w.WriteHeader(http.StatusOK)
 	return
 }
 
-// verifySignature validates the auth signature from the incoming request.
+// findChannel looks up the channel of the app within its own span, so a trace shows when an
+// event was not delivered because nobody was subscribed to the channel.
+func (h *TriggerEventsHandler) findChannel(ctx context.Context, appId, channelName string) larasockets.Channel {
+	_, span := tracing.Tracer().Start(ctx, "larasockets.channel.lookup", trace.WithAttributes(
+		attribute.String("larasockets.channel", channelName),
+	))
+	defer span.End()
+
+	channel := h.channelManager.FindChannel(appId, channelName)
+	span.SetAttributes(attribute.Bool("larasockets.channel_found", channel != nil))
+
+	return channel
+}
+
+// verifySignature validates the auth signature from the incoming request and returns the version
+// of the app secret it was signed with.
 // See https://pusher.com/docs/channels/library_auth_reference/rest-api#Authentication for more implementation
 // details.
-func (h *TriggerEventsHandler) verifySignature(r *http.Request) error {
-	// Whether the appId is valid will be checked in the middleware layer, so at this
-	// point it is safe to assume that a valid app exists with the given appId.
-	app := h.channelManager.AppManager().FindById(chi.URLParam(r, "appId"))
-
+func (h *TriggerEventsHandler) verifySignature(app *larasockets.Application, r *http.Request) (string, error) {
 	queryParams := r.URL.Query()
 	queryParamsKeys := make([]string, 0)
 	authSignature := queryParams.Get("auth_signature")
@@ -131,15 +183,13 @@ func (h *TriggerEventsHandler) verifySignature(r *http.Request) error {
 
 	incomingSignature, err := hex.DecodeString(authSignature)
 	if err != nil {
-		return err
+		return "", err
 	}
 
-	hashToSign := hmac.New(sha256.New, []byte(app.Secret()))
-	hashToSign.Write([]byte(signatureString.String()))
-
-	if valid := hmac.Equal(hashToSign.Sum(nil), incomingSignature); !valid {
-		return errors.New("invalid auth signature")
+	secretVersion, valid := app.VerifySignature(signatureString.String(), incomingSignature)
+	if !valid {
+		return "", errors.New("invalid auth signature")
 	}
 
-	return nil
+	return secretVersion, nil
 }
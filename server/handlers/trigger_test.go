@@ -0,0 +1,206 @@
+package handlers
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/go-chi/chi"
+	"github.com/iamsayantan/larasockets"
+	"github.com/iamsayantan/larasockets/app_managers"
+	"github.com/iamsayantan/larasockets/channel_managers"
+	"github.com/iamsayantan/larasockets/config"
+	"github.com/iamsayantan/larasockets/events"
+	"github.com/iamsayantan/larasockets/statistics/collectors"
+	"github.com/iamsayantan/larasockets/statistics/stores"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type testConnection struct {
+	id  string
+	app *larasockets.Application
+
+	mu       sync.Mutex
+	received []*larasockets.EncodedMessage
+}
+
+func (c *testConnection) Id() string                    { return c.id }
+func (c *testConnection) App() *larasockets.Application { return c.app }
+func (c *testConnection) Receive()                      {}
+func (c *testConnection) Close()                        {}
+
+func (c *testConnection) Send(data interface{}) {
+	message, ok := data.(*larasockets.EncodedMessage)
+	if !ok {
+		return
+	}
+
+	c.mu.Lock()
+	c.received = append(c.received, message)
+	c.mu.Unlock()
+}
+
+func (c *testConnection) Disconnect(ctx context.Context, code int, message string) {}
+
+func (c *testConnection) Info() larasockets.ConnectionInfo {
+	return larasockets.ConnectionInfo{Id: c.id, AppId: c.app.Id()}
+}
+
+// recordSpans installs a tracer provider that records the spans and the W3C trace context
+// propagator, both are reset when the test ends.
+func recordSpans(t *testing.T) *tracetest.SpanRecorder {
+	t.Helper()
+
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() {
+		otel.SetTracerProvider(trace.NewNoopTracerProvider())
+		otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator())
+		_ = provider.Shutdown(context.Background())
+	})
+
+	return recorder
+}
+
+// signedEventsRequest returns a request triggering the event on the channel of app 1, signed like
+// the pusher http libraries sign it.
+func signedEventsRequest(secret, body string) *http.Request {
+	query := url.Values{}
+	query.Set("auth_key", "key1")
+	query.Set("auth_timestamp", "1600000000")
+	query.Set("auth_version", "1.0")
+
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	params := make([]string, 0, len(keys))
+	for _, key := range keys {
+		params = append(params, key+"="+query.Get(key))
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("POST\n/apps/1/events\n" + strings.Join(params, "&")))
+	query.Set("auth_signature", hex.EncodeToString(mac.Sum(nil)))
+
+	return httptest.NewRequest(http.MethodPost, "/apps/1/events?"+query.Encode(), strings.NewReader(body))
+}
+
+func newTestTriggerRouter(t *testing.T) (http.Handler, *testConnection) {
+	t.Helper()
+
+	apps := app_managers.NewConfigManager([]config.AppConfig{{ID: "1", Key: "key1", Secret: "secret1"}})
+	cm := channel_managers.NewLocalManager(apps, zap.NewNop())
+	events.RecentLogs.Forget("1")
+
+	collector := collectors.NewMemoryCollector(cm, stores.NewNullStorage(), time.Hour)
+	t.Cleanup(collector.Stop)
+
+	conn := &testConnection{id: "1.1", app: apps.FindById("1")}
+	if err := cm.SubscribeToChannel(conn, "chat", struct{}{}); err != nil {
+		t.Fatalf("error subscribing to the channel: %s", err.Error())
+	}
+
+	r := chi.NewRouter()
+	r.Post("/apps/{appId}/events", NewTriggerEventHandler(cm, collector, zap.NewNop()).HandleEvents)
+
+	return r, conn
+}
+
+func TestTriggerEventsContinuesTheTraceOfTheCaller(t *testing.T) {
+	recorder := recordSpans(t)
+	router, conn := newTestTriggerRouter(t)
+
+	traceId, _ := trace.TraceIDFromHex("4bf92f3577b34da6a3ce929d0e0e4736")
+	parentId, _ := trace.SpanIDFromHex("00f067aa0ba902b7")
+
+	req := signedEventsRequest("secret1", `{"name":"message","channels":["chat","empty"],"data":"{}"}`)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+
+	spans := make(map[string][]sdktrace.ReadOnlySpan)
+	for _, span := range recorder.Ended() {
+		if span.SpanContext().TraceID() != traceId {
+			t.Fatalf("expected span %s to be part of the trace of the caller, got trace %s", span.Name(), span.SpanContext().TraceID())
+		}
+
+		spans[span.Name()] = append(spans[span.Name()], span)
+	}
+
+	root := spans["larasockets.trigger_events"]
+	if len(root) != 1 || root[0].Parent().SpanID() != parentId || !root[0].Parent().IsRemote() {
+		t.Fatalf("expected the trigger span to be a child of the span of the caller, got %+v", root)
+	}
+
+	if lookups := spans["larasockets.channel.lookup"]; len(lookups) != 2 || lookups[0].Parent().SpanID() != root[0].SpanContext().SpanID() {
+		t.Fatalf("expected a lookup span per channel below the trigger span, got %d", len(lookups))
+	}
+
+	broadcasts := spans["larasockets.channel.broadcast"]
+	if len(broadcasts) != 1 || broadcasts[0].Parent().SpanID() != root[0].SpanContext().SpanID() {
+		t.Fatalf("expected a broadcast span for the channel with a subscriber, got %d", len(broadcasts))
+	}
+
+	// the message carries the trace to the write of the connection.
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+
+	if len(conn.received) != 1 {
+		t.Fatalf("expected the subscriber to get 1 message, got %d", len(conn.received))
+	}
+
+	message := conn.received[0]
+	if spanContext := trace.SpanContextFromContext(message.Context()); spanContext.TraceID() != traceId {
+		t.Fatalf("expected the message to be part of the trace of the caller, got trace %s", spanContext.TraceID())
+	}
+
+	if _, ok := larasockets.TriggerTimeFromContext(message.Context()); !ok {
+		t.Fatal("expected the message to carry the time it was triggered at")
+	}
+}
+
+func TestTriggerEventsStartsATraceWithoutTraceparent(t *testing.T) {
+	recorder := recordSpans(t)
+	router, _ := newTestTriggerRouter(t)
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, signedEventsRequest("secret1", `{"name":"message","channels":["chat"],"data":"{}"}`))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+
+	for _, span := range recorder.Ended() {
+		if span.Name() == "larasockets.trigger_events" && span.Parent().IsValid() {
+			t.Fatalf("expected the trigger span to start a new trace, got parent %s", span.Parent().SpanID())
+		}
+	}
+
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, signedEventsRequest("wrong", `{"name":"message","channels":["chat"],"data":"{}"}`))
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected status %d for a wrong signature, got %d", http.StatusUnauthorized, rec.Code)
+	}
+}
@@ -1,17 +1,23 @@
 package server
 
 import (
+	"context"
 	"github.com/go-chi/chi"
 	"github.com/go-chi/chi/middleware"
 	"github.com/go-chi/cors"
 	"github.com/gorilla/websocket"
 	"github.com/iamsayantan/larasockets"
+	"github.com/iamsayantan/larasockets/config"
 	"github.com/iamsayantan/larasockets/events"
+	"github.com/iamsayantan/larasockets/server/dashboard"
 	"github.com/iamsayantan/larasockets/server/handlers"
 	"github.com/iamsayantan/larasockets/server/handlers/middlewares"
 	"github.com/iamsayantan/larasockets/statistics"
 	"go.uber.org/zap"
+	"net"
 	"net/http"
+	"strings"
+	"sync/atomic"
 )
 
 var upgrader = websocket.Upgrader{
@@ -24,12 +30,21 @@ var upgrader = websocket.Upgrader{
 
 type Server struct {
 	logger *zap.Logger
+	config config.ServerConfig
 	router chi.Router
 	hub    *Hub
 
 	statsStore     statistics.StatsStorage
 	collector      statistics.StatsCollector
 	channelManager larasockets.ChannelManager
+
+	// trustedProxies are the networks of the proxies whose X-Forwarded-For header is used as
+	// the remote address of the connections.
+	trustedProxies []*net.IPNet
+
+	// draining is set to 1 once the server starts shutting down, new websocket
+	// connections are refused from then on.
+	draining int32
 }
 
 func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -37,6 +52,11 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *Server) ServeWS(w http.ResponseWriter, r *http.Request) {
+	if atomic.LoadInt32(&s.draining) == 1 {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+
 	appKey := chi.URLParam(r, "appKey")
 	if appKey == "" {
 		s.logger.Error("no appKey found on url path")
@@ -57,7 +77,7 @@ func (s *Server) ServeWS(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	wsConn := NewConnection(s.hub, app, conn, s.collector, s.logger)
+	wsConn := NewConnection(s.hub, app, conn, s.connectionInfo(r), s.config.SendQueue, s.collector, s.logger)
 	s.hub.register <- wsConn
 
 	s.logger.Info("received new websocket connection", zap.String("connection_id", wsConn.Id()), zap.String("application_id", app.Id()))
@@ -66,14 +86,96 @@ func (s *Server) ServeWS(w http.ResponseWriter, r *http.Request) {
 	wsConn.Send(connResp)
 }
 
-func NewServer(logger *zap.Logger, cm larasockets.ChannelManager, collector statistics.StatsCollector, store statistics.StatsStorage) *Server {
+// Shutdown drains the server. New websocket connections are refused, the collected stats are
+// flushed to the storage and the connected clients are asked to reconnect, to another node
+// behind the load balancer, in jittered batches. It returns once every connection is closed
+// or ctx is done.
+func (s *Server) Shutdown(ctx context.Context) {
+	atomic.StoreInt32(&s.draining, 1)
+	s.collector.Stop()
+
+	// see https://pusher.com/docs/channels/library_auth_reference/pusher-websockets-protocol#error-codes
+	s.hub.Drain(ctx, 4200, "server is shutting down, please reconnect", s.config.Shutdown.BatchSize, s.config.Shutdown.BatchInterval)
+}
+
+// Mount serves the handler on the given path next to the routes of the server.
+func (s *Server) Mount(pattern string, handler http.Handler) {
+	s.router.Handle(pattern, handler)
+}
+
+// DisconnectApp disconnects all the connections made to the app with the given pusher code.
+func (s *Server) DisconnectApp(appId string, code int, message string) {
+	s.hub.DisconnectApp(appId, code, message)
+}
+
+// connectionInfo reads the metadata of a websocket connection from the request it was made with.
+func (s *Server) connectionInfo(r *http.Request) larasockets.ConnectionInfo {
+	query := r.URL.Query()
+
+	return larasockets.ConnectionInfo{
+		RemoteIP:      s.remoteIP(r),
+		UserAgent:     r.UserAgent(),
+		Origin:        r.Header.Get("Origin"),
+		Client:        query.Get("client"),
+		ClientVersion: query.Get("version"),
+		Protocol:      query.Get("protocol"),
+	}
+}
+
+// remoteIP returns the address of the client. Behind trusted proxies it is the last address of
+// X-Forwarded-For that was not added by a trusted proxy, so clients can not spoof it.
+func (s *Server) remoteIP(r *http.Request) string {
+	remote, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		remote = r.RemoteAddr
+	}
+
+	if !s.isTrustedProxy(remote) {
+		return remote
+	}
+
+	forwarded := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		address := strings.TrimSpace(forwarded[i])
+		if address == "" {
+			continue
+		}
+
+		remote = address
+		if !s.isTrustedProxy(address) {
+			break
+		}
+	}
+
+	return remote
+}
+
+func (s *Server) isTrustedProxy(address string) bool {
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return false
+	}
+
+	for _, network := range s.trustedProxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func NewServer(logger *zap.Logger, cfg config.ServerConfig, cm larasockets.ChannelManager, socketIds larasockets.SocketIdGenerator, collector statistics.StatsCollector, store statistics.StatsStorage, users larasockets.UserManager) *Server {
 	server := &Server{}
 
+	server.config = cfg
 	server.channelManager = cm
 	server.logger = logger
 	server.collector = collector
 	server.statsStore = store
-	server.hub = NewHub(logger, cm)
+	server.hub = NewHub(logger, cm, socketIds)
+	// the trusted proxies are validated with the rest of the configuration.
+	server.trustedProxies, _ = cfg.TrustedProxyNetworks()
 
 	corsHandler := cors.New(cors.Options{
 		AllowedOrigins: []string{"*"},
@@ -87,25 +189,81 @@ func NewServer(logger *zap.Logger, cm larasockets.ChannelManager, collector stat
 	r.Use(corsHandler.Handler)
 
 	triggerHandler := handlers.NewTriggerEventHandler(server.channelManager, server.collector, server.logger)
-	dashboardHandler := handlers.NewDashboardHandler(server.channelManager, server.collector)
+	dashboardHandler := handlers.NewDashboardHandler(server.channelManager, server.collector, users, cfg.Dashboard)
 	statsHandler := handlers.NewStatsHandler(store, collector)
+	statusHandler := handlers.NewStatusHandler(store)
+	logsHandler := handlers.NewLogsHandler(events.RecentLogs)
+	connectionsHandler := handlers.NewConnectionsHandler(server.hub)
 
-	authMiddleware := middlewares.NewAuthMiddleware(cm.AppManager())
+	authMiddleware := middlewares.NewAuthMiddleware(cm.AppManager(), users, []byte(cfg.Dashboard.TokenKey), cfg.Dashboard.AppSecretLogin)
 
 	r.Get("/app/{appKey}", server.ServeWS)
 	r.Post("/apps/{appId}/events", triggerHandler.HandleEvents)
 
-	// Authenticated routes are grouped here.
+	// Authenticated routes are grouped here by the role they need for the app.
 	r.Group(func(r chi.Router) {
-		r.Use(authMiddleware.Handler)
+		r.Use(authMiddleware.Require(larasockets.RoleViewer))
 		r.Post("/apps/{appId}/authorize-channels", dashboardHandler.AuthorizeChannelRequest)
-		r.Post("/apps/{appId}/trigger-events", dashboardHandler.TriggerEvent)
 		r.Get("/apps/{appId}/daily-stats", statsHandler.GetStatForToday)
 		r.Get("/apps/{appId}/graph", statsHandler.GetStatsForGraph)
+		r.Get("/apps/{appId}/top-channels", statsHandler.GetTopChannels)
+		r.Get("/apps/{appId}/top-events", statsHandler.GetTopEvents)
+		r.Get("/apps/{appId}/logs", logsHandler.GetLogs)
+		r.Get("/apps/{appId}/connections", connectionsHandler.AllConnections)
+		r.Get("/apps/{appId}/connections/{socketId}", connectionsHandler.GetConnection)
+	})
+
+	r.Group(func(r chi.Router) {
+		r.Use(authMiddleware.Require(larasockets.RoleOperator))
+		r.Post("/apps/{appId}/trigger-events", dashboardHandler.TriggerEvent)
+		r.Post("/apps/{appId}/connections/{socketId}/disconnect", connectionsHandler.Disconnect)
 	})
 
+	r.Group(func(r chi.Router) {
+		r.Use(authMiddleware.Require(larasockets.RoleAdmin))
+		r.Get("/apps/{appId}/usage", statsHandler.GetUsage)
+	})
+
+	// The admin api is only available when the apps can be changed and an admin token is set.
+	if appStore, ok := cm.AppManager().(larasockets.ApplicationStore); ok && cfg.Admin.Token != "" {
+		adminHandler := handlers.NewAdminHandler(appStore, server.hub, server.logger)
+		adminAuthMiddleware := middlewares.NewAdminAuthMiddleware(cfg.Admin.Token)
+
+		r.Route("/admin/apps", func(r chi.Router) {
+			r.Use(adminAuthMiddleware.Handler)
+			r.Get("/", adminHandler.AllApps)
+			r.Post("/", adminHandler.CreateApp)
+			r.Put("/{appId}", adminHandler.UpdateApp)
+			r.Post("/{appId}/disable", adminHandler.DisableApp)
+			r.Post("/{appId}/enable", adminHandler.EnableApp)
+			r.Delete("/{appId}", adminHandler.DeleteApp)
+		})
+	}
+
+	// The dashboard users can be changed the same way, when they are kept in the database.
+	if userStore, ok := users.(larasockets.UserStore); ok && cfg.Admin.Token != "" {
+		adminUsersHandler := handlers.NewAdminUsersHandler(userStore, server.logger)
+		adminAuthMiddleware := middlewares.NewAdminAuthMiddleware(cfg.Admin.Token)
+
+		r.Route("/admin/users", func(r chi.Router) {
+			r.Use(adminAuthMiddleware.Handler)
+			r.Get("/", adminUsersHandler.AllUsers)
+			r.Post("/", adminUsersHandler.CreateUser)
+			r.Put("/{username}", adminUsersHandler.UpdateUser)
+			r.Delete("/{username}", adminUsersHandler.DeleteUser)
+		})
+	}
+
+	r.Get("/status", statusHandler.GetStatus)
 	r.Get("/dashboard/apps", dashboardHandler.AllApps)
 	r.Post("/dashboard/apps/authorize", dashboardHandler.AuthorizeConnectionRequest)
+	r.Post("/dashboard/login", dashboardHandler.Login)
+
+	if cfg.Dashboard.Enabled {
+		r.Get(cfg.Dashboard.Path, http.RedirectHandler(cfg.Dashboard.Path+"/", http.StatusMovedPermanently).ServeHTTP)
+		r.Handle(cfg.Dashboard.Path+"/*", dashboard.Handler(cfg.Dashboard.Path))
+	}
+
 	server.router = r
 
 	return server
@@ -1,14 +1,20 @@
 package server
 
 import (
+	"context"
 	"github.com/iamsayantan/larasockets"
 	"go.uber.org/zap"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
 )
 
 // Hub
 type Hub struct {
 	logger        *zap.Logger
 	channelManger larasockets.ChannelManager
+	socketIds     larasockets.SocketIdGenerator
 
 	// connections holds all the active connections to our servers. Each connection is
 	// assigned an unique id, so this is a map whose key is the id of the connection.
@@ -21,16 +27,22 @@ type Hub struct {
 	// unregister channel listens for connections that are being closed so that they
 	// can be removed.
 	unregister chan larasockets.Connection
+
+	// snapshot channel listens for requests of the current connections. The connections
+	// are sent back on the requesting channel.
+	snapshot chan chan []larasockets.Connection
 }
 
 // NewHub returns pointer to a new Hub instance.
-func NewHub(logger *zap.Logger, cm larasockets.ChannelManager) *Hub {
+func NewHub(logger *zap.Logger, cm larasockets.ChannelManager, socketIds larasockets.SocketIdGenerator) *Hub {
 	hub := &Hub{
 		logger:        logger,
 		channelManger: cm,
+		socketIds:     socketIds,
 		connections:   make(map[string]larasockets.Connection, 0),
 		register:      make(chan larasockets.Connection),
 		unregister:    make(chan larasockets.Connection),
+		snapshot:      make(chan chan []larasockets.Connection),
 	}
 
 	go hub.run()
@@ -42,18 +54,159 @@ func (h *Hub) run() {
 	for {
 		select {
 		case conn := <-h.register:
+			if _, ok := h.connections[conn.Id()]; ok {
+				h.logger.Error("duplicate socket id, replacing the existing connection", zap.String("connection_id", conn.Id()))
+			}
+
 			h.connections[conn.Id()] = conn
 		case conn := <-h.unregister:
 			if _, ok := h.connections[conn.Id()]; ok {
 				delete(h.connections, conn.Id())
 			}
+		case resp := <-h.snapshot:
+			connections := make([]larasockets.Connection, 0, len(h.connections))
+			for _, conn := range h.connections {
+				connections = append(connections, conn)
+			}
+
+			resp <- connections
 		}
 	}
 }
 
+// Connections returns all the connections that are currently active in the hub.
+func (h *Hub) Connections() []larasockets.Connection {
+	resp := make(chan []larasockets.Connection, 1)
+	h.snapshot <- resp
+
+	return <-resp
+}
+
 // RemoveConnection will remove the connection from all the channels, and also remove it
 // form the hub.
 func (h *Hub) RemoveConnection(conn larasockets.Connection) {
 	h.channelManger.UnsubscribeFromAllChannels(conn)
 	h.unregister <- conn
 }
+
+// DisconnectApp disconnects all the connections of the app with the given pusher code. The
+// connections are closed in the background.
+func (h *Hub) DisconnectApp(appId string, code int, message string) {
+	for _, conn := range h.Connections() {
+		if conn.App().Id() != appId {
+			continue
+		}
+
+		go func(conn larasockets.Connection) {
+			ctx, cancel := context.WithTimeout(context.Background(), writeWait)
+			defer cancel()
+
+			conn.Disconnect(ctx, code, message)
+		}(conn)
+	}
+}
+
+// AppConnections returns the info of all the connections of the app, with the channels they are
+// subscribed to, the oldest connection first.
+func (h *Hub) AppConnections(appId string) []larasockets.ConnectionInfo {
+	subscriptions := make(map[string][]string)
+	for _, channel := range h.channelManger.AllChannels(appId) {
+		for _, conn := range channel.Connections() {
+			subscriptions[conn.Id()] = append(subscriptions[conn.Id()], channel.Name())
+		}
+	}
+
+	infos := make([]larasockets.ConnectionInfo, 0)
+	for _, conn := range h.Connections() {
+		if conn.App().Id() != appId {
+			continue
+		}
+
+		info := conn.Info()
+		info.Channels = subscriptions[conn.Id()]
+		if info.Channels == nil {
+			info.Channels = make([]string, 0)
+		}
+
+		sort.Strings(info.Channels)
+		infos = append(infos, info)
+	}
+
+	sort.Slice(infos, func(i, j int) bool {
+		if infos[i].ConnectedAt.Equal(infos[j].ConnectedAt) {
+			return infos[i].Id < infos[j].Id
+		}
+
+		return infos[i].ConnectedAt.Before(infos[j].ConnectedAt)
+	})
+
+	return infos
+}
+
+// FindConnection returns the connection of the app with the given socket id, or nil.
+func (h *Hub) FindConnection(appId, connectionId string) larasockets.Connection {
+	for _, conn := range h.Connections() {
+		if conn.Id() == connectionId && conn.App().Id() == appId {
+			return conn
+		}
+	}
+
+	return nil
+}
+
+// DisconnectConnection disconnects the connection of the app with the given pusher code in the
+// background. It reports whether the connection was found.
+func (h *Hub) DisconnectConnection(appId, connectionId string, code int, message string) bool {
+	conn := h.FindConnection(appId, connectionId)
+	if conn == nil {
+		return false
+	}
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), writeWait)
+		defer cancel()
+
+		conn.Disconnect(ctx, code, message)
+	}()
+
+	return true
+}
+
+// Drain disconnects all the connections with the given pusher code, batchSize connections at a
+// time with a random pause of up to interval between the batches, so the clients do not all
+// reconnect to the other nodes at the same moment. It returns once every connection is closed
+// or ctx is done.
+func (h *Hub) Drain(ctx context.Context, code int, message string, batchSize int, interval time.Duration) {
+	connections := h.Connections()
+	h.logger.Info("draining connections", zap.Int("connections", len(connections)))
+
+	var wg sync.WaitGroup
+	for start := 0; start < len(connections); start += batchSize {
+		end := start + batchSize
+		if end > len(connections) {
+			end = len(connections)
+		}
+
+		for _, conn := range connections[start:end] {
+			wg.Add(1)
+			go func(conn larasockets.Connection) {
+				defer wg.Done()
+				conn.Disconnect(ctx, code, message)
+			}(conn)
+		}
+
+		if end == len(connections) || interval <= 0 {
+			continue
+		}
+
+		select {
+		case <-time.After(time.Duration(rand.Int63n(int64(interval)))):
+		case <-ctx.Done():
+			h.logger.Warn("drain deadline reached", zap.Int("remaining_connections", len(connections)-end))
+			wg.Wait()
+			return
+		}
+	}
+
+	wg.Wait()
+}
@@ -0,0 +1,47 @@
+package socket_ids
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"github.com/iamsayantan/larasockets"
+	"strconv"
+	"sync/atomic"
+)
+
+// maxRandomPrefix bounds the prefix picked when no node id is configured, it keeps the
+// socket ids in the same shape as the ones issued by pusher.
+const maxRandomPrefix = 100000
+
+type sequentialGenerator struct {
+	prefix  string
+	counter uint64
+}
+
+// NewSequentialGenerator returns a SocketIdGenerator that issues ids in the "<prefix>.<sequence>"
+// format pusher-js expects. The prefix is the node id, so ids never collide between the nodes
+// of a cluster. If nodeId is zero a random prefix is picked instead. The sequence is increased
+// for every id and starts at a random offset, so ids of a restarted process do not repeat the
+// ids of its previous run.
+func NewSequentialGenerator(nodeId uint64) larasockets.SocketIdGenerator {
+	if nodeId == 0 {
+		nodeId = randomUint64()%(maxRandomPrefix-1) + 1
+	}
+
+	return &sequentialGenerator{
+		prefix:  strconv.FormatUint(nodeId, 10) + ".",
+		counter: randomUint64() >> 16,
+	}
+}
+
+func (g *sequentialGenerator) Generate() string {
+	return g.prefix + strconv.FormatUint(atomic.AddUint64(&g.counter, 1), 10)
+}
+
+func randomUint64() uint64 {
+	var b [8]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic("socket_ids: could not read random bytes: " + err.Error())
+	}
+
+	return binary.BigEndian.Uint64(b[:])
+}
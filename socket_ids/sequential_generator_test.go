@@ -0,0 +1,61 @@
+package socket_ids
+
+import (
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+)
+
+var socketIdFormat = regexp.MustCompile(`^\d+\.\d+$`)
+
+func TestSequentialGeneratorConcurrently(t *testing.T) {
+	tests := []struct {
+		name   string
+		nodeId uint64
+		prefix string
+	}{
+		{name: "random prefix", nodeId: 0},
+		{name: "node id", nodeId: 42, prefix: "42."},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			generator := NewSequentialGenerator(tt.nodeId)
+
+			workers, perWorker := 16, 1000
+			generated := make([][]string, workers)
+
+			var wg sync.WaitGroup
+			for i := range generated {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					for j := 0; j < perWorker; j++ {
+						generated[i] = append(generated[i], generator.Generate())
+					}
+				}(i)
+			}
+			wg.Wait()
+
+			seen := make(map[string]bool, workers*perWorker)
+			for _, ids := range generated {
+				for _, id := range ids {
+					if !socketIdFormat.MatchString(id) {
+						t.Fatalf("expected the socket id to match %s, got %q", socketIdFormat, id)
+					}
+
+					if !strings.HasPrefix(id, tt.prefix) {
+						t.Fatalf("expected the socket id to start with %q, got %q", tt.prefix, id)
+					}
+
+					if seen[id] {
+						t.Fatalf("expected the socket ids to be unique, got %q twice", id)
+					}
+
+					seen[id] = true
+				}
+			}
+		})
+	}
+}
@@ -1,17 +1,57 @@
 package statistics
 
+import "time"
+
+// Results of a webhook delivery, see StatsCollector.HandleWebhookDelivery.
+const (
+	WebhookDeliverySuccess = "success"
+	WebhookDeliveryFailure = "failure"
+)
+
 // StatsCollector interface defines the methods for a collector.
 // A collector is a temporary storage of incoming statistics until they can be
 // dumped in a more permanent storage, usually a MySQL or a time-series database.
 // Statistics are stored on per application basis. So all the methods takes the id of
 // the application as a parameter.
 type StatsCollector interface {
-	// HandleWebsocketMessage collects all the outgoing websocket messages.
-	HandleWebsocketMessage(appId string)
+	// HandleWebsocketMessage collects all the outgoing websocket messages along with their
+	// size in bytes.
+	HandleWebsocketMessage(appId string, bytes int)
+
+	// HandleIncomingWebsocketMessage collects all the messages received from the websocket
+	// connections along with their size in bytes.
+	HandleIncomingWebsocketMessage(appId string, bytes int)
+
+	// HandleDeliveryLatency collects the time from the api request that triggered a message to
+	// the write of the message to a websocket connection.
+	HandleDeliveryLatency(appId string, latency time.Duration)
+
+	// HandleWriteDuration collects the time writing a message to a websocket connection took.
+	HandleWriteDuration(appId string, duration time.Duration)
 
 	// HandleApiMessage collects all the incoming api message.
 	HandleApiMessage(appId string)
 
+	// HandleChannelMessage collects a message of the event sent to the subscribers of a channel,
+	// bytes is the size of the message data.
+	HandleChannelMessage(appId string, channel string, event string, bytes int, subscribers int)
+
+	// HandleDroppedMessage collects the outgoing messages that were discarded because the
+	// send queue of a connection was full.
+	HandleDroppedMessage(appId string)
+
+	// HandleSendQueueDepth collects the number of messages waiting in the send queue of a
+	// connection of the app.
+	HandleSendQueueDepth(appId string, depth int)
+
+	// HandleSubscriptionFailure collects the channel subscriptions that were rejected, reason
+	// is a short machine readable description of why.
+	HandleSubscriptionFailure(appId string, reason string)
+
+	// HandleWebhookDelivery collects the outcome of every attempt to send a webhook for the app,
+	// result is either WebhookDeliverySuccess or WebhookDeliveryFailure.
+	HandleWebhookDelivery(appId string, result string)
+
 	// HandleConnection collects all the new connections made to the server.
 	HandleConnection(appId string)
 
@@ -29,15 +69,21 @@ type StatsCollector interface {
 	GetAppStatistics(appId string) Statistic
 
 	// RegisterStatsListener will register a listener to listen for all the change in statistics
-	// for an app.
-	RegisterStatsListener(listener StatsCollectionListener)
+	// for an app. The events are delivered asynchronously, up to buffer events are queued for a
+	// listener that is busy.
+	RegisterStatsListener(listener StatsCollectionListener, buffer int)
 
 	// DumpToStorage will dump all the available stats to some permanent storage.
 	DumpToStorage(store StatsStorage)
+
+	// Stop stops the periodic dump to the storage, after dumping the collected stats
+	// one last time, and waits for the listeners to handle the events queued for them.
+	Stop()
 }
 
 // StatsCollectionListener interface should be implemented by all the types which want to
-// listen for the changes in stats.
+// listen for the changes in stats. Every event is one of the StatEvent types published by
+// the collector.
 type StatsCollectionListener interface {
-	ListenStatChanged(stat Statistic)
+	ListenStatChanged(event StatEvent)
 }
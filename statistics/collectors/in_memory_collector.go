@@ -3,17 +3,20 @@ package collectors
 import (
 	"github.com/iamsayantan/larasockets"
 	"github.com/iamsayantan/larasockets/statistics"
-	"github.com/iamsayantan/larasockets/statistics/events"
+	"sync"
 	"time"
 )
 
-// NewMemoryCollector returns a new stats collector that stores the data in memory.
-func NewMemoryCollector(cm larasockets.ChannelManager, store statistics.StatsStorage) statistics.StatsCollector {
+// NewMemoryCollector returns a new stats collector that stores the data in memory and dumps it to
+// the store every dumpInterval.
+func NewMemoryCollector(cm larasockets.ChannelManager, store statistics.StatsStorage, dumpInterval time.Duration) statistics.StatsCollector {
 	collector := &memoryCollector{
-		stats:     make(map[string]*statistics.Statistic),
-		listeners: make([]statistics.StatsCollectionListener, 0),
-		store:     store,
-		cm:        cm,
+		bus:          statistics.NewEventBus(),
+		store:        store,
+		cm:           cm,
+		dumpInterval: dumpInterval,
+		stopCh:       make(chan struct{}),
+		stoppedCh:    make(chan struct{}),
 	}
 
 	go collector.periodicDumpToStorage()
@@ -22,84 +25,166 @@ func NewMemoryCollector(cm larasockets.ChannelManager, store statistics.StatsSto
 }
 
 type memoryCollector struct {
-	stats     map[string]*statistics.Statistic
-	listeners []statistics.StatsCollectionListener
-	store     statistics.StatsStorage
-	cm        larasockets.ChannelManager
+	// stats maps the app ids to their *statistics.Counters. The hooks are called from every
+	// connection goroutine, so neither the map nor the counters take a lock.
+	stats        sync.Map
+	bus          *statistics.EventBus
+	store        statistics.StatsStorage
+	cm           larasockets.ChannelManager
+	dumpInterval time.Duration
+
+	// dumpMu makes sure the counters are not snapshot twice at the same time, which would split
+	// a dump interval into two statistics.
+	dumpMu    sync.Mutex
+	stopOnce  sync.Once
+	stopCh    chan struct{}
+	stoppedCh chan struct{}
 }
 
 func (c *memoryCollector) DumpToStorage(store statistics.StatsStorage) {
-	for _, stat := range c.stats {
-		if stat.CanRemoveStatistics() {
-			delete(c.stats, stat.AppId())
-			return
+	c.dumpMu.Lock()
+	defer c.dumpMu.Unlock()
+
+	c.stats.Range(func(key, value interface{}) bool {
+		counters := value.(*statistics.Counters)
+
+		// Nothing is stored for an app without any activity. Its counters are only removed once
+		// the app was deleted, as a hook racing with the removal could count into the removed
+		// counters.
+		if counters.IsIdle() {
+			if c.cm.AppManager().FindById(counters.AppId()) == nil {
+				c.stats.Delete(key)
+			}
+
+			return true
 		}
 
-		store.Store(*stat)
-		events.StatisticsUpdated(c.cm, *stat)
+		stat := counters.Snapshot()
+		store.Store(stat)
+		c.bus.Publish(statistics.StatisticsCollected{Statistic: stat, Time: time.Now()})
 
-		concurrentConnections := c.cm.ConcurrentConnectionsForApp(stat.AppId())
-		stat.Reset(concurrentConnections)
-	}
+		return true
+	})
+}
+
+func (c *memoryCollector) HandleWebsocketMessage(appId string, bytes int) {
+	counters := c.findOrMake(appId)
+	counters.HandleNewWebsocketMessage()
+	counters.HandleBytesSent(bytes)
+}
+
+func (c *memoryCollector) HandleIncomingWebsocketMessage(appId string, bytes int) {
+	counters := c.findOrMake(appId)
+	counters.HandleNewWebsocketMessage()
+	counters.HandleBytesReceived(bytes)
+}
+
+func (c *memoryCollector) HandleDeliveryLatency(appId string, latency time.Duration) {
+	c.findOrMake(appId).HandleDeliveryLatency(latency)
+}
+
+func (c *memoryCollector) HandleWriteDuration(appId string, duration time.Duration) {
+	c.findOrMake(appId).HandleWriteDuration(duration)
+}
+
+func (c *memoryCollector) HandleSubscriptionFailure(appId string, reason string) {
+	c.findOrMake(appId).HandleSubscriptionFailure()
 }
 
-func (c *memoryCollector) HandleWebsocketMessage(appId string) {
-	c.findOrMake(appId).HandleNewWebsocketMessage()
+func (c *memoryCollector) HandleWebhookDelivery(appId string, result string) {
+	if result == statistics.WebhookDeliveryFailure {
+		c.findOrMake(appId).HandleWebhookFailure()
+	}
 }
 
 func (c *memoryCollector) HandleApiMessage(appId string) {
 	c.findOrMake(appId).HandleNewApiMessage()
 }
 
+func (c *memoryCollector) HandleChannelMessage(appId string, channel string, event string, bytes int, subscribers int) {
+	c.findOrMake(appId).HandleChannelMessage(channel, event, bytes, subscribers)
+}
+
+func (c *memoryCollector) HandleDroppedMessage(appId string) {
+	c.findOrMake(appId).HandleDroppedMessage()
+}
+
+func (c *memoryCollector) HandleSendQueueDepth(appId string, depth int) {
+	c.findOrMake(appId).HandleSendQueueDepth(depth)
+}
+
 func (c *memoryCollector) HandleConnection(appId string) {
-	c.findOrMake(appId).HandleNewConnection()
-	events.ConcurrentConnectionChanged(c.cm, c.GetAppStatistics(appId))
+	connections := c.findOrMake(appId).HandleNewConnection()
+	c.publishConcurrentConnections(appId, connections)
 }
 
 func (c *memoryCollector) HandleDisconnection(appId string) {
-	c.findOrMake(appId).HandleDisconnection()
-	events.ConcurrentConnectionChanged(c.cm, c.GetAppStatistics(appId))
+	connections := c.findOrMake(appId).HandleDisconnection()
+	c.publishConcurrentConnections(appId, connections)
+}
+
+func (c *memoryCollector) publishConcurrentConnections(appId string, connections int) {
+	c.bus.Publish(statistics.ConcurrentConnectionsChanged{
+		App:                   appId,
+		ConcurrentConnections: connections,
+		Time:                  time.Now(),
+	})
 }
 
 func (c *memoryCollector) Flush() {
-	c.stats = make(map[string]*statistics.Statistic, 0)
+	c.stats.Range(func(key, _ interface{}) bool {
+		c.stats.Delete(key)
+		return true
+	})
 }
 
 func (c *memoryCollector) GetAllStatistics() []statistics.Statistic {
 	stats := make([]statistics.Statistic, 0)
-	for _, stat := range c.stats {
-		stats = append(stats, *stat)
-	}
+	c.stats.Range(func(_, value interface{}) bool {
+		stats = append(stats, value.(*statistics.Counters).Current())
+		return true
+	})
 
 	return stats
 }
 
 func (c *memoryCollector) GetAppStatistics(appId string) statistics.Statistic {
-	stat := c.findOrMake(appId)
-	return *stat
+	return c.findOrMake(appId).Current()
+}
+
+func (c *memoryCollector) RegisterStatsListener(listener statistics.StatsCollectionListener, buffer int) {
+	c.bus.Subscribe(listener, buffer)
 }
 
-func (c *memoryCollector) RegisterStatsListener(listener statistics.StatsCollectionListener) {
-	c.listeners = append(c.listeners, listener)
+func (c *memoryCollector) Stop() {
+	c.stopOnce.Do(func() {
+		close(c.stopCh)
+		<-c.stoppedCh
+		c.bus.Close()
+	})
 }
 
 func (c *memoryCollector) periodicDumpToStorage() {
-	ticker := time.NewTicker(time.Second * 5)
+	ticker := time.NewTicker(c.dumpInterval)
+	defer close(c.stoppedCh)
+
 	for {
 		select {
 		case <-ticker.C:
 			c.DumpToStorage(c.store)
+		case <-c.stopCh:
+			ticker.Stop()
+			c.DumpToStorage(c.store)
+			return
 		}
 	}
 }
 
-func (c *memoryCollector) findOrMake(appId string) *statistics.Statistic {
-	if stat, ok := c.stats[appId]; ok {
-		return stat
+func (c *memoryCollector) findOrMake(appId string) *statistics.Counters {
+	if counters, ok := c.stats.Load(appId); ok {
+		return counters.(*statistics.Counters)
 	}
 
-	stat := statistics.NewStatistic(appId)
-	c.stats[appId] = stat
-
-	return stat
+	counters, _ := c.stats.LoadOrStore(appId, statistics.NewCounters(appId))
+	return counters.(*statistics.Counters)
 }
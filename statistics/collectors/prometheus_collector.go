@@ -0,0 +1,251 @@
+package collectors
+
+import (
+	"github.com/iamsayantan/larasockets"
+	"github.com/iamsayantan/larasockets/statistics"
+	"github.com/prometheus/client_golang/prometheus"
+	"sync"
+	"time"
+)
+
+const metricsNamespace = "larasockets"
+
+// prometheusCollector exports the collected statistics as prometheus metrics. It wraps another
+// collector, every hook updates the metrics right away and is then passed on to the wrapped
+// collector, so the metrics do not depend on the periodic snapshots of the statistics.
+type prometheusCollector struct {
+	statistics.StatsCollector
+
+	connections               *prometheus.GaugeVec
+	peakConnections           *prometheus.GaugeVec
+	websocketMessagesReceived *prometheus.CounterVec
+	websocketMessagesSent     *prometheus.CounterVec
+	websocketBytesReceived    *prometheus.CounterVec
+	websocketBytesSent        *prometheus.CounterVec
+	apiMessages               *prometheus.CounterVec
+	droppedMessages           *prometheus.CounterVec
+	subscriptionFailures      *prometheus.CounterVec
+	webhookDeliveries         *prometheus.CounterVec
+	deliveryLatency           *prometheus.HistogramVec
+	writeDuration             *prometheus.HistogramVec
+
+	// mu guards the connection counts used for the gauges, the peak is the highest number of
+	// concurrent connections since the server started.
+	mu      sync.Mutex
+	current map[string]int
+	peak    map[string]int
+}
+
+// NewPrometheusCollector returns a stats collector that records all the statistics as prometheus
+// metrics on the registerer before handing them to next.
+func NewPrometheusCollector(next statistics.StatsCollector, cm larasockets.ChannelManager, registerer prometheus.Registerer) (statistics.StatsCollector, error) {
+	collector := &prometheusCollector{
+		StatsCollector: next,
+		connections: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "connections",
+			Help:      "Number of websocket connections currently open.",
+		}, []string{"app_id"}),
+		peakConnections: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "peak_connections",
+			Help:      "Highest number of concurrent websocket connections since the server started.",
+		}, []string{"app_id"}),
+		websocketMessagesReceived: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "websocket_messages_received_total",
+			Help:      "Number of messages received from websocket connections.",
+		}, []string{"app_id"}),
+		websocketMessagesSent: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "websocket_messages_sent_total",
+			Help:      "Number of messages written to websocket connections.",
+		}, []string{"app_id"}),
+		websocketBytesReceived: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "websocket_bytes_received_total",
+			Help:      "Number of bytes received from websocket connections.",
+		}, []string{"app_id"}),
+		websocketBytesSent: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "websocket_bytes_sent_total",
+			Help:      "Number of bytes written to websocket connections.",
+		}, []string{"app_id"}),
+		apiMessages: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "api_messages_total",
+			Help:      "Number of events triggered through the http api.",
+		}, []string{"app_id"}),
+		droppedMessages: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "dropped_messages_total",
+			Help:      "Number of outgoing messages discarded because the send queue was full.",
+		}, []string{"app_id"}),
+		subscriptionFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "subscription_failures_total",
+			Help:      "Number of rejected channel subscriptions.",
+		}, []string{"app_id", "reason"}),
+		webhookDeliveries: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "webhook_deliveries_total",
+			Help:      "Number of webhooks sent, by result.",
+		}, []string{"app_id", "result"}),
+		deliveryLatency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "delivery_latency_seconds",
+			Help:      "Time from the api request that triggered a message to its write to a connection.",
+			Buckets:   prometheus.ExponentialBuckets(0.0001, 4, 10),
+		}, []string{"app_id"}),
+		writeDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "write_duration_seconds",
+			Help:      "Time writing a message to a websocket connection took.",
+			Buckets:   prometheus.ExponentialBuckets(0.00005, 4, 10),
+		}, []string{"app_id"}),
+		current: make(map[string]int),
+		peak:    make(map[string]int),
+	}
+
+	metrics := []prometheus.Collector{
+		collector.connections,
+		collector.peakConnections,
+		collector.websocketMessagesReceived,
+		collector.websocketMessagesSent,
+		collector.websocketBytesReceived,
+		collector.websocketBytesSent,
+		collector.apiMessages,
+		collector.droppedMessages,
+		collector.subscriptionFailures,
+		collector.webhookDeliveries,
+		collector.deliveryLatency,
+		collector.writeDuration,
+		newChannelMetrics(cm),
+	}
+
+	for _, metric := range metrics {
+		if err := registerer.Register(metric); err != nil {
+			return nil, err
+		}
+	}
+
+	return collector, nil
+}
+
+func (c *prometheusCollector) HandleWebsocketMessage(appId string, bytes int) {
+	c.websocketMessagesSent.WithLabelValues(appId).Inc()
+	c.websocketBytesSent.WithLabelValues(appId).Add(float64(bytes))
+	c.StatsCollector.HandleWebsocketMessage(appId, bytes)
+}
+
+func (c *prometheusCollector) HandleIncomingWebsocketMessage(appId string, bytes int) {
+	c.websocketMessagesReceived.WithLabelValues(appId).Inc()
+	c.websocketBytesReceived.WithLabelValues(appId).Add(float64(bytes))
+	c.StatsCollector.HandleIncomingWebsocketMessage(appId, bytes)
+}
+
+func (c *prometheusCollector) HandleDeliveryLatency(appId string, latency time.Duration) {
+	c.deliveryLatency.WithLabelValues(appId).Observe(latency.Seconds())
+	c.StatsCollector.HandleDeliveryLatency(appId, latency)
+}
+
+func (c *prometheusCollector) HandleWriteDuration(appId string, duration time.Duration) {
+	c.writeDuration.WithLabelValues(appId).Observe(duration.Seconds())
+	c.StatsCollector.HandleWriteDuration(appId, duration)
+}
+
+func (c *prometheusCollector) HandleApiMessage(appId string) {
+	c.apiMessages.WithLabelValues(appId).Inc()
+	c.StatsCollector.HandleApiMessage(appId)
+}
+
+func (c *prometheusCollector) HandleDroppedMessage(appId string) {
+	c.droppedMessages.WithLabelValues(appId).Inc()
+	c.StatsCollector.HandleDroppedMessage(appId)
+}
+
+func (c *prometheusCollector) HandleSubscriptionFailure(appId string, reason string) {
+	c.subscriptionFailures.WithLabelValues(appId, reason).Inc()
+	c.StatsCollector.HandleSubscriptionFailure(appId, reason)
+}
+
+func (c *prometheusCollector) HandleWebhookDelivery(appId string, result string) {
+	c.webhookDeliveries.WithLabelValues(appId, result).Inc()
+	c.StatsCollector.HandleWebhookDelivery(appId, result)
+}
+
+func (c *prometheusCollector) HandleConnection(appId string) {
+	c.mu.Lock()
+	c.current[appId]++
+	if c.current[appId] > c.peak[appId] {
+		c.peak[appId] = c.current[appId]
+	}
+
+	c.connections.WithLabelValues(appId).Set(float64(c.current[appId]))
+	c.peakConnections.WithLabelValues(appId).Set(float64(c.peak[appId]))
+	c.mu.Unlock()
+
+	c.StatsCollector.HandleConnection(appId)
+}
+
+func (c *prometheusCollector) HandleDisconnection(appId string) {
+	c.mu.Lock()
+	if c.current[appId] > 0 {
+		c.current[appId]--
+	}
+
+	c.connections.WithLabelValues(appId).Set(float64(c.current[appId]))
+	c.mu.Unlock()
+
+	c.StatsCollector.HandleDisconnection(appId)
+}
+
+// channelMetrics reports the number of channels and subscriptions of every app. They are read
+// from the channel manager when the metrics are scraped.
+type channelMetrics struct {
+	cm larasockets.ChannelManager
+
+	channels      *prometheus.Desc
+	subscriptions *prometheus.Desc
+}
+
+func newChannelMetrics(cm larasockets.ChannelManager) *channelMetrics {
+	return &channelMetrics{
+		cm: cm,
+		channels: prometheus.NewDesc(
+			prometheus.BuildFQName(metricsNamespace, "", "channels"),
+			"Number of occupied channels.",
+			[]string{"app_id"}, nil,
+		),
+		subscriptions: prometheus.NewDesc(
+			prometheus.BuildFQName(metricsNamespace, "", "subscriptions"),
+			"Number of channel subscriptions of all the connections.",
+			[]string{"app_id"}, nil,
+		),
+	}
+}
+
+func (m *channelMetrics) Describe(ch chan<- *prometheus.Desc) {
+	ch <- m.channels
+	ch <- m.subscriptions
+}
+
+func (m *channelMetrics) Collect(ch chan<- prometheus.Metric) {
+	for _, app := range m.cm.AppManager().All() {
+		// channels without connections, like the ones left behind by a failed subscription or
+		// created by a broadcast to the dashboard, are not occupied.
+		occupied, subscriptions := 0, 0
+		for _, channel := range m.cm.AllChannels(app.Id()) {
+			connections := len(channel.Connections())
+			if connections == 0 {
+				continue
+			}
+
+			occupied++
+			subscriptions += connections
+		}
+
+		ch <- prometheus.MustNewConstMetric(m.channels, prometheus.GaugeValue, float64(occupied), app.Id())
+		ch <- prometheus.MustNewConstMetric(m.subscriptions, prometheus.GaugeValue, float64(subscriptions), app.Id())
+	}
+}
@@ -0,0 +1,235 @@
+package statistics
+
+import (
+	"sync/atomic"
+	"time"
+	"unsafe"
+)
+
+// Counters collects the statistics of an app from many goroutines at once. The counters are
+// updated atomically, so recording a statistic never waits for a lock, the traffic per channel and
+// event is counted in a trafficTable. Snapshot swaps the counters with zero, nothing recorded
+// concurrently with a snapshot is lost, it ends up in the next one.
+type Counters struct {
+	// the int64 fields are accessed atomically and are kept first so they are aligned on 32 bit
+	// platforms.
+	concurrentConnections int64
+	peakConnections       int64
+	websocketMessages     int64
+	apiMessages           int64
+	droppedMessages       int64
+	peakSendQueueDepth    int64
+	bytesReceived         int64
+	bytesSent             int64
+	webhookFailures       int64
+	subscriptionFailures  int64
+
+	deliveryLatency *atomicHistogram
+	writeDuration   *atomicHistogram
+
+	// traffic points to the trafficTable of the current snapshot, it is accessed atomically.
+	traffic unsafe.Pointer
+
+	appId string
+	// lastSnapshot is the time of the last snapshot, or when the counters were created.
+	lastSnapshot time.Time
+}
+
+func NewCounters(appId string) *Counters {
+	return &Counters{
+		appId:           appId,
+		lastSnapshot:    time.Now(),
+		deliveryLatency: newAtomicHistogram(),
+		writeDuration:   newAtomicHistogram(),
+		traffic:         unsafe.Pointer(newTrafficTable()),
+	}
+}
+
+func (c *Counters) AppId() string {
+	return c.appId
+}
+
+// HandleNewConnection counts a new connection and returns the number of concurrent connections.
+func (c *Counters) HandleNewConnection() int {
+	connections := atomic.AddInt64(&c.concurrentConnections, 1)
+	storeMax(&c.peakConnections, connections)
+
+	return int(connections)
+}
+
+// HandleDisconnection counts a closed connection and returns the number of concurrent connections.
+func (c *Counters) HandleDisconnection() int {
+	return int(atomic.AddInt64(&c.concurrentConnections, -1))
+}
+
+func (c *Counters) HandleNewWebsocketMessage() {
+	atomic.AddInt64(&c.websocketMessages, 1)
+}
+
+func (c *Counters) HandleNewApiMessage() {
+	atomic.AddInt64(&c.apiMessages, 1)
+}
+
+func (c *Counters) HandleDroppedMessage() {
+	atomic.AddInt64(&c.droppedMessages, 1)
+}
+
+func (c *Counters) HandleSendQueueDepth(depth int) {
+	storeMax(&c.peakSendQueueDepth, int64(depth))
+}
+
+func (c *Counters) HandleBytesReceived(bytes int) {
+	atomic.AddInt64(&c.bytesReceived, int64(bytes))
+}
+
+func (c *Counters) HandleBytesSent(bytes int) {
+	atomic.AddInt64(&c.bytesSent, int64(bytes))
+}
+
+func (c *Counters) HandleWebhookFailure() {
+	atomic.AddInt64(&c.webhookFailures, 1)
+}
+
+func (c *Counters) HandleSubscriptionFailure() {
+	atomic.AddInt64(&c.subscriptionFailures, 1)
+}
+
+func (c *Counters) HandleDeliveryLatency(latency time.Duration) {
+	c.deliveryLatency.observe(latency)
+}
+
+func (c *Counters) HandleWriteDuration(duration time.Duration) {
+	c.writeDuration.observe(duration)
+}
+
+// HandleChannelMessage tracks a message of bytes sent to the subscribers of the channel.
+func (c *Counters) HandleChannelMessage(channel, event string, bytes, subscribers int) {
+	for {
+		table := (*trafficTable)(atomic.LoadPointer(&c.traffic))
+		atomic.AddInt64(&table.writers, 1)
+
+		// a snapshot replaced the table in the meantime and may already be reading it, the
+		// message is added to the new table instead.
+		if atomic.LoadPointer(&c.traffic) != unsafe.Pointer(table) {
+			atomic.AddInt64(&table.writers, -1)
+			continue
+		}
+
+		table.add(channel, event, bytes, subscribers)
+		atomic.AddInt64(&table.writers, -1)
+		return
+	}
+}
+
+// IsIdle reports whether the app had neither a connection, an api message nor a failed webhook
+// since the last snapshot.
+func (c *Counters) IsIdle() bool {
+	return atomic.LoadInt64(&c.peakConnections) == 0 && atomic.LoadInt64(&c.concurrentConnections) == 0 &&
+		atomic.LoadInt64(&c.apiMessages) == 0 && atomic.LoadInt64(&c.webhookFailures) == 0
+}
+
+// Current returns the statistics collected since the last snapshot without resetting them.
+func (c *Counters) Current() Statistic {
+	stat := c.load(false)
+	(*trafficTable)(atomic.LoadPointer(&c.traffic)).addTo(stat.channels, stat.events)
+
+	return stat
+}
+
+// Snapshot returns the statistics collected since the last snapshot and starts collecting anew,
+// the connections open now are the peak of the next snapshot. The connection seconds assume the
+// connections open now were open since the last snapshot. Snapshot must not be called
+// concurrently.
+func (c *Counters) Snapshot() Statistic {
+	stat := c.load(true)
+
+	now := time.Now()
+	stat.connectionSeconds = int(float64(stat.concurrentConnections) * now.Sub(c.lastSnapshot).Seconds())
+	c.lastSnapshot = now
+
+	table := (*trafficTable)(atomic.SwapPointer(&c.traffic, unsafe.Pointer(newTrafficTable())))
+	table.wait()
+	table.addTo(stat.channels, stat.events)
+
+	return stat
+}
+
+func (c *Counters) load(reset bool) Statistic {
+	read := atomic.LoadInt64
+	if reset {
+		read = func(addr *int64) int64 { return atomic.SwapInt64(addr, 0) }
+	}
+
+	stat := Statistic{
+		appId:                  c.appId,
+		concurrentConnections:  int(atomic.LoadInt64(&c.concurrentConnections)),
+		peakConnections:        int(read(&c.peakConnections)),
+		websocketMessagesCount: int(read(&c.websocketMessages)),
+		apiMessagesCount:       int(read(&c.apiMessages)),
+		droppedMessagesCount:   int(read(&c.droppedMessages)),
+		peakSendQueueDepth:     int(read(&c.peakSendQueueDepth)),
+		bytesReceived:          int(read(&c.bytesReceived)),
+		bytesSent:              int(read(&c.bytesSent)),
+		webhookFailures:        int(read(&c.webhookFailures)),
+		subscriptionFailures:   int(read(&c.subscriptionFailures)),
+		deliveryLatency:        c.deliveryLatency.load(reset),
+		writeDuration:          c.writeDuration.load(reset),
+		channels:               newHeavyHitters(MaxTrackedTraffic),
+		events:                 newHeavyHitters(MaxTrackedTraffic),
+	}
+
+	if reset {
+		storeMax(&c.peakConnections, atomic.LoadInt64(&c.concurrentConnections))
+	}
+
+	return stat
+}
+
+// storeMax stores value at addr if it is larger than the value already there.
+func storeMax(addr *int64, value int64) {
+	for {
+		current := atomic.LoadInt64(addr)
+		if value <= current || atomic.CompareAndSwapInt64(addr, current, value) {
+			return
+		}
+	}
+}
+
+// atomicHistogram is a latencyHistogram that can be observed concurrently.
+type atomicHistogram struct {
+	counts []int64
+	max    int64
+}
+
+func newAtomicHistogram() *atomicHistogram {
+	return &atomicHistogram{counts: make([]int64, len(latencyBounds)+1)}
+}
+
+func (h *atomicHistogram) observe(latency time.Duration) {
+	atomic.AddInt64(&h.counts[latencyBucket(latency)], 1)
+	storeMax(&h.max, int64(latency))
+}
+
+// load copies the histogram, with reset the counts are swapped with zero.
+func (h *atomicHistogram) load(reset bool) *latencyHistogram {
+	histogram := newLatencyHistogram()
+	for i := range h.counts {
+		var count int64
+		if reset {
+			count = atomic.SwapInt64(&h.counts[i], 0)
+		} else {
+			count = atomic.LoadInt64(&h.counts[i])
+		}
+
+		histogram.counts[i] = int(count)
+		histogram.total += int(count)
+	}
+
+	if reset {
+		histogram.max = time.Duration(atomic.SwapInt64(&h.max, 0))
+	} else {
+		histogram.max = time.Duration(atomic.LoadInt64(&h.max))
+	}
+
+	return histogram
+}
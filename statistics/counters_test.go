@@ -0,0 +1,134 @@
+package statistics
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// collectTraffic sums the messages of the traffic by name.
+func collectTraffic(totals map[string]int, traffic []TrafficStatistic) {
+	for _, t := range traffic {
+		totals[t.Name] += t.Messages
+	}
+}
+
+func TestCountersSnapshotWhileCounting(t *testing.T) {
+	counters := NewCounters("1")
+
+	const writers, messages = 8, 5000
+	channels := []string{"one", "two", "three"}
+
+	var wg sync.WaitGroup
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < messages; j++ {
+				counters.HandleNewWebsocketMessage()
+				counters.HandleBytesSent(10)
+				counters.HandleDeliveryLatency(time.Millisecond)
+				counters.HandleChannelMessage(channels[j%len(channels)], "event", 10, j%50)
+			}
+		}()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	websocketMessages, bytesSent, latencies := 0, 0, 0
+	channelMessages, eventMessages := make(map[string]int), make(map[string]int)
+	peakSubscribers := 0
+
+	collect := func(stat Statistic) {
+		websocketMessages += stat.WebsocketMessages()
+		bytesSent += stat.BytesSent()
+		latencies += stat.deliveryLatency.total
+		collectTraffic(channelMessages, stat.ChannelTraffic())
+		collectTraffic(eventMessages, stat.EventTraffic())
+
+		for _, traffic := range stat.ChannelTraffic() {
+			if traffic.PeakSubscribers > peakSubscribers {
+				peakSubscribers = traffic.PeakSubscribers
+			}
+		}
+	}
+
+	for counting := true; counting; {
+		select {
+		case <-done:
+			counting = false
+		default:
+		}
+
+		// Current reads the counters while they change, it must not take anything away.
+		counters.Current()
+		collect(counters.Snapshot())
+	}
+
+	collect(counters.Snapshot())
+
+	if total := writers * messages; websocketMessages != total || latencies != total {
+		t.Fatalf("expected %d websocket messages and latencies, got %d and %d", total, websocketMessages, latencies)
+	}
+
+	if bytesSent != writers*messages*10 {
+		t.Fatalf("expected %d bytes sent, got %d", writers*messages*10, bytesSent)
+	}
+
+	for i, channel := range channels {
+		expected := 0
+		for j := 0; j < messages; j++ {
+			if j%len(channels) == i {
+				expected += writers
+			}
+		}
+
+		if channelMessages[channel] != expected {
+			t.Fatalf("expected %d messages on channel %s, got %d", expected, channel, channelMessages[channel])
+		}
+	}
+
+	if eventMessages["event"] != writers*messages {
+		t.Fatalf("expected %d event messages, got %d", writers*messages, eventMessages["event"])
+	}
+
+	if peakSubscribers != 49 {
+		t.Fatalf("expected a peak of 49 subscribers, got %d", peakSubscribers)
+	}
+}
+
+func TestCountersTrafficKeepsTheBusiestChannels(t *testing.T) {
+	counters := NewCounters("1")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 2*MaxTrackedTraffic; j++ {
+				counters.HandleChannelMessage(fmt.Sprintf("quiet-%d-%d", i, j), "event", 1, 1)
+				counters.HandleChannelMessage("busy", "event", 1, 1)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	stat := counters.Snapshot()
+	traffic := stat.ChannelTraffic()
+	if len(traffic) != MaxTrackedTraffic {
+		t.Fatalf("expected the traffic of %d channels, got %d", MaxTrackedTraffic, len(traffic))
+	}
+
+	if traffic[0].Name != "busy" || traffic[0].Messages < 8*MaxTrackedTraffic {
+		t.Fatalf("expected the busy channel first with at least %d messages, got %+v", 8*MaxTrackedTraffic, traffic[0])
+	}
+
+	if next := counters.Snapshot(); len(next.ChannelTraffic()) != 0 {
+		t.Fatalf("expected no traffic after the snapshot, got %d channels", len(next.ChannelTraffic()))
+	}
+}
@@ -0,0 +1,107 @@
+package statistics
+
+import (
+	"sync"
+	"time"
+)
+
+// DefaultListenerBuffer is the number of events a listener can fall behind before events are
+// dropped for it.
+const DefaultListenerBuffer = 256
+
+// StatEvent is a change in the statistics of an app, published by a StatsCollector to all of its
+// listeners.
+type StatEvent interface {
+	AppId() string
+}
+
+// ConcurrentConnectionsChanged is published whenever a connection of the app is opened or closed.
+type ConcurrentConnectionsChanged struct {
+	App                   string
+	ConcurrentConnections int
+	Time                  time.Time
+}
+
+func (e ConcurrentConnectionsChanged) AppId() string {
+	return e.App
+}
+
+// StatisticsCollected is published every time the collected statistics of an app are dumped to
+// the storage, Statistic holds the statistics of the last dump interval.
+type StatisticsCollected struct {
+	Statistic Statistic
+	Time      time.Time
+}
+
+func (e StatisticsCollected) AppId() string {
+	return e.Statistic.AppId()
+}
+
+// EventBus delivers the published events to the listeners asynchronously. Every listener gets its
+// own buffer and goroutine, so publishing never waits for a listener. When the buffer of a slow
+// listener is full, the events for it are dropped until it catches up.
+type EventBus struct {
+	mu            sync.RWMutex
+	subscriptions []chan StatEvent
+	closed        bool
+	wg            sync.WaitGroup
+}
+
+func NewEventBus() *EventBus {
+	return &EventBus{subscriptions: make([]chan StatEvent, 0)}
+}
+
+// Subscribe registers the listener, up to buffer events are queued for it.
+func (b *EventBus) Subscribe(listener StatsCollectionListener, buffer int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return
+	}
+
+	events := make(chan StatEvent, buffer)
+	b.subscriptions = append(b.subscriptions, events)
+
+	b.wg.Add(1)
+	go func() {
+		defer b.wg.Done()
+		for event := range events {
+			listener.ListenStatChanged(event)
+		}
+	}()
+}
+
+// Publish queues the event for every listener without blocking.
+func (b *EventBus) Publish(event StatEvent) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	if b.closed {
+		return
+	}
+
+	for _, events := range b.subscriptions {
+		select {
+		case events <- event:
+		default:
+		}
+	}
+}
+
+// Close stops accepting events and waits for the listeners to handle the queued ones.
+func (b *EventBus) Close() {
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return
+	}
+
+	b.closed = true
+	for _, events := range b.subscriptions {
+		close(events)
+	}
+	b.mu.Unlock()
+
+	b.wg.Wait()
+}
@@ -0,0 +1,96 @@
+package statistics
+
+import "time"
+
+// latencyBounds are the upper bounds of the buckets of a latencyHistogram. They grow exponentially
+// from 50µs to about 52s, so the percentiles are accurate to a factor of two.
+var latencyBounds = func() []time.Duration {
+	bounds := make([]time.Duration, 0, 21)
+	for bound := 50 * time.Microsecond; len(bounds) < cap(bounds); bound *= 2 {
+		bounds = append(bounds, bound)
+	}
+
+	return bounds
+}()
+
+// LatencyPercentiles summarizes the latencies observed within a dump interval.
+type LatencyPercentiles struct {
+	P50 time.Duration
+	P95 time.Duration
+	P99 time.Duration
+}
+
+// Max returns the highest of every percentile of p and o. Percentiles can not be combined exactly,
+// so the worst one is used when statistics are merged.
+func (p LatencyPercentiles) Max(o LatencyPercentiles) LatencyPercentiles {
+	return LatencyPercentiles{P50: maxDuration(p.P50, o.P50), P95: maxDuration(p.P95, o.P95), P99: maxDuration(p.P99, o.P99)}
+}
+
+// latencyHistogram counts latencies in exponentially growing buckets, which keeps its size fixed
+// no matter how many latencies are observed.
+type latencyHistogram struct {
+	counts []int
+	total  int
+	max    time.Duration
+}
+
+func newLatencyHistogram() *latencyHistogram {
+	return &latencyHistogram{counts: make([]int, len(latencyBounds)+1)}
+}
+
+func (h *latencyHistogram) observe(latency time.Duration) {
+	h.counts[latencyBucket(latency)]++
+	h.total++
+	h.max = maxDuration(h.max, latency)
+}
+
+// percentile returns the upper bound of the bucket the percentile falls into, but never more
+// than the highest latency observed.
+func (h *latencyHistogram) percentile(p float64) time.Duration {
+	if h.total == 0 {
+		return 0
+	}
+
+	rank := int(p*float64(h.total) + 0.5)
+	if rank < 1 {
+		rank = 1
+	}
+
+	seen := 0
+	for i, count := range h.counts {
+		seen += count
+		if seen < rank {
+			continue
+		}
+
+		if i == len(latencyBounds) || latencyBounds[i] > h.max {
+			return h.max
+		}
+
+		return latencyBounds[i]
+	}
+
+	return h.max
+}
+
+// latencyBucket returns the index of the bucket latency is counted in.
+func latencyBucket(latency time.Duration) int {
+	i := 0
+	for i < len(latencyBounds) && latency > latencyBounds[i] {
+		i++
+	}
+
+	return i
+}
+
+func (h *latencyHistogram) percentiles() LatencyPercentiles {
+	return LatencyPercentiles{P50: h.percentile(0.50), P95: h.percentile(0.95), P99: h.percentile(0.99)}
+}
+
+func maxDuration(a, b time.Duration) time.Duration {
+	if a > b {
+		return a
+	}
+
+	return b
+}
@@ -1,6 +1,7 @@
 package listeners
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"github.com/iamsayantan/larasockets"
@@ -8,6 +9,8 @@ import (
 	"github.com/iamsayantan/larasockets/statistics"
 )
 
+// NewConcurrentConnectionListener returns a listener that broadcasts the concurrent connections of
+// an app to its dashboard whenever they change.
 func NewConcurrentConnectionListener(cm larasockets.ChannelManager) statistics.StatsCollectionListener {
 	return &concurrentConnectionListener{channelManager: cm}
 }
@@ -16,12 +19,20 @@ type concurrentConnectionListener struct {
 	channelManager larasockets.ChannelManager
 }
 
-func (c *concurrentConnectionListener) ListenStatChanged(stat statistics.Statistic) {
-	channelName := fmt.Sprintf("private-app-%s-stats-concurrent-connections", stat.AppId())
-	channel := c.channelManager.FindOrCreateChannel(stat.AppId(), channelName)
+func (c *concurrentConnectionListener) ListenStatChanged(event statistics.StatEvent) {
+	changed, ok := event.(statistics.ConcurrentConnectionsChanged)
+	if !ok {
+		return
+	}
+
+	channelName := fmt.Sprintf("private-app-%s-stats-concurrent-connections", changed.AppId())
+	channel := c.channelManager.FindChannel(changed.AppId(), channelName)
+	if channel == nil {
+		return
+	}
 
 	data := make(map[string]int, 0)
-	data["concurrent_connections"] = stat.ConcurrentConnections()
+	data["concurrent_connections"] = changed.ConcurrentConnections
 
 	payloadData, err := json.Marshal(data)
 	if err != nil {
@@ -34,5 +45,5 @@ func (c *concurrentConnectionListener) ListenStatChanged(stat statistics.Statist
 		Data:    string(payloadData),
 	}
 
-	channel.Broadcast(msg)
+	channel.Broadcast(context.Background(), msg)
 }
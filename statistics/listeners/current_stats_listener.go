@@ -0,0 +1,50 @@
+package listeners
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/iamsayantan/larasockets"
+	"github.com/iamsayantan/larasockets/messages"
+	"github.com/iamsayantan/larasockets/statistics"
+)
+
+// NewCurrentStatsListener returns a listener that broadcasts the statistics of every dump interval
+// to the dashboard of the app.
+func NewCurrentStatsListener(cm larasockets.ChannelManager) statistics.StatsCollectionListener {
+	return &currentStatsListener{channelManager: cm}
+}
+
+type currentStatsListener struct {
+	channelManager larasockets.ChannelManager
+}
+
+func (c *currentStatsListener) ListenStatChanged(event statistics.StatEvent) {
+	collected, ok := event.(statistics.StatisticsCollected)
+	if !ok {
+		return
+	}
+
+	channelName := fmt.Sprintf("private-app-%s-current-stats", collected.AppId())
+	// the statistics are only broadcast while a dashboard is subscribed to them.
+	channel := c.channelManager.FindChannel(collected.AppId(), channelName)
+	if channel == nil {
+		return
+	}
+
+	data := collected.Statistic.GetCurrentSnapshot()
+	data["timestamp"] = collected.Time.Unix()
+
+	payloadData, err := json.Marshal(data)
+	if err != nil {
+		return
+	}
+
+	msg := messages.PusherEventPayload{
+		Event:   "update",
+		Channel: channelName,
+		Data:    string(payloadData),
+	}
+
+	channel.Broadcast(context.Background(), msg)
+}
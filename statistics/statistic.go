@@ -2,12 +2,36 @@ package statistics
 
 import "time"
 
+// Statistic holds the statistics of an app for a period of time. The statistics are collected by
+// Counters, a Statistic is either a snapshot of them or read from a storage.
 type Statistic struct {
 	appId                  string
 	concurrentConnections  int
 	peakConnections        int
 	websocketMessagesCount int
 	apiMessagesCount       int
+	droppedMessagesCount   int
+	peakSendQueueDepth     int
+	bytesReceived          int
+	bytesSent              int
+	// connectionSeconds is the time all the connections of the app were open together.
+	connectionSeconds int
+	// webhookFailures is only collected for alerting, it is not kept by the storages.
+	webhookFailures int
+	// subscriptionFailures is the number of channel subscriptions of the app that were rejected.
+	subscriptionFailures int
+
+	// deliveryLatency is the time from an api request to the write of its messages, writeDuration
+	// the time writing a message to a connection took. Statistics read from a storage only have
+	// the percentiles, which are kept in storedDeliveryLatency and storedWriteDuration.
+	deliveryLatency       *latencyHistogram
+	writeDuration         *latencyHistogram
+	storedDeliveryLatency LatencyPercentiles
+	storedWriteDuration   LatencyPercentiles
+
+	// channels and events are the traffic per channel and per event name.
+	channels *heavyHitters
+	events   *heavyHitters
 }
 
 type StatisticByTime struct {
@@ -16,16 +40,28 @@ type StatisticByTime struct {
 }
 
 func NewStatistic(appId string) *Statistic {
-	return &Statistic{appId: appId}
+	return &Statistic{
+		appId:           appId,
+		deliveryLatency: newLatencyHistogram(),
+		writeDuration:   newLatencyHistogram(),
+		channels:        newHeavyHitters(MaxTrackedTraffic),
+		events:          newHeavyHitters(MaxTrackedTraffic),
+	}
 }
 
-func NewStatisticWithData(appId string, concurrentConnections, peakConnections, websocketMessages, apiMessages int) *Statistic {
+func NewStatisticWithData(appId string, concurrentConnections, peakConnections, websocketMessages, apiMessages, droppedMessages, peakSendQueueDepth int) *Statistic {
 	return &Statistic{
 		appId:                  appId,
 		concurrentConnections:  concurrentConnections,
 		peakConnections:        peakConnections,
 		websocketMessagesCount: websocketMessages,
 		apiMessagesCount:       apiMessages,
+		droppedMessagesCount:   droppedMessages,
+		peakSendQueueDepth:     peakSendQueueDepth,
+		deliveryLatency:        newLatencyHistogram(),
+		writeDuration:          newLatencyHistogram(),
+		channels:               newHeavyHitters(MaxTrackedTraffic),
+		events:                 newHeavyHitters(MaxTrackedTraffic),
 	}
 }
 
@@ -56,42 +92,88 @@ func (s *Statistic) ApiMessages() int {
 	return s.apiMessagesCount
 }
 
-func (s *Statistic) HandleNewConnection() {
-	s.concurrentConnections++
+func (s *Statistic) DroppedMessages() int {
+	return s.droppedMessagesCount
+}
+
+// PeakSendQueueDepth is the deepest send queue seen on any connection of the app.
+func (s *Statistic) PeakSendQueueDepth() int {
+	return s.peakSendQueueDepth
+}
+
+func (s *Statistic) BytesReceived() int {
+	return s.bytesReceived
+}
+
+func (s *Statistic) BytesSent() int {
+	return s.bytesSent
+}
 
-	if s.concurrentConnections > s.peakConnections {
-		s.peakConnections = s.concurrentConnections
+// DeliveryLatency returns the percentiles of the time from an api request to the write of its
+// messages to the connections.
+func (s *Statistic) DeliveryLatency() LatencyPercentiles {
+	if s.deliveryLatency.total == 0 {
+		return s.storedDeliveryLatency
 	}
+
+	return s.deliveryLatency.percentiles()
 }
 
-func (s *Statistic) HandleDisconnection() {
-	s.concurrentConnections--
+// WriteDuration returns the percentiles of the time writing a message to a connection took.
+func (s *Statistic) WriteDuration() LatencyPercentiles {
+	if s.writeDuration.total == 0 {
+		return s.storedWriteDuration
+	}
+
+	return s.writeDuration.percentiles()
 }
 
-func (s *Statistic) HandleNewWebsocketMessage() {
-	s.websocketMessagesCount++
+// WebhookFailures is the number of webhooks of the app that could not be delivered.
+func (s *Statistic) WebhookFailures() int {
+	return s.webhookFailures
 }
 
-func (s *Statistic) HandleNewApiMessage() {
-	s.apiMessagesCount++
+// SubscriptionFailures is the number of channel subscriptions of the app that were rejected.
+func (s *Statistic) SubscriptionFailures() int {
+	return s.subscriptionFailures
 }
 
-func (s *Statistic) Reset(concurrentConnections int) {
-	s.concurrentConnections = concurrentConnections
-	s.apiMessagesCount = 0
-	s.websocketMessagesCount = 0
+// SetSubscriptionFailures sets the rejected subscriptions of a statistic read from a storage.
+func (s *Statistic) SetSubscriptionFailures(failures int) {
+	s.subscriptionFailures = failures
+}
 
-	if concurrentConnections > 0 {
-		s.peakConnections = concurrentConnections
-	} else {
-		s.peakConnections = 0
-	}
+// ConnectionSeconds is the sum of the time every connection of the app was open, sampled at the
+// end of every dump interval.
+func (s *Statistic) ConnectionSeconds() int {
+	return s.connectionSeconds
 }
 
-// CanRemoveStatistics checks if there is any current activity in for the app,
-// if there is no current activity then, this statistics can be safely removed.
-func (s *Statistic) CanRemoveStatistics() bool {
-	return s.peakConnections == 0 && s.concurrentConnections == 0
+// SetConnectionSeconds sets the connection seconds of a statistic read from a storage.
+func (s *Statistic) SetConnectionSeconds(seconds int) {
+	s.connectionSeconds = seconds
+}
+
+// SetBandwidth sets the bytes of a statistic read from a storage.
+func (s *Statistic) SetBandwidth(bytesReceived, bytesSent int) {
+	s.bytesReceived = bytesReceived
+	s.bytesSent = bytesSent
+}
+
+// SetLatencies sets the latency percentiles of a statistic read from a storage.
+func (s *Statistic) SetLatencies(deliveryLatency, writeDuration LatencyPercentiles) {
+	s.storedDeliveryLatency = deliveryLatency
+	s.storedWriteDuration = writeDuration
+}
+
+// ChannelTraffic returns the traffic of the busiest channels, the busiest first.
+func (s *Statistic) ChannelTraffic() []TrafficStatistic {
+	return s.channels.top()
+}
+
+// EventTraffic returns the traffic of the busiest event names, the busiest first.
+func (s *Statistic) EventTraffic() []TrafficStatistic {
+	return s.events.top()
 }
 
 func (s *Statistic) GetCurrentSnapshot() map[string]interface{} {
@@ -101,12 +183,43 @@ func (s *Statistic) GetCurrentSnapshot() map[string]interface{} {
 	stats["peak_connections"] = s.peakConnections
 	stats["websocket_messages"] = s.websocketMessagesCount
 	stats["api_messages"] = s.apiMessagesCount
+	stats["dropped_messages"] = s.droppedMessagesCount
+	stats["peak_send_queue_depth"] = s.peakSendQueueDepth
+	stats["bytes_received"] = s.bytesReceived
+	stats["bytes_sent"] = s.bytesSent
+	stats["connection_seconds"] = s.connectionSeconds
+	stats["webhook_failures"] = s.webhookFailures
+	stats["subscription_failures"] = s.subscriptionFailures
+	stats["delivery_latency_p99_ms"] = s.DeliveryLatency().P99.Seconds() * 1000
+	stats["write_duration_p99_ms"] = s.WriteDuration().P99.Seconds() * 1000
 
 	return stats
 }
 
+// Merge adds the statistic o to s. The messages, the bytes, the connection seconds and the
+// failures are summed, the peaks and the latency percentiles are the highest of both. The
+// concurrent connections of s are kept.
+func (s *Statistic) Merge(o *Statistic) {
+	s.peakConnections = maxInt(s.peakConnections, o.peakConnections)
+	s.websocketMessagesCount += o.websocketMessagesCount
+	s.apiMessagesCount += o.apiMessagesCount
+	s.droppedMessagesCount += o.droppedMessagesCount
+	s.peakSendQueueDepth = maxInt(s.peakSendQueueDepth, o.peakSendQueueDepth)
+	s.bytesReceived += o.bytesReceived
+	s.bytesSent += o.bytesSent
+	s.connectionSeconds += o.connectionSeconds
+	s.webhookFailures += o.webhookFailures
+	s.subscriptionFailures += o.subscriptionFailures
+	s.storedDeliveryLatency = s.DeliveryLatency().Max(o.DeliveryLatency())
+	s.storedWriteDuration = s.WriteDuration().Max(o.WriteDuration())
+}
+
+// Set sets the statistic of the second of t, a statistic already set for it is replaced.
 func (st *StatisticByTime) Set(t time.Time, statistic *Statistic) {
-	st.timestamps = append(st.timestamps, t.Unix())
+	if _, ok := st.statistics[t.Unix()]; !ok {
+		st.timestamps = append(st.timestamps, t.Unix())
+	}
+
 	st.statistics[t.Unix()] = statistic
 }
 
@@ -121,3 +234,46 @@ func (st *StatisticByTime) Get(timestamp int64) *Statistic {
 
 	return nil
 }
+
+// Buckets groups the statistics into buckets of interval between start and end. Within a bucket
+// the messages, the bytes and the connection seconds are summed, the peaks are the highest value
+// and the concurrent connections are the latest value. The latency percentiles are the highest
+// within the bucket, an upper bound of the real percentiles. Buckets without any statistic are
+// filled with zeros. The buckets are aligned to the interval and returned oldest first. The
+// interval must be a whole number of seconds.
+func (st *StatisticByTime) Buckets(appId string, start, end time.Time, interval time.Duration) *StatisticByTime {
+	buckets := NewStatisticByTime()
+	latest := make(map[int64]int64)
+
+	bucketStart := start.Truncate(interval)
+	for t := bucketStart; !t.After(end); t = t.Add(interval) {
+		buckets.Set(t, NewStatistic(appId))
+	}
+
+	seconds := int64(interval / time.Second)
+	for _, timestamp := range st.timestamps {
+		bucket := bucketStart.Unix() + (timestamp-bucketStart.Unix())/seconds*seconds
+		stat, ok := buckets.statistics[bucket]
+		if !ok || timestamp < bucketStart.Unix() {
+			continue
+		}
+
+		s := st.statistics[timestamp]
+		if last, ok := latest[bucket]; !ok || timestamp >= last {
+			latest[bucket] = timestamp
+			stat.concurrentConnections = s.concurrentConnections
+		}
+
+		stat.Merge(s)
+	}
+
+	return buckets
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+
+	return b
+}
@@ -0,0 +1,27 @@
+package statistics
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStatisticByTimeSetReplacesTheSameSecond(t *testing.T) {
+	start := time.Unix(1600000000, 0)
+	stats := NewStatisticByTime()
+
+	first, second := NewStatistic("1"), NewStatistic("1")
+	first.websocketMessagesCount = 5
+	second.websocketMessagesCount = 7
+
+	stats.Set(start, first)
+	stats.Set(start.Add(500*time.Millisecond), second)
+
+	if len(stats.Timestamps()) != 1 {
+		t.Fatalf("expected 1 timestamp, got %d", len(stats.Timestamps()))
+	}
+
+	buckets := stats.Buckets("1", start, start, time.Minute)
+	if got := buckets.Get(start.Truncate(time.Minute).Unix()).WebsocketMessages(); got != 7 {
+		t.Fatalf("expected the bucket to have 7 websocket messages, got %d", got)
+	}
+}
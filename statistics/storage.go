@@ -7,5 +7,30 @@ import "time"
 type StatsStorage interface {
 	Store(statistic Statistic)
 	DailyStatForApp(appId string) *Statistic
+	// UsageForApp returns the totals of the statistics within the range, see Statistic.Merge.
+	UsageForApp(appId string, startTime time.Time, endTime time.Time) *Statistic
 	StatsByTimeRange(appId string, startTime time.Time, endTime time.Time) *StatisticByTime
+	// StatsForInterval returns the statistics of the range grouped into buckets of interval, see
+	// StatisticByTime.Buckets.
+	StatsForInterval(appId string, startTime time.Time, endTime time.Time, interval time.Duration) *StatisticByTime
+	// TopTraffic returns the limit channels or event names, depending on kind, with the most
+	// messages within the range.
+	TopTraffic(appId string, kind string, startTime time.Time, endTime time.Time, limit int) []TrafficStatistic
+	// Health reports whether the statistics are being stored.
+	Health() StoreHealth
+	// Close stores the statistics that are still buffered.
+	Close()
+}
+
+// StoreHealth describes the state of the writes of a StatsStorage. A storage that buffers its
+// writes keeps the statistics it could not store yet, Pending in memory and Spilled on disk.
+type StoreHealth struct {
+	Healthy bool
+	Pending int
+	Spilled int
+	// Dropped is the number of statistics discarded because the buffers were full.
+	Dropped     int
+	LastError   string
+	LastErrorAt time.Time
+	LastWriteAt time.Time
 }
@@ -1,64 +1,349 @@
 package stores
 
 import (
+	"github.com/iamsayantan/larasockets/config"
 	"github.com/iamsayantan/larasockets/statistics"
 	"gorm.io/gorm"
 	"time"
 )
 
 type LarasocketsStatistic struct {
-	ID                uint   `json:"id" gorm:"primarykey"`
-	AppId             string `gorm:"index"`
-	PeakConnections   int
-	WebsocketMessages int
-	ApiMessages       int
-	CreatedAt         time.Time `json:"-"`
-	UpdatedAt         time.Time `json:"-"`
+	ID    uint   `json:"id" gorm:"primarykey"`
+	AppId string `gorm:"index:idx_larasockets_statistics_app_id_created_at,priority:1"`
+	// RecordKey identifies the statistic when it is written more than once, it is unique across
+	// the nodes storing statistics of the same app. Rows stored before it existed have none.
+	RecordKey             *string `gorm:"size:32;uniqueIndex"`
+	ConcurrentConnections int
+	PeakConnections       int
+	WebsocketMessages     int
+	ApiMessages           int
+	DroppedMessages       int
+	PeakSendQueueDepth    int
+	BytesReceived         int
+	BytesSent             int
+	ConnectionSeconds     int
+	SubscriptionFailures  int
+	// the latency percentiles are in microseconds.
+	DeliveryLatencyP50 int
+	DeliveryLatencyP95 int
+	DeliveryLatencyP99 int
+	WriteDurationP50   int
+	WriteDurationP95   int
+	WriteDurationP99   int
+	CreatedAt          time.Time `json:"-" gorm:"index:idx_larasockets_statistics_app_id_created_at,priority:2"`
+	UpdatedAt          time.Time `json:"-"`
 }
 
-func NewDatabaseStorage(db *gorm.DB) statistics.StatsStorage {
-	return &dbStore{db: db}
+// minGraphPoints is the number of points a time range needs at least in a resolution for the
+// resolution to be used for it.
+const minGraphPoints = 60
+
+// LarasocketsTrafficStatistic is the traffic of a channel or an event name of an app between two
+// dumps of the statistics. Kind is one of statistics.TrafficChannel and statistics.TrafficEvent.
+type LarasocketsTrafficStatistic struct {
+	ID uint `json:"id" gorm:"primarykey"`
+	// RecordKey is the key of the statistic the traffic was collected with.
+	RecordKey       *string `gorm:"size:32;uniqueIndex:idx_larasockets_traffic_statistics_record,priority:1"`
+	AppId           string  `gorm:"size:191;index:idx_larasockets_traffic_statistics_app_id_kind_created_at,priority:1"`
+	Kind            string  `gorm:"size:16;index:idx_larasockets_traffic_statistics_app_id_kind_created_at,priority:2;uniqueIndex:idx_larasockets_traffic_statistics_record,priority:2"`
+	Name            string  `gorm:"size:191;uniqueIndex:idx_larasockets_traffic_statistics_record,priority:3"`
+	Messages        int
+	Bytes           int
+	PeakSubscribers int
+	CreatedAt       time.Time `json:"-" gorm:"index:idx_larasockets_traffic_statistics_app_id_kind_created_at,priority:3"`
+}
+
+// NewDatabaseStorage returns a StatsStorage that stores the statistics in an sql database. The
+// statistics are read from the rollups that are kept by Rollups, the retention must be the same.
+// The writes are buffered, see writeBuffer.
+func NewDatabaseStorage(db *gorm.DB, retention config.RetentionConfig, writes config.WriteBufferConfig) statistics.StatsStorage {
+	resolutions := newResolutions(retention)
+	return &dbStore{db: db, resolutions: resolutions, writes: newWriteBuffer(db, resolutions, writes)}
 }
 
 type dbStore struct {
-	db *gorm.DB
+	db          *gorm.DB
+	resolutions []resolution
+	writes      *writeBuffer
 }
 
+// Store queues the statistic to be written by the write buffer.
 func (m *dbStore) Store(statistic statistics.Statistic) {
+	// a statistic without a key could be stored twice when it is written again, it is not
+	// stored at all instead.
+	recordKey, err := newRecordKey()
+	if err != nil {
+		m.writes.reject(err)
+		return
+	}
+
+	createdAt := time.Now()
 	statToStore := LarasocketsStatistic{
-		AppId:             statistic.AppId(),
-		PeakConnections:   statistic.PeakConnections(),
-		WebsocketMessages: statistic.WebsocketMessages(),
-		ApiMessages:       statistic.ApiMessages(),
+		AppId:                 statistic.AppId(),
+		RecordKey:             &recordKey,
+		ConcurrentConnections: statistic.ConcurrentConnections(),
+		PeakConnections:       statistic.PeakConnections(),
+		WebsocketMessages:     statistic.WebsocketMessages(),
+		ApiMessages:           statistic.ApiMessages(),
+		DroppedMessages:       statistic.DroppedMessages(),
+		PeakSendQueueDepth:    statistic.PeakSendQueueDepth(),
+		BytesReceived:         statistic.BytesReceived(),
+		BytesSent:             statistic.BytesSent(),
+		ConnectionSeconds:     statistic.ConnectionSeconds(),
+		SubscriptionFailures:  statistic.SubscriptionFailures(),
+		CreatedAt:             createdAt,
+		UpdatedAt:             createdAt,
+	}
+
+	statToStore.DeliveryLatencyP50, statToStore.DeliveryLatencyP95, statToStore.DeliveryLatencyP99 = percentileMicros(statistic.DeliveryLatency())
+	statToStore.WriteDurationP50, statToStore.WriteDurationP95, statToStore.WriteDurationP99 = percentileMicros(statistic.WriteDuration())
+
+	traffic := make([]LarasocketsTrafficStatistic, 0)
+	for _, channel := range statistic.ChannelTraffic() {
+		traffic = append(traffic, newTrafficRow(statistic.AppId(), recordKey, statistics.TrafficChannel, channel, createdAt))
+	}
+
+	for _, event := range statistic.EventTraffic() {
+		traffic = append(traffic, newTrafficRow(statistic.AppId(), recordKey, statistics.TrafficEvent, event, createdAt))
 	}
 
-	m.db.Create(&statToStore)
+	m.writes.add(statisticRecord{Statistic: statToStore, Traffic: traffic})
+}
+
+func (m *dbStore) Health() statistics.StoreHealth {
+	return m.writes.healthStatus()
+}
+
+func (m *dbStore) Close() {
+	m.writes.close()
+}
+
+func newTrafficRow(appId, recordKey, kind string, traffic statistics.TrafficStatistic, createdAt time.Time) LarasocketsTrafficStatistic {
+	return LarasocketsTrafficStatistic{
+		AppId:           appId,
+		RecordKey:       &recordKey,
+		Kind:            kind,
+		Name:            traffic.Name,
+		Messages:        traffic.Messages,
+		Bytes:           traffic.Bytes,
+		PeakSubscribers: traffic.PeakSubscribers,
+		CreatedAt:       createdAt,
+	}
 }
 
 func (m *dbStore) DailyStatForApp(appId string) *statistics.Statistic {
-	var stats LarasocketsStatistic
-	err := m.db.Select("MAX(peak_connections) AS peak_connections, SUM(websocket_messages) AS websocket_messages, SUM(api_messages) AS api_messages").
-		Where("app_id = ?", appId).
-		Where("created_at >= CURDATE()").
-		Where("created_at < CURDATE() + INTERVAL 1 DAY").
-		First(&stats).
-		Error
+	// the day boundaries are computed here instead of in sql, so the query runs on every database.
+	startOfDay := startOfDay(time.Now())
+	return m.UsageForApp(appId, startOfDay, startOfDay.AddDate(0, 0, 1))
+}
 
+// UsageForApp sums the statistics of the range, starting with the coarsest resolution both ends
+// of the range are aligned to. The part of the range that was not rolled up into it yet is read
+// from the finer resolutions, as the raw statistics might have been pruned already.
+func (m *dbStore) UsageForApp(appId string, startTime time.Time, endTime time.Time) *statistics.Statistic {
+	now := time.Now()
+	chosen := len(m.resolutions) - 1
+	for chosen > 0 {
+		res := m.resolutions[chosen]
+		aligned := startTime.Truncate(res.step).Equal(startTime) && endTime.Truncate(res.step).Equal(endTime)
+		if aligned && (res.retention == 0 || !startTime.Before(now.Add(-res.retention))) {
+			break
+		}
+
+		chosen--
+	}
+
+	usage, err := m.totals(chosen, appId, startTime, endTime)
 	if err != nil {
 		return nil
 	}
 
-	return statistics.NewStatisticWithData(appId, 0, stats.PeakConnections, stats.WebsocketMessages, stats.ApiMessages)
+	return usage.statistic(appId)
+}
+
+// totals aggregates the range from the resolution as far as it is rolled up and the rest from
+// the finer resolutions.
+func (m *dbStore) totals(resolutionIndex int, appId string, from, to time.Time) (rollupRow, error) {
+	res := m.resolutions[resolutionIndex]
+	if resolutionIndex == 0 {
+		return m.aggregate(res, appId, from, to)
+	}
+
+	until, err := rolledUntil(m.db, res)
+	if err != nil {
+		return rollupRow{}, err
+	}
+
+	var total rollupRow
+	if until.After(from) {
+		end := to
+		if until.Before(end) {
+			end = until
+		}
+
+		if total, err = m.aggregate(res, appId, from, end); err != nil {
+			return rollupRow{}, err
+		}
+
+		from = end
+	}
+
+	if !from.Before(to) {
+		return total, nil
+	}
+
+	rest, err := m.totals(resolutionIndex-1, appId, from, to)
+	if err != nil {
+		return rollupRow{}, err
+	}
+
+	return total.merge(rest), nil
 }
 
+// StatsByTimeRange returns the statistics of the range from the coarsest resolution that still
+// has enough points within the range. The part of the range that was not rolled up into the
+// resolution yet is filled from the finer resolutions.
 func (m *dbStore) StatsByTimeRange(appId string, startTime time.Time, endTime time.Time) *statistics.StatisticByTime {
-	var stats []LarasocketsStatistic
 	statsResponse := statistics.NewStatisticByTime()
+	m.collectStats(statsResponse, appId, m.resolutionFor(startTime, endTime.Sub(startTime)/minGraphPoints, false), startTime, endTime)
+
+	return statsResponse
+}
+
+// StatsForInterval reads the statistics from the coarsest resolution whose buckets fit into the
+// buckets of interval.
+func (m *dbStore) StatsForInterval(appId string, startTime time.Time, endTime time.Time, interval time.Duration) *statistics.StatisticByTime {
+	statsResponse := statistics.NewStatisticByTime()
+	m.collectStats(statsResponse, appId, m.resolutionFor(startTime, interval, true), startTime, endTime)
+
+	return statsResponse.Buckets(appId, startTime, endTime, interval)
+}
+
+// resolutionFor returns the index of the coarsest resolution whose step is at most maxStep, unless
+// it is not kept long enough for startTime. With aligned the step must evenly divide maxStep.
+func (m *dbStore) resolutionFor(startTime time.Time, maxStep time.Duration, aligned bool) int {
+	chosen := 0
+	for i := 1; i < len(m.resolutions); i++ {
+		step := m.resolutions[i].step
+		if step > maxStep {
+			break
+		}
+
+		if !aligned || maxStep%step == 0 {
+			chosen = i
+		}
+	}
+
+	// the finer resolutions might not be kept long enough for the start of the range.
+	now := time.Now()
+	for chosen < len(m.resolutions)-1 {
+		retention := m.resolutions[chosen].retention
+		if retention == 0 || !startTime.Before(now.Add(-retention)) {
+			break
+		}
+
+		chosen++
+	}
+
+	return chosen
+}
+
+// collectStats adds the statistics of the range to statsResponse, newest first.
+func (m *dbStore) collectStats(statsResponse *statistics.StatisticByTime, appId string, resolutionIndex int, startTime, endTime time.Time) {
+	res := m.resolutions[resolutionIndex]
+	until, err := rolledUntil(m.db, res)
+	if err != nil {
+		return
+	}
+
+	// the raw statistics are always complete, the rollups only until they were last rolled up.
+	if resolutionIndex > 0 && !until.After(endTime) {
+		from := startTime
+		if until.After(from) {
+			from = until
+		}
+
+		m.collectStats(statsResponse, appId, resolutionIndex-1, from, endTime)
+		endTime = until
+	}
+
+	if resolutionIndex > 0 && !until.After(startTime) {
+		return
+	}
+
+	var stats []rollupRow
+	m.db.Table(res.table).
+		Select("app_id, "+res.timeColumn+" AS observed_at, "+statisticColumns).
+		Where("app_id = ?", appId).
+		Where(res.timeColumn+" >= ?", startTime).
+		Where(res.timeColumn+" <= ?", endTime).
+		Order(res.timeColumn + " DESC").
+		Find(&stats)
 
-	m.db.Where("app_id = ?", appId).Where("created_at >= ?", startTime).Where("created_at <= ?", endTime).Order("created_at DESC").Find(&stats)
 	for _, stat := range stats {
-		statsResponse.Set(stat.CreatedAt, statistics.NewStatisticWithData(appId, 0, stat.PeakConnections, stat.WebsocketMessages, stat.ApiMessages))
+		if resolutionIndex > 0 && stat.ObservedAt.Equal(until) {
+			// the bucket starting at until belongs to the finer resolutions.
+			continue
+		}
+
+		statsResponse.Set(stat.ObservedAt, stat.statistic(appId))
 	}
+}
 
-	return statsResponse
+// TopTraffic sums up the traffic that was stored within the range. The traffic is not rolled up,
+// so only the range within the retention of the raw statistics is available.
+func (m *dbStore) TopTraffic(appId string, kind string, startTime time.Time, endTime time.Time, limit int) []statistics.TrafficStatistic {
+	traffic := make([]statistics.TrafficStatistic, 0)
+	m.db.Model(&LarasocketsTrafficStatistic{}).
+		Select("name, SUM(messages) AS messages, SUM(bytes) AS bytes, MAX(peak_subscribers) AS peak_subscribers").
+		Where("app_id = ?", appId).
+		Where("kind = ?", kind).
+		Where("created_at >= ?", startTime).
+		Where("created_at <= ?", endTime).
+		Group("name").
+		Order("messages DESC, name").
+		Limit(limit).
+		Scan(&traffic)
+
+	return traffic
+}
+
+// aggregate returns the peaks and the sums of the statistics of the resolution between from and to.
+func (m *dbStore) aggregate(res resolution, appId string, from, to time.Time) (rollupRow, error) {
+	var stats rollupRow
+	err := m.db.Table(res.table).
+		Select("COALESCE(MAX(peak_connections), 0) AS peak_connections, COALESCE(SUM(websocket_messages), 0) AS websocket_messages, "+
+			"COALESCE(SUM(api_messages), 0) AS api_messages, COALESCE(SUM(dropped_messages), 0) AS dropped_messages, "+
+			"COALESCE(MAX(peak_send_queue_depth), 0) AS peak_send_queue_depth, COALESCE(SUM(bytes_received), 0) AS bytes_received, "+
+			"COALESCE(SUM(bytes_sent), 0) AS bytes_sent, COALESCE(SUM(connection_seconds), 0) AS connection_seconds, "+
+			"COALESCE(SUM(subscription_failures), 0) AS subscription_failures, COALESCE(MAX(delivery_latency_p50), 0) AS delivery_latency_p50, "+
+			"COALESCE(MAX(delivery_latency_p95), 0) AS delivery_latency_p95, COALESCE(MAX(delivery_latency_p99), 0) AS delivery_latency_p99, "+
+			"COALESCE(MAX(write_duration_p50), 0) AS write_duration_p50, COALESCE(MAX(write_duration_p95), 0) AS write_duration_p95, "+
+			"COALESCE(MAX(write_duration_p99), 0) AS write_duration_p99").
+		Where("app_id = ?", appId).
+		Where(res.timeColumn+" >= ?", from).
+		Where(res.timeColumn+" < ?", to).
+		Take(&stats).
+		Error
+
+	return stats, err
+}
+
+// startOfDay returns the midnight that started the day of t.
+func startOfDay(t time.Time) time.Time {
+	year, month, day := t.Date()
+	return time.Date(year, month, day, 0, 0, 0, 0, t.Location())
+}
+
+// percentileMicros returns the percentiles in microseconds, the unit they are stored in.
+func percentileMicros(p statistics.LatencyPercentiles) (int, int, int) {
+	return int(p.P50 / time.Microsecond), int(p.P95 / time.Microsecond), int(p.P99 / time.Microsecond)
+}
+
+func percentilesFromMicros(p50, p95, p99 int) statistics.LatencyPercentiles {
+	return statistics.LatencyPercentiles{
+		P50: time.Duration(p50) * time.Microsecond,
+		P95: time.Duration(p95) * time.Microsecond,
+		P99: time.Duration(p99) * time.Microsecond,
+	}
 }
@@ -0,0 +1,163 @@
+package stores
+
+import (
+	"github.com/iamsayantan/larasockets/statistics"
+	"sync"
+	"time"
+)
+
+// memoryRecord is a statistic together with the time it was stored.
+type memoryRecord struct {
+	createdAt time.Time
+	statistic statistics.Statistic
+}
+
+// memoryRing keeps the latest records of an app, once it is full the oldest record is overwritten.
+type memoryRing struct {
+	records []memoryRecord
+	// next is the position the next record is written to, which is the oldest record once the
+	// ring is full.
+	next int
+}
+
+// NewMemoryStorage returns a StatsStorage that keeps the latest capacity statistics of every app in
+// memory. It is meant for development and small deployments, the statistics are lost on restart.
+func NewMemoryStorage(capacity int) statistics.StatsStorage {
+	return &memoryStore{capacity: capacity, rings: make(map[string]*memoryRing)}
+}
+
+type memoryStore struct {
+	capacity int
+
+	mu    sync.RWMutex
+	rings map[string]*memoryRing
+}
+
+func (m *memoryStore) Store(statistic statistics.Statistic) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	ring, ok := m.rings[statistic.AppId()]
+	if !ok {
+		ring = &memoryRing{records: make([]memoryRecord, 0)}
+		m.rings[statistic.AppId()] = ring
+	}
+
+	record := memoryRecord{createdAt: time.Now(), statistic: statistic}
+	if len(ring.records) < m.capacity {
+		ring.records = append(ring.records, record)
+		return
+	}
+
+	ring.records[ring.next] = record
+	ring.next = (ring.next + 1) % m.capacity
+}
+
+func (m *memoryStore) DailyStatForApp(appId string) *statistics.Statistic {
+	startOfDay := startOfDay(time.Now())
+	return m.UsageForApp(appId, startOfDay, startOfDay.AddDate(0, 0, 1))
+}
+
+func (m *memoryStore) UsageForApp(appId string, startTime time.Time, endTime time.Time) *statistics.Statistic {
+	usage := statistics.NewStatistic(appId)
+	m.each(appId, func(record memoryRecord) {
+		if record.createdAt.Before(startTime) || !record.createdAt.Before(endTime) {
+			return
+		}
+
+		usage.Merge(&record.statistic)
+	})
+
+	return usage
+}
+
+func (m *memoryStore) StatsByTimeRange(appId string, startTime time.Time, endTime time.Time) *statistics.StatisticByTime {
+	records := make([]memoryRecord, 0)
+	m.each(appId, func(record memoryRecord) {
+		if record.createdAt.Before(startTime) || record.createdAt.After(endTime) {
+			return
+		}
+
+		records = append(records, record)
+	})
+
+	// the records are stored oldest first, but like the database store they are returned newest first.
+	statsResponse := statistics.NewStatisticByTime()
+	for i := len(records) - 1; i >= 0; i-- {
+		stat := records[i].statistic
+		statsResponse.Set(records[i].createdAt, &stat)
+	}
+
+	return statsResponse
+}
+
+func (m *memoryStore) TopTraffic(appId string, kind string, startTime time.Time, endTime time.Time, limit int) []statistics.TrafficStatistic {
+	totals := make(map[string]*statistics.TrafficStatistic)
+	m.each(appId, func(record memoryRecord) {
+		if record.createdAt.Before(startTime) || record.createdAt.After(endTime) {
+			return
+		}
+
+		traffic := record.statistic.EventTraffic()
+		if kind == statistics.TrafficChannel {
+			traffic = record.statistic.ChannelTraffic()
+		}
+
+		for _, t := range traffic {
+			total, ok := totals[t.Name]
+			if !ok {
+				total = &statistics.TrafficStatistic{Name: t.Name}
+				totals[t.Name] = total
+			}
+
+			total.Messages += t.Messages
+			total.Bytes += t.Bytes
+			total.PeakSubscribers = maxInt(total.PeakSubscribers, t.PeakSubscribers)
+		}
+	})
+
+	top := make([]statistics.TrafficStatistic, 0, len(totals))
+	for _, total := range totals {
+		top = append(top, *total)
+	}
+
+	statistics.SortTraffic(top)
+	if len(top) > limit {
+		top = top[:limit]
+	}
+
+	return top
+}
+
+// each calls fn with every record of the app, oldest first.
+func (m *memoryStore) each(appId string, fn func(record memoryRecord)) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	ring, ok := m.rings[appId]
+	if !ok {
+		return
+	}
+
+	for i := range ring.records {
+		fn(ring.records[(ring.next+i)%len(ring.records)])
+	}
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+
+	return b
+}
+
+func (m *memoryStore) StatsForInterval(appId string, startTime time.Time, endTime time.Time, interval time.Duration) *statistics.StatisticByTime {
+	return m.StatsByTimeRange(appId, startTime, endTime).Buckets(appId, startTime, endTime, interval)
+}
+
+func (m *memoryStore) Health() statistics.StoreHealth {
+	return statistics.StoreHealth{Healthy: true}
+}
+
+func (m *memoryStore) Close() {}
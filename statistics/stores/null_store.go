@@ -0,0 +1,42 @@
+package stores
+
+import (
+	"github.com/iamsayantan/larasockets/statistics"
+	"time"
+)
+
+// NewNullStorage returns a StatsStorage that discards all the statistics, for running the server
+// without any statistics storage.
+func NewNullStorage() statistics.StatsStorage {
+	return &nullStore{}
+}
+
+type nullStore struct{}
+
+func (n *nullStore) Store(statistic statistics.Statistic) {}
+
+func (n *nullStore) DailyStatForApp(appId string) *statistics.Statistic {
+	return statistics.NewStatistic(appId)
+}
+
+func (n *nullStore) UsageForApp(appId string, startTime time.Time, endTime time.Time) *statistics.Statistic {
+	return statistics.NewStatistic(appId)
+}
+
+func (n *nullStore) StatsByTimeRange(appId string, startTime time.Time, endTime time.Time) *statistics.StatisticByTime {
+	return statistics.NewStatisticByTime()
+}
+
+func (n *nullStore) StatsForInterval(appId string, startTime time.Time, endTime time.Time, interval time.Duration) *statistics.StatisticByTime {
+	return n.StatsByTimeRange(appId, startTime, endTime).Buckets(appId, startTime, endTime, interval)
+}
+
+func (n *nullStore) TopTraffic(appId string, kind string, startTime time.Time, endTime time.Time, limit int) []statistics.TrafficStatistic {
+	return make([]statistics.TrafficStatistic, 0)
+}
+
+func (n *nullStore) Health() statistics.StoreHealth {
+	return statistics.StoreHealth{Healthy: true}
+}
+
+func (n *nullStore) Close() {}
@@ -0,0 +1,194 @@
+package stores
+
+import (
+	"fmt"
+	"github.com/iamsayantan/larasockets/config"
+	"github.com/iamsayantan/larasockets/statistics"
+	"gorm.io/gorm"
+)
+
+// postgresMigrations create the postgres schema of the statistics. They are applied in order and
+// the version of a migration is its position in the list, so released migrations must never be
+// changed, new ones are appended instead.
+var postgresMigrations = []string{
+	`CREATE TABLE IF NOT EXISTS larasockets_statistics (
+		id BIGSERIAL PRIMARY KEY,
+		app_id TEXT NOT NULL,
+		peak_connections INTEGER NOT NULL DEFAULT 0,
+		websocket_messages INTEGER NOT NULL DEFAULT 0,
+		api_messages INTEGER NOT NULL DEFAULT 0,
+		dropped_messages INTEGER NOT NULL DEFAULT 0,
+		peak_send_queue_depth INTEGER NOT NULL DEFAULT 0,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+		updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	)`,
+	`CREATE INDEX IF NOT EXISTS idx_larasockets_statistics_app_id_created_at
+		ON larasockets_statistics (app_id, created_at)`,
+	`CREATE TABLE IF NOT EXISTS larasockets_statistics_minutes (
+		id BIGSERIAL PRIMARY KEY,
+		app_id TEXT NOT NULL,
+		bucket TIMESTAMPTZ NOT NULL,
+		peak_connections INTEGER NOT NULL DEFAULT 0,
+		websocket_messages INTEGER NOT NULL DEFAULT 0,
+		api_messages INTEGER NOT NULL DEFAULT 0,
+		dropped_messages INTEGER NOT NULL DEFAULT 0,
+		peak_send_queue_depth INTEGER NOT NULL DEFAULT 0
+	)`,
+	`CREATE UNIQUE INDEX IF NOT EXISTS idx_larasockets_statistics_minutes_app_id_bucket
+		ON larasockets_statistics_minutes (app_id, bucket)`,
+	`CREATE TABLE IF NOT EXISTS larasockets_statistics_hours (
+		id BIGSERIAL PRIMARY KEY,
+		app_id TEXT NOT NULL,
+		bucket TIMESTAMPTZ NOT NULL,
+		peak_connections INTEGER NOT NULL DEFAULT 0,
+		websocket_messages INTEGER NOT NULL DEFAULT 0,
+		api_messages INTEGER NOT NULL DEFAULT 0,
+		dropped_messages INTEGER NOT NULL DEFAULT 0,
+		peak_send_queue_depth INTEGER NOT NULL DEFAULT 0
+	)`,
+	`CREATE UNIQUE INDEX IF NOT EXISTS idx_larasockets_statistics_hours_app_id_bucket
+		ON larasockets_statistics_hours (app_id, bucket)`,
+	`CREATE TABLE IF NOT EXISTS larasockets_statistics_days (
+		id BIGSERIAL PRIMARY KEY,
+		app_id TEXT NOT NULL,
+		bucket TIMESTAMPTZ NOT NULL,
+		peak_connections INTEGER NOT NULL DEFAULT 0,
+		websocket_messages INTEGER NOT NULL DEFAULT 0,
+		api_messages INTEGER NOT NULL DEFAULT 0,
+		dropped_messages INTEGER NOT NULL DEFAULT 0,
+		peak_send_queue_depth INTEGER NOT NULL DEFAULT 0
+	)`,
+	`CREATE UNIQUE INDEX IF NOT EXISTS idx_larasockets_statistics_days_app_id_bucket
+		ON larasockets_statistics_days (app_id, bucket)`,
+	`CREATE TABLE IF NOT EXISTS larasockets_statistic_rollup_progresses (
+		resolution TEXT PRIMARY KEY,
+		rolled_until TIMESTAMPTZ NOT NULL
+	)`,
+	`ALTER TABLE larasockets_statistics ADD COLUMN IF NOT EXISTS concurrent_connections INTEGER NOT NULL DEFAULT 0`,
+	`ALTER TABLE larasockets_statistics_minutes ADD COLUMN IF NOT EXISTS concurrent_connections INTEGER NOT NULL DEFAULT 0`,
+	`ALTER TABLE larasockets_statistics_hours ADD COLUMN IF NOT EXISTS concurrent_connections INTEGER NOT NULL DEFAULT 0`,
+	`ALTER TABLE larasockets_statistics_days ADD COLUMN IF NOT EXISTS concurrent_connections INTEGER NOT NULL DEFAULT 0`,
+	`CREATE TABLE IF NOT EXISTS larasockets_traffic_statistics (
+		id BIGSERIAL PRIMARY KEY,
+		app_id TEXT NOT NULL,
+		kind TEXT NOT NULL,
+		name TEXT NOT NULL,
+		messages INTEGER NOT NULL DEFAULT 0,
+		bytes INTEGER NOT NULL DEFAULT 0,
+		peak_subscribers INTEGER NOT NULL DEFAULT 0,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	)`,
+	`CREATE INDEX IF NOT EXISTS idx_larasockets_traffic_statistics_app_id_kind_created_at
+		ON larasockets_traffic_statistics (app_id, kind, created_at)`,
+	`ALTER TABLE larasockets_statistics
+		ADD COLUMN IF NOT EXISTS bytes_received BIGINT NOT NULL DEFAULT 0,
+		ADD COLUMN IF NOT EXISTS bytes_sent BIGINT NOT NULL DEFAULT 0,
+		ADD COLUMN IF NOT EXISTS delivery_latency_p50 INTEGER NOT NULL DEFAULT 0,
+		ADD COLUMN IF NOT EXISTS delivery_latency_p95 INTEGER NOT NULL DEFAULT 0,
+		ADD COLUMN IF NOT EXISTS delivery_latency_p99 INTEGER NOT NULL DEFAULT 0,
+		ADD COLUMN IF NOT EXISTS write_duration_p50 INTEGER NOT NULL DEFAULT 0,
+		ADD COLUMN IF NOT EXISTS write_duration_p95 INTEGER NOT NULL DEFAULT 0,
+		ADD COLUMN IF NOT EXISTS write_duration_p99 INTEGER NOT NULL DEFAULT 0`,
+	`ALTER TABLE larasockets_statistics_minutes
+		ADD COLUMN IF NOT EXISTS bytes_received BIGINT NOT NULL DEFAULT 0,
+		ADD COLUMN IF NOT EXISTS bytes_sent BIGINT NOT NULL DEFAULT 0,
+		ADD COLUMN IF NOT EXISTS delivery_latency_p50 INTEGER NOT NULL DEFAULT 0,
+		ADD COLUMN IF NOT EXISTS delivery_latency_p95 INTEGER NOT NULL DEFAULT 0,
+		ADD COLUMN IF NOT EXISTS delivery_latency_p99 INTEGER NOT NULL DEFAULT 0,
+		ADD COLUMN IF NOT EXISTS write_duration_p50 INTEGER NOT NULL DEFAULT 0,
+		ADD COLUMN IF NOT EXISTS write_duration_p95 INTEGER NOT NULL DEFAULT 0,
+		ADD COLUMN IF NOT EXISTS write_duration_p99 INTEGER NOT NULL DEFAULT 0`,
+	`ALTER TABLE larasockets_statistics_hours
+		ADD COLUMN IF NOT EXISTS bytes_received BIGINT NOT NULL DEFAULT 0,
+		ADD COLUMN IF NOT EXISTS bytes_sent BIGINT NOT NULL DEFAULT 0,
+		ADD COLUMN IF NOT EXISTS delivery_latency_p50 INTEGER NOT NULL DEFAULT 0,
+		ADD COLUMN IF NOT EXISTS delivery_latency_p95 INTEGER NOT NULL DEFAULT 0,
+		ADD COLUMN IF NOT EXISTS delivery_latency_p99 INTEGER NOT NULL DEFAULT 0,
+		ADD COLUMN IF NOT EXISTS write_duration_p50 INTEGER NOT NULL DEFAULT 0,
+		ADD COLUMN IF NOT EXISTS write_duration_p95 INTEGER NOT NULL DEFAULT 0,
+		ADD COLUMN IF NOT EXISTS write_duration_p99 INTEGER NOT NULL DEFAULT 0`,
+	`ALTER TABLE larasockets_statistics_days
+		ADD COLUMN IF NOT EXISTS bytes_received BIGINT NOT NULL DEFAULT 0,
+		ADD COLUMN IF NOT EXISTS bytes_sent BIGINT NOT NULL DEFAULT 0,
+		ADD COLUMN IF NOT EXISTS delivery_latency_p50 INTEGER NOT NULL DEFAULT 0,
+		ADD COLUMN IF NOT EXISTS delivery_latency_p95 INTEGER NOT NULL DEFAULT 0,
+		ADD COLUMN IF NOT EXISTS delivery_latency_p99 INTEGER NOT NULL DEFAULT 0,
+		ADD COLUMN IF NOT EXISTS write_duration_p50 INTEGER NOT NULL DEFAULT 0,
+		ADD COLUMN IF NOT EXISTS write_duration_p95 INTEGER NOT NULL DEFAULT 0,
+		ADD COLUMN IF NOT EXISTS write_duration_p99 INTEGER NOT NULL DEFAULT 0`,
+	`ALTER TABLE larasockets_statistics
+		ADD COLUMN IF NOT EXISTS connection_seconds BIGINT NOT NULL DEFAULT 0`,
+	`ALTER TABLE larasockets_statistics_minutes
+		ADD COLUMN IF NOT EXISTS connection_seconds BIGINT NOT NULL DEFAULT 0`,
+	`ALTER TABLE larasockets_statistics_hours
+		ADD COLUMN IF NOT EXISTS connection_seconds BIGINT NOT NULL DEFAULT 0`,
+	`ALTER TABLE larasockets_statistics_days
+		ADD COLUMN IF NOT EXISTS connection_seconds BIGINT NOT NULL DEFAULT 0`,
+	`ALTER TABLE larasockets_statistics
+		ADD COLUMN IF NOT EXISTS subscription_failures INTEGER NOT NULL DEFAULT 0`,
+	`ALTER TABLE larasockets_statistics_minutes
+		ADD COLUMN IF NOT EXISTS subscription_failures INTEGER NOT NULL DEFAULT 0`,
+	`ALTER TABLE larasockets_statistics_hours
+		ADD COLUMN IF NOT EXISTS subscription_failures INTEGER NOT NULL DEFAULT 0`,
+	`ALTER TABLE larasockets_statistics_days
+		ADD COLUMN IF NOT EXISTS subscription_failures INTEGER NOT NULL DEFAULT 0`,
+	`ALTER TABLE larasockets_statistics ADD COLUMN IF NOT EXISTS record_key TEXT`,
+	`CREATE UNIQUE INDEX IF NOT EXISTS idx_larasockets_statistics_record_key
+		ON larasockets_statistics (record_key)`,
+	`ALTER TABLE larasockets_traffic_statistics ADD COLUMN IF NOT EXISTS record_key TEXT`,
+	`CREATE UNIQUE INDEX IF NOT EXISTS idx_larasockets_traffic_statistics_record
+		ON larasockets_traffic_statistics (record_key, kind, name)`,
+}
+
+// NewPostgresStorage returns a StatsStorage that stores the statistics in postgres. Unlike the other
+// sql databases, which are migrated by gorm, the postgres schema is created by its own versioned
+// migrations, which are applied before the storage is returned.
+func NewPostgresStorage(db *gorm.DB, retention config.RetentionConfig, writes config.WriteBufferConfig) (statistics.StatsStorage, error) {
+	if err := migratePostgres(db); err != nil {
+		return nil, err
+	}
+
+	resolutions := newResolutions(retention)
+	return &postgresStore{dbStore{db: db, resolutions: resolutions, writes: newWriteBuffer(db, resolutions, writes)}}, nil
+}
+
+// postgresStore shares the queries of the database store, all of them are portable and the
+// time ranges are always passed as timestamptz parameters.
+type postgresStore struct {
+	dbStore
+}
+
+// migratePostgres applies the migrations that were not applied yet, each in its own transaction.
+func migratePostgres(db *gorm.DB) error {
+	err := db.Exec(`CREATE TABLE IF NOT EXISTS larasockets_statistics_migrations (
+		version INTEGER PRIMARY KEY,
+		applied_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	)`).Error
+
+	if err != nil {
+		return fmt.Errorf("error creating the migrations table: %w", err)
+	}
+
+	var appliedVersion int
+	err = db.Raw("SELECT COALESCE(MAX(version), 0) FROM larasockets_statistics_migrations").Scan(&appliedVersion).Error
+	if err != nil {
+		return fmt.Errorf("error reading the applied migrations: %w", err)
+	}
+
+	for i := appliedVersion; i < len(postgresMigrations); i++ {
+		version := i + 1
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Exec(postgresMigrations[i]).Error; err != nil {
+				return err
+			}
+
+			return tx.Exec("INSERT INTO larasockets_statistics_migrations (version) VALUES (?)", version).Error
+		})
+
+		if err != nil {
+			return fmt.Errorf("error applying migration %d: %w", version, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,166 @@
+package stores
+
+import (
+	"fmt"
+	"github.com/iamsayantan/larasockets/config"
+	"github.com/iamsayantan/larasockets/statistics"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+	"os"
+	"testing"
+	"time"
+)
+
+// openTestPostgres connects to the postgres database of LARASOCKETS_TEST_POSTGRES_DSN, the test is
+// skipped when it is not set. Every test gets a schema of its own, which is dropped afterwards.
+func openTestPostgres(t *testing.T) *gorm.DB {
+	t.Helper()
+
+	dsn := os.Getenv("LARASOCKETS_TEST_POSTGRES_DSN")
+	if dsn == "" {
+		t.Skip("LARASOCKETS_TEST_POSTGRES_DSN is not set")
+	}
+
+	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatalf("error connecting to postgres: %s", err.Error())
+	}
+
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatalf("error connecting to postgres: %s", err.Error())
+	}
+
+	// the search path is set per connection, so a single connection is used.
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { _ = sqlDB.Close() })
+
+	schema := fmt.Sprintf("larasockets_test_%d", time.Now().UnixNano())
+	if err := db.Exec("CREATE SCHEMA " + schema).Error; err != nil {
+		t.Fatalf("error creating the test schema: %s", err.Error())
+	}
+
+	t.Cleanup(func() { db.Exec("DROP SCHEMA " + schema + " CASCADE") })
+
+	if err := db.Exec("SET search_path TO " + schema).Error; err != nil {
+		t.Fatalf("error selecting the test schema: %s", err.Error())
+	}
+
+	return db
+}
+
+func newTestPostgresStore(t *testing.T, db *gorm.DB) *postgresStore {
+	t.Helper()
+
+	store, err := NewPostgresStorage(db, testRetention, config.WriteBufferConfig{BatchSize: 100, FlushInterval: time.Hour, Capacity: 1000})
+	if err != nil {
+		t.Fatalf("error creating the postgres storage: %s", err.Error())
+	}
+
+	t.Cleanup(store.Close)
+	return store.(*postgresStore)
+}
+
+func TestPostgresMigrationsAreIdempotent(t *testing.T) {
+	db := openTestPostgres(t)
+
+	for i := 0; i < 2; i++ {
+		if err := migratePostgres(db); err != nil {
+			t.Fatalf("error migrating postgres, run %d: %s", i+1, err.Error())
+		}
+	}
+
+	var applied, latest int
+	db.Raw("SELECT COUNT(*), COALESCE(MAX(version), 0) FROM larasockets_statistics_migrations").Row().Scan(&applied, &latest)
+	if applied != len(postgresMigrations) || latest != len(postgresMigrations) {
+		t.Fatalf("expected %d migrations to be applied once, got %d up to version %d", len(postgresMigrations), applied, latest)
+	}
+
+	// the storage migrates the database itself, a migrated database must be left as it is.
+	newTestPostgresStore(t, db)
+}
+
+func TestPostgresStatsByTimeRange(t *testing.T) {
+	db := openTestPostgres(t)
+	store := newTestPostgresStore(t, db)
+
+	now := time.Now()
+	start := now.Add(-2 * time.Hour).Truncate(time.Hour)
+	end := start.Add(30 * time.Minute)
+
+	records := testRecords("1", start, end, time.Minute)
+	writeTestRecords(t, store.writes, records)
+	writeTestRecords(t, store.writes, testRecords("2", start, end, time.Minute))
+
+	expected := 0
+	for _, record := range records {
+		expected += record.Statistic.WebsocketMessages
+	}
+
+	check := func(when string) {
+		stats := store.StatsByTimeRange("1", start, end)
+
+		websocketMessages := 0
+		for _, timestamp := range stats.Timestamps() {
+			websocketMessages += stats.Get(timestamp).WebsocketMessages()
+		}
+
+		if websocketMessages != expected {
+			t.Fatalf("expected %d websocket messages %s, got %d", expected, when, websocketMessages)
+		}
+	}
+
+	check("before the rollups")
+	rollupTestStatistics(t, db, now)
+	check("after the rollups")
+}
+
+func TestPostgresTopTraffic(t *testing.T) {
+	db := openTestPostgres(t)
+	store := newTestPostgresStore(t, db)
+
+	now := time.Now()
+	start := now.Add(-time.Hour).Truncate(time.Minute)
+	end := start.Add(10 * time.Minute)
+
+	records := testRecords("1", start, end, time.Minute)
+	for i := range records {
+		key := *records[i].Statistic.RecordKey
+		createdAt := records[i].Statistic.CreatedAt
+		records[i].Traffic = []LarasocketsTrafficStatistic{
+			newTrafficRow("1", key, statistics.TrafficChannel, statistics.TrafficStatistic{Name: "busy", Messages: 10, Bytes: 100, PeakSubscribers: i}, createdAt),
+			newTrafficRow("1", key, statistics.TrafficChannel, statistics.TrafficStatistic{Name: "quiet", Messages: 1, Bytes: 10, PeakSubscribers: 1}, createdAt),
+			newTrafficRow("1", key, statistics.TrafficEvent, statistics.TrafficStatistic{Name: "event", Messages: 11, Bytes: 110}, createdAt),
+		}
+	}
+
+	writeTestRecords(t, store.writes, records)
+	// the same traffic written again must not be counted twice.
+	writeTestRecords(t, store.writes, records)
+
+	channels := store.TopTraffic("1", statistics.TrafficChannel, start, end, 10)
+	expected := []statistics.TrafficStatistic{
+		{Name: "busy", Messages: 10 * len(records), Bytes: 100 * len(records), PeakSubscribers: len(records) - 1},
+		{Name: "quiet", Messages: len(records), Bytes: 10 * len(records), PeakSubscribers: 1},
+	}
+
+	if len(channels) != len(expected) {
+		t.Fatalf("expected the traffic of %d channels, got %+v", len(expected), channels)
+	}
+
+	for i := range expected {
+		if channels[i] != expected[i] {
+			t.Fatalf("expected channel traffic %+v, got %+v", expected[i], channels[i])
+		}
+	}
+
+	if top := store.TopTraffic("1", statistics.TrafficChannel, start, end, 1); len(top) != 1 || top[0].Name != "busy" {
+		t.Fatalf("expected only the busy channel, got %+v", top)
+	}
+
+	events := store.TopTraffic("1", statistics.TrafficEvent, start, end, 10)
+	if len(events) != 1 || events[0].Messages != 11*len(records) {
+		t.Fatalf("expected %d event messages, got %+v", 11*len(records), events)
+	}
+}
@@ -0,0 +1,474 @@
+package stores
+
+import (
+	"github.com/iamsayantan/larasockets/config"
+	"github.com/iamsayantan/larasockets/statistics"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"log"
+	"sync"
+	"time"
+)
+
+// rollupDelay is how long the raw statistics of a minute are waited for before the minute is
+// rolled up. Statistics stored later than that rewind the rollups, see writeBuffer.rewindRollups,
+// the delay keeps the buckets from being rolled up again for every dump of the collector.
+const rollupDelay = time.Minute
+
+// LarasocketsStatisticRollup is the aggregate of the statistics of an app over a bucket of time.
+// The peaks and the latency percentiles are the highest value within the bucket, the concurrent
+// connections are the last value within the bucket and everything else is the sum.
+type LarasocketsStatisticRollup struct {
+	ID                    uint      `gorm:"primarykey"`
+	AppId                 string    `gorm:"size:191;uniqueIndex:,composite:app_id_bucket,priority:1"`
+	Bucket                time.Time `gorm:"uniqueIndex:,composite:app_id_bucket,priority:2"`
+	ConcurrentConnections int
+	PeakConnections       int
+	WebsocketMessages     int
+	ApiMessages           int
+	DroppedMessages       int
+	PeakSendQueueDepth    int
+	BytesReceived         int
+	BytesSent             int
+	ConnectionSeconds     int
+	SubscriptionFailures  int
+	// the latency percentiles are in microseconds.
+	DeliveryLatencyP50 int
+	DeliveryLatencyP95 int
+	DeliveryLatencyP99 int
+	WriteDurationP50   int
+	WriteDurationP95   int
+	WriteDurationP99   int
+}
+
+// LarasocketsStatisticMinute is a rollup of the raw statistics of a minute.
+type LarasocketsStatisticMinute struct {
+	LarasocketsStatisticRollup
+}
+
+func (LarasocketsStatisticMinute) TableName() string {
+	return "larasockets_statistics_minutes"
+}
+
+// LarasocketsStatisticHour is a rollup of the minute rollups of an hour.
+type LarasocketsStatisticHour struct {
+	LarasocketsStatisticRollup
+}
+
+func (LarasocketsStatisticHour) TableName() string {
+	return "larasockets_statistics_hours"
+}
+
+// LarasocketsStatisticDay is a rollup of the hour rollups of a day, the days are in UTC.
+type LarasocketsStatisticDay struct {
+	LarasocketsStatisticRollup
+}
+
+func (LarasocketsStatisticDay) TableName() string {
+	return "larasockets_statistics_days"
+}
+
+// LarasocketsStatisticRollupProgress records up to when a resolution has been rolled up. Every
+// bucket before RolledUntil is complete, even the ones without a row because nothing happened.
+// Statistics stored late move RolledUntil back, the buckets after it are rolled up again.
+type LarasocketsStatisticRollupProgress struct {
+	Resolution  string `gorm:"primarykey;size:32"`
+	RolledUntil time.Time
+}
+
+// RollupTables returns the tables of the rollups and of the traffic statistics, to be migrated
+// together with the statistics.
+func RollupTables() []interface{} {
+	return []interface{}{
+		&LarasocketsStatisticMinute{},
+		&LarasocketsStatisticHour{},
+		&LarasocketsStatisticDay{},
+		&LarasocketsStatisticRollupProgress{},
+		&LarasocketsTrafficStatistic{},
+	}
+}
+
+// resolution is one of the granularities the statistics are stored in.
+type resolution struct {
+	name       string
+	table      string
+	timeColumn string
+	// step is the size of a bucket, the raw statistics are stored every dump interval, which is
+	// five seconds by default.
+	step time.Duration
+	// window is the span of the finer resolution aggregated at once while catching up.
+	window    time.Duration
+	retention time.Duration
+}
+
+// newResolutions returns the resolutions from the finest to the coarsest, every resolution is
+// rolled up from the one before it.
+func newResolutions(retention config.RetentionConfig) []resolution {
+	return []resolution{
+		{name: "raw", table: "larasockets_statistics", timeColumn: "created_at", step: 5 * time.Second, retention: retention.Raw},
+		{name: "minute", table: "larasockets_statistics_minutes", timeColumn: "bucket", step: time.Minute, window: time.Hour, retention: retention.Minute},
+		{name: "hour", table: "larasockets_statistics_hours", timeColumn: "bucket", step: time.Hour, window: 24 * time.Hour, retention: retention.Hour},
+		{name: "day", table: "larasockets_statistics_days", timeColumn: "bucket", step: 24 * time.Hour, window: 30 * 24 * time.Hour, retention: retention.Day},
+	}
+}
+
+// rollupRow is a row of any of the resolutions, with its time under a common name.
+type rollupRow struct {
+	AppId                 string
+	ObservedAt            time.Time
+	ConcurrentConnections int
+	PeakConnections       int
+	WebsocketMessages     int
+	ApiMessages           int
+	DroppedMessages       int
+	PeakSendQueueDepth    int
+	BytesReceived         int
+	BytesSent             int
+	ConnectionSeconds     int
+	SubscriptionFailures  int
+	// the latency percentiles are in microseconds.
+	DeliveryLatencyP50 int
+	DeliveryLatencyP95 int
+	DeliveryLatencyP99 int
+	WriteDurationP50   int
+	WriteDurationP95   int
+	WriteDurationP99   int
+}
+
+// statisticColumns are the columns of the statistics shared by all the resolutions.
+const statisticColumns = "concurrent_connections, peak_connections, websocket_messages, api_messages, dropped_messages, " +
+	"peak_send_queue_depth, bytes_received, bytes_sent, connection_seconds, subscription_failures, delivery_latency_p50, delivery_latency_p95, delivery_latency_p99, " +
+	"write_duration_p50, write_duration_p95, write_duration_p99"
+
+func (row rollupRow) deliveryLatency() statistics.LatencyPercentiles {
+	return percentilesFromMicros(row.DeliveryLatencyP50, row.DeliveryLatencyP95, row.DeliveryLatencyP99)
+}
+
+func (row rollupRow) writeDuration() statistics.LatencyPercentiles {
+	return percentilesFromMicros(row.WriteDurationP50, row.WriteDurationP95, row.WriteDurationP99)
+}
+
+func (row rollupRow) statistic(appId string) *statistics.Statistic {
+	stat := statistics.NewStatisticWithData(appId, row.ConcurrentConnections, row.PeakConnections, row.WebsocketMessages, row.ApiMessages, row.DroppedMessages, row.PeakSendQueueDepth)
+	stat.SetBandwidth(row.BytesReceived, row.BytesSent)
+	stat.SetConnectionSeconds(row.ConnectionSeconds)
+	stat.SetSubscriptionFailures(row.SubscriptionFailures)
+	stat.SetLatencies(row.deliveryLatency(), row.writeDuration())
+
+	return stat
+}
+
+// merge adds the sums and the peaks of o to the row, the concurrent connections of the row are
+// kept.
+func (row rollupRow) merge(o rollupRow) rollupRow {
+	row.PeakConnections = maxInt(row.PeakConnections, o.PeakConnections)
+	row.WebsocketMessages += o.WebsocketMessages
+	row.ApiMessages += o.ApiMessages
+	row.DroppedMessages += o.DroppedMessages
+	row.PeakSendQueueDepth = maxInt(row.PeakSendQueueDepth, o.PeakSendQueueDepth)
+	row.BytesReceived += o.BytesReceived
+	row.BytesSent += o.BytesSent
+	row.ConnectionSeconds += o.ConnectionSeconds
+	row.SubscriptionFailures += o.SubscriptionFailures
+	row.DeliveryLatencyP50 = maxInt(row.DeliveryLatencyP50, o.DeliveryLatencyP50)
+	row.DeliveryLatencyP95 = maxInt(row.DeliveryLatencyP95, o.DeliveryLatencyP95)
+	row.DeliveryLatencyP99 = maxInt(row.DeliveryLatencyP99, o.DeliveryLatencyP99)
+	row.WriteDurationP50 = maxInt(row.WriteDurationP50, o.WriteDurationP50)
+	row.WriteDurationP95 = maxInt(row.WriteDurationP95, o.WriteDurationP95)
+	row.WriteDurationP99 = maxInt(row.WriteDurationP99, o.WriteDurationP99)
+
+	return row
+}
+
+// rolledUntil returns the time up to which the resolution is complete. The raw statistics are
+// always complete.
+func rolledUntil(db *gorm.DB, res resolution) (time.Time, error) {
+	if res.window == 0 {
+		return time.Now(), nil
+	}
+
+	var progress LarasocketsStatisticRollupProgress
+	err := db.Where("resolution = ?", res.name).Limit(1).Find(&progress).Error
+
+	return progress.RolledUntil, err
+}
+
+// Rollups aggregates the raw statistics into the minute, hour and day rollups in the background
+// and prunes the statistics that are older than the retention of their resolution.
+type Rollups struct {
+	db          *gorm.DB
+	interval    time.Duration
+	resolutions []resolution
+
+	stopOnce  sync.Once
+	stopCh    chan struct{}
+	stoppedCh chan struct{}
+}
+
+// NewRollups returns the rollups of the statistics stored in db. They do not run until Start
+// is called.
+func NewRollups(db *gorm.DB, cfg config.RollupConfig) *Rollups {
+	return &Rollups{
+		db:          db,
+		interval:    cfg.Interval,
+		resolutions: newResolutions(cfg.Retention),
+		stopCh:      make(chan struct{}),
+		stoppedCh:   make(chan struct{}),
+	}
+}
+
+// Start runs the rollups and the pruning periodically until Stop is called.
+func (r *Rollups) Start() {
+	go func() {
+		ticker := time.NewTicker(r.interval)
+		defer ticker.Stop()
+		defer close(r.stoppedCh)
+
+		r.run()
+		for {
+			select {
+			case <-ticker.C:
+				r.run()
+			case <-r.stopCh:
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops the periodic rollups, waiting for a running one to finish.
+func (r *Rollups) Stop() {
+	r.stopOnce.Do(func() {
+		close(r.stopCh)
+		<-r.stoppedCh
+	})
+}
+
+func (r *Rollups) run() {
+	now := time.Now()
+	if err := r.Rollup(now); err != nil {
+		log.Printf("error rolling up statistics: %s", err.Error())
+	}
+
+	if err := r.Prune(now); err != nil {
+		log.Printf("error pruning statistics: %s", err.Error())
+	}
+}
+
+// Rollup aggregates all the complete buckets up to now, every resolution from the one before it.
+// It continues where the previous run stopped, so it can be run any number of times.
+func (r *Rollups) Rollup(now time.Time) error {
+	sourceUntil := now.Add(-rollupDelay)
+	for i := 1; i < len(r.resolutions); i++ {
+		res := r.resolutions[i]
+		if err := r.rollup(r.resolutions[i-1], res, sourceUntil); err != nil {
+			return err
+		}
+
+		var err error
+		if sourceUntil, err = rolledUntil(r.db, res); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (r *Rollups) rollup(source, res resolution, sourceUntil time.Time) error {
+	until := sourceUntil.Truncate(res.step)
+
+	from, err := rolledUntil(r.db, res)
+	if err != nil {
+		return err
+	}
+
+	if from.IsZero() {
+		// nothing was rolled up yet, start with the oldest statistic of the finer resolution.
+		var oldest rollupRow
+		err = r.db.Table(source.table).
+			Select(source.timeColumn + " AS observed_at").
+			Order(source.timeColumn).
+			Limit(1).
+			Find(&oldest).
+			Error
+
+		if err != nil {
+			return err
+		}
+
+		if oldest.ObservedAt.IsZero() {
+			return nil
+		}
+
+		// the progress is recorded before the first window, so the rollups and the late writes
+		// always find a progress row to lock. Another node might have recorded it first.
+		progress := LarasocketsStatisticRollupProgress{Resolution: res.name, RolledUntil: oldest.ObservedAt.Truncate(res.step)}
+		if err := r.db.Clauses(clause.OnConflict{DoNothing: true}).Create(&progress).Error; err != nil {
+			return err
+		}
+
+		if from, err = rolledUntil(r.db, res); err != nil {
+			return err
+		}
+	}
+
+	for from.Before(until) {
+		to := from.Add(res.window)
+		if to.After(until) {
+			to = until
+		}
+
+		rolled, err := r.rollupWindow(source, res, from, to)
+		if err != nil {
+			return err
+		}
+
+		// the progress was rewound by statistics stored late, the next run continues from there.
+		if !rolled {
+			return nil
+		}
+
+		from = to
+	}
+
+	return nil
+}
+
+// rollupWindow aggregates the rows of the source resolution between from and to, which are both
+// on a bucket boundary, and records the progress in the same transaction. The buckets that were
+// rolled up before are replaced. It reports false without rolling up anything when the progress
+// is no longer at from, because statistics stored late rewound it.
+func (r *Rollups) rollupWindow(source, res resolution, from, to time.Time) (bool, error) {
+	rolled := false
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		// updating the progress row locks it until the transaction ends, statistics stored late
+		// rewind the progress either before the rows are read or after the progress is recorded.
+		progress := tx.Model(&LarasocketsStatisticRollupProgress{}).Where("resolution = ?", res.name)
+		if err := progress.Update("rolled_until", gorm.Expr("rolled_until")).Error; err != nil {
+			return err
+		}
+
+		current, err := rolledUntil(tx, res)
+		if err != nil || !current.Equal(from) {
+			return err
+		}
+
+		rollups, err := r.aggregateWindow(tx, source, res, from, to)
+		if err != nil {
+			return err
+		}
+
+		if len(rollups) > 0 {
+			err := tx.Table(res.table).Clauses(clause.OnConflict{
+				Columns:   []clause.Column{{Name: "app_id"}, {Name: "bucket"}},
+				UpdateAll: true,
+			}).Create(rollups).Error
+
+			if err != nil {
+				return err
+			}
+		}
+
+		rolled = true
+		return tx.Model(&LarasocketsStatisticRollupProgress{}).Where("resolution = ?", res.name).Update("rolled_until", to).Error
+	})
+
+	return rolled, err
+}
+
+// aggregateWindow returns the rollups of the buckets of the rows of the source resolution between
+// from and to.
+func (r *Rollups) aggregateWindow(tx *gorm.DB, source, res resolution, from, to time.Time) ([]*LarasocketsStatisticRollup, error) {
+	var rows []rollupRow
+	err := tx.Table(source.table).
+		Select("app_id, "+source.timeColumn+" AS observed_at, "+statisticColumns).
+		Where(source.timeColumn+" >= ?", from).
+		Where(source.timeColumn+" < ?", to).
+		Find(&rows).
+		Error
+
+	if err != nil {
+		return nil, err
+	}
+
+	type bucketKey struct {
+		appId  string
+		bucket int64
+	}
+
+	buckets := make(map[bucketKey]*LarasocketsStatisticRollup)
+	latest := make(map[bucketKey]time.Time)
+	rollups := make([]*LarasocketsStatisticRollup, 0)
+	for _, row := range rows {
+		bucket := row.ObservedAt.Truncate(res.step)
+		key := bucketKey{appId: row.AppId, bucket: bucket.Unix()}
+
+		rollup, ok := buckets[key]
+		if !ok {
+			rollup = &LarasocketsStatisticRollup{AppId: row.AppId, Bucket: bucket}
+			buckets[key] = rollup
+			rollups = append(rollups, rollup)
+		}
+
+		if !row.ObservedAt.Before(latest[key]) {
+			latest[key] = row.ObservedAt
+			rollup.ConcurrentConnections = row.ConcurrentConnections
+		}
+
+		rollup.PeakConnections = maxInt(rollup.PeakConnections, row.PeakConnections)
+		rollup.WebsocketMessages += row.WebsocketMessages
+		rollup.ApiMessages += row.ApiMessages
+		rollup.DroppedMessages += row.DroppedMessages
+		rollup.PeakSendQueueDepth = maxInt(rollup.PeakSendQueueDepth, row.PeakSendQueueDepth)
+		rollup.BytesReceived += row.BytesReceived
+		rollup.BytesSent += row.BytesSent
+		rollup.ConnectionSeconds += row.ConnectionSeconds
+		rollup.SubscriptionFailures += row.SubscriptionFailures
+		rollup.DeliveryLatencyP50 = maxInt(rollup.DeliveryLatencyP50, row.DeliveryLatencyP50)
+		rollup.DeliveryLatencyP95 = maxInt(rollup.DeliveryLatencyP95, row.DeliveryLatencyP95)
+		rollup.DeliveryLatencyP99 = maxInt(rollup.DeliveryLatencyP99, row.DeliveryLatencyP99)
+		rollup.WriteDurationP50 = maxInt(rollup.WriteDurationP50, row.WriteDurationP50)
+		rollup.WriteDurationP95 = maxInt(rollup.WriteDurationP95, row.WriteDurationP95)
+		rollup.WriteDurationP99 = maxInt(rollup.WriteDurationP99, row.WriteDurationP99)
+	}
+
+	return rollups, nil
+}
+
+// Prune deletes the statistics that are older than the retention of their resolution. Statistics
+// that were not rolled up into the next resolution yet are kept.
+func (r *Rollups) Prune(now time.Time) error {
+	for i, res := range r.resolutions {
+		if res.retention == 0 {
+			continue
+		}
+
+		cutoff := now.Add(-res.retention)
+		if i+1 < len(r.resolutions) {
+			next, err := rolledUntil(r.db, r.resolutions[i+1])
+			if err != nil {
+				return err
+			}
+
+			if next.Before(cutoff) {
+				cutoff = next
+			}
+		}
+
+		err := r.db.Table(res.table).Where(res.timeColumn+" < ?", cutoff).Delete(&rollupRow{}).Error
+		if err != nil {
+			return err
+		}
+	}
+
+	// the traffic of the channels and events is not rolled up, it is kept as long as the raw
+	// statistics are.
+	if retention := r.resolutions[0].retention; retention != 0 {
+		err := r.db.Where("created_at < ?", now.Add(-retention)).Delete(&LarasocketsTrafficStatistic{}).Error
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
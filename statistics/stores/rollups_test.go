@@ -0,0 +1,198 @@
+package stores
+
+import (
+	"fmt"
+	"github.com/glebarez/sqlite"
+	"github.com/iamsayantan/larasockets/config"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var testRetention = config.RetentionConfig{Raw: 48 * time.Hour, Minute: 336 * time.Hour, Hour: 2160 * time.Hour}
+
+func openTestDatabase(t *testing.T) *gorm.DB {
+	t.Helper()
+
+	return openTestDatabaseFile(t, filepath.Join(t.TempDir(), "statistics.db"))
+}
+
+// openTestDatabaseFile opens the sqlite database at path, creating the tables if they do not exist.
+func openTestDatabaseFile(t *testing.T, path string) *gorm.DB {
+	t.Helper()
+
+	db, err := gorm.Open(sqlite.Open(path), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatalf("error opening the database: %s", err.Error())
+	}
+
+	tables := append([]interface{}{&LarasocketsStatistic{}}, RollupTables()...)
+	if err := db.AutoMigrate(tables...); err != nil {
+		t.Fatalf("error migrating the database: %s", err.Error())
+	}
+
+	return db
+}
+
+// newTestStore returns a database store and the write buffer it writes with, the statistics are
+// written by calling write on the buffer so their time can be chosen.
+func newTestStore(t *testing.T, db *gorm.DB) (*dbStore, *writeBuffer) {
+	t.Helper()
+
+	resolutions := newResolutions(testRetention)
+	buffer := newWriteBuffer(db, resolutions, config.WriteBufferConfig{BatchSize: 100, FlushInterval: time.Hour, Capacity: 1000})
+	t.Cleanup(buffer.close)
+
+	return &dbStore{db: db, resolutions: resolutions, writes: buffer}, buffer
+}
+
+// testRecords returns a record of the app every interval from start until end, record i has i+1
+// websocket messages.
+func testRecords(appId string, start, end time.Time, interval time.Duration) []statisticRecord {
+	records := make([]statisticRecord, 0)
+	for i, createdAt := 0, start; createdAt.Before(end); i, createdAt = i+1, createdAt.Add(interval) {
+		key := fmt.Sprintf("%s-%d", appId, createdAt.UnixNano())
+		records = append(records, statisticRecord{Statistic: LarasocketsStatistic{
+			AppId:             appId,
+			RecordKey:         &key,
+			PeakConnections:   i%7 + 1,
+			WebsocketMessages: i + 1,
+			ApiMessages:       2,
+			BytesSent:         100,
+			ConnectionSeconds: 5,
+			CreatedAt:         createdAt,
+			UpdatedAt:         createdAt,
+		}})
+	}
+
+	return records
+}
+
+func writeTestRecords(t *testing.T, buffer *writeBuffer, records []statisticRecord) {
+	t.Helper()
+
+	if err := buffer.write(records); err != nil {
+		t.Fatalf("error writing the statistics: %s", err.Error())
+	}
+}
+
+func rollupTestStatistics(t *testing.T, db *gorm.DB, now time.Time) {
+	t.Helper()
+
+	if err := NewRollups(db, config.RollupConfig{Interval: time.Hour, Retention: testRetention}).Rollup(now); err != nil {
+		t.Fatalf("error rolling up the statistics: %s", err.Error())
+	}
+}
+
+// rawTotals sums the raw statistics of the app within the range.
+func rawTotals(t *testing.T, db *gorm.DB, table, timeColumn, appId string, from, to time.Time) (websocketMessages, apiMessages, rows int) {
+	t.Helper()
+
+	var totals struct {
+		WebsocketMessages int
+		ApiMessages       int
+		Rows              int
+	}
+
+	err := db.Table(table).
+		Select("COALESCE(SUM(websocket_messages), 0) AS websocket_messages, COALESCE(SUM(api_messages), 0) AS api_messages, COUNT(*) AS rows").
+		Where("app_id = ?", appId).
+		Where(timeColumn+" >= ?", from).
+		Where(timeColumn+" < ?", to).
+		Scan(&totals).
+		Error
+
+	if err != nil {
+		t.Fatalf("error summing %s: %s", table, err.Error())
+	}
+
+	return totals.WebsocketMessages, totals.ApiMessages, totals.Rows
+}
+
+func TestRollupIncludesStatisticsWrittenLate(t *testing.T) {
+	db := openTestDatabase(t)
+	store, buffer := newTestStore(t, db)
+
+	now := time.Now()
+	start := now.Add(-6 * time.Hour).Truncate(time.Hour)
+	end := start.Add(2 * time.Hour)
+
+	records := testRecords("1", start, end, 5*time.Second)
+	// every fifth statistic is stored only after the rollups ran, as if it was replayed late.
+	onTime, late := make([]statisticRecord, 0), make([]statisticRecord, 0)
+	for i, record := range records {
+		if i%5 == 0 {
+			late = append(late, record)
+		} else {
+			onTime = append(onTime, record)
+		}
+	}
+
+	writeTestRecords(t, buffer, onTime)
+	rollupTestStatistics(t, db, now)
+
+	minuteUntil, err := rolledUntil(db, store.resolutions[1])
+	if err != nil || minuteUntil.Before(end) {
+		t.Fatalf("expected the minutes to be rolled up past %s, got %s", end, minuteUntil)
+	}
+
+	writeTestRecords(t, buffer, late)
+
+	minuteUntil, _ = rolledUntil(db, store.resolutions[1])
+	if !minuteUntil.Equal(start) {
+		t.Fatalf("expected the late statistics to rewind the minutes to %s, got %s", start, minuteUntil)
+	}
+
+	rollupTestStatistics(t, db, now)
+
+	rawMessages, rawApiMessages, _ := rawTotals(t, db, "larasockets_statistics", "created_at", "1", start, end)
+	for _, res := range store.resolutions[1:3] {
+		messages, apiMessages, _ := rawTotals(t, db, res.table, "bucket", "1", start, end)
+		if messages != rawMessages || apiMessages != rawApiMessages {
+			t.Fatalf("expected the %s rollups to sum up to %d and %d messages, got %d and %d", res.name, rawMessages, rawApiMessages, messages, apiMessages)
+		}
+	}
+
+	usage := store.UsageForApp("1", start, end)
+	if usage.WebsocketMessages() != rawMessages || usage.ApiMessages() != rawApiMessages {
+		t.Fatalf("expected a usage of %d and %d messages, got %d and %d", rawMessages, rawApiMessages, usage.WebsocketMessages(), usage.ApiMessages())
+	}
+}
+
+func TestWriteSkipsStatisticsWrittenBefore(t *testing.T) {
+	db := openTestDatabase(t)
+	store, buffer := newTestStore(t, db)
+
+	now := time.Now()
+	start := now.Add(-3 * time.Hour).Truncate(time.Hour)
+	end := start.Add(time.Hour)
+
+	records := testRecords("1", start, end, 5*time.Second)
+	writeTestRecords(t, buffer, records)
+	rollupTestStatistics(t, db, now)
+
+	// replaying a spill file after a crash writes the same statistics again.
+	writeTestRecords(t, buffer, records[:len(records)/2])
+	writeTestRecords(t, buffer, records)
+	rollupTestStatistics(t, db, now)
+
+	rawMessages, _, rows := rawTotals(t, db, "larasockets_statistics", "created_at", "1", start, end)
+	if rows != len(records) {
+		t.Fatalf("expected %d statistics to be stored, got %d", len(records), rows)
+	}
+
+	expected := 0
+	for _, record := range records {
+		expected += record.Statistic.WebsocketMessages
+	}
+
+	if rawMessages != expected {
+		t.Fatalf("expected %d websocket messages, got %d", expected, rawMessages)
+	}
+
+	if usage := store.UsageForApp("1", start, end); usage.WebsocketMessages() != expected {
+		t.Fatalf("expected a usage of %d websocket messages, got %d", expected, usage.WebsocketMessages())
+	}
+}
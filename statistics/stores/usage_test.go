@@ -0,0 +1,45 @@
+package stores
+
+import (
+	"github.com/iamsayantan/larasockets/statistics"
+	"testing"
+	"time"
+)
+
+func TestUsageReportMatchesRawStatistics(t *testing.T) {
+	db := openTestDatabase(t)
+	store, buffer := newTestStore(t, db)
+
+	now := time.Now()
+	start := now.Add(-30 * time.Hour).Truncate(time.Hour)
+	end := start.Add(12 * time.Hour)
+
+	records := testRecords("1", start, end, time.Minute)
+	writeTestRecords(t, buffer, records[:len(records)/2])
+	rollupTestStatistics(t, db, now)
+
+	// the rest of the statistics is written after the rollups ran past it, the report must still
+	// include them once the rollups ran again.
+	writeTestRecords(t, buffer, records[len(records)/2:])
+	rollupTestStatistics(t, db, now)
+
+	expected, connectionSeconds := statistics.UsageDay{}, 0
+	for _, record := range records {
+		connectionSeconds += record.Statistic.ConnectionSeconds
+		if record.Statistic.PeakConnections > expected.PeakConnections {
+			expected.PeakConnections = record.Statistic.PeakConnections
+		}
+
+		expected.WebsocketMessages += record.Statistic.WebsocketMessages
+		expected.ApiMessages += record.Statistic.ApiMessages
+		expected.BytesReceived += record.Statistic.BytesReceived
+		expected.BytesSent += record.Statistic.BytesSent
+	}
+
+	expected.ConnectionMinutes = float64(connectionSeconds) / 60
+
+	report := statistics.NewUsageReport(store, "1", start, end)
+	if report.Total != expected {
+		t.Fatalf("expected the usage report totals to be %+v, got %+v", expected, report.Total)
+	}
+}
@@ -0,0 +1,446 @@
+package stores
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/iamsayantan/larasockets/config"
+	"github.com/iamsayantan/larasockets/statistics"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// statisticRecord holds the rows of a statistic as they are written to the database, the time of
+// the rows is the time the statistic was collected rather than the time it was written.
+type statisticRecord struct {
+	Statistic LarasocketsStatistic
+	Traffic   []LarasocketsTrafficStatistic
+	// CollectedAt is the time of the rows in the spill file, the rows do not encode their time.
+	CollectedAt time.Time
+}
+
+// restoreTime sets the time of the rows of a record read from the spill file.
+func (r *statisticRecord) restoreTime() {
+	if r.CollectedAt.IsZero() {
+		return
+	}
+
+	r.Statistic.CreatedAt, r.Statistic.UpdatedAt = r.CollectedAt, r.CollectedAt
+	for i := range r.Traffic {
+		r.Traffic[i].CreatedAt = r.CollectedAt
+	}
+}
+
+// writeBuffer writes the statistics to the database in batches from its own goroutine, so a slow
+// database never holds up the collector. Statistics that could not be written are kept in memory
+// and retried on the next flush. With a spill path the statistics that failed are moved to a file
+// instead, which is replayed first once the database is back and survives a restart. Both
+// buffers are bounded, the oldest statistics in memory are dropped when they are full.
+//
+// Every record has a key of its own, so a record that is written twice, for example when the spill
+// file is replayed again after a crash, is only stored once. Records older than the progress of
+// the rollups rewind the progress to their buckets, so they are rolled up on the next run.
+type writeBuffer struct {
+	db          *gorm.DB
+	cfg         config.WriteBufferConfig
+	resolutions []resolution
+
+	mu      sync.Mutex
+	pending []statisticRecord
+	// removed counts the records ever removed from the front of pending, it tells how many
+	// records of a batch were dropped while the batch was being written.
+	removed int
+	spilled int
+	// replayed is the number of records at the start of the spill file that were written already.
+	replayed int
+	health   statistics.StoreHealth
+
+	flushCh   chan struct{}
+	stopCh    chan struct{}
+	stoppedCh chan struct{}
+	stopOnce  sync.Once
+}
+
+func newWriteBuffer(db *gorm.DB, resolutions []resolution, cfg config.WriteBufferConfig) *writeBuffer {
+	buffer := &writeBuffer{
+		db:          db,
+		cfg:         cfg,
+		resolutions: resolutions,
+		pending:     make([]statisticRecord, 0),
+		health:      statistics.StoreHealth{Healthy: true},
+		flushCh:     make(chan struct{}, 1),
+		stopCh:      make(chan struct{}),
+		stoppedCh:   make(chan struct{}),
+	}
+
+	if cfg.SpillPath != "" {
+		buffer.spilled = countSpilled(cfg.SpillPath)
+	}
+
+	go buffer.run()
+
+	return buffer
+}
+
+// add queues the record to be written, it never blocks on the database.
+func (b *writeBuffer) add(record statisticRecord) {
+	b.mu.Lock()
+	if len(b.pending) >= b.cfg.Capacity {
+		b.pending = b.pending[1:]
+		b.removed++
+		b.health.Dropped++
+	}
+
+	b.pending = append(b.pending, record)
+	full := len(b.pending) >= b.cfg.BatchSize
+	b.mu.Unlock()
+
+	if full {
+		select {
+		case b.flushCh <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (b *writeBuffer) healthStatus() statistics.StoreHealth {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	health := b.health
+	health.Pending = len(b.pending)
+	health.Spilled = b.spilled
+
+	return health
+}
+
+// close writes the buffered statistics one last time, what can not be written is spilled to disk
+// when a spill path is set and lost otherwise.
+func (b *writeBuffer) close() {
+	b.stopOnce.Do(func() {
+		close(b.stopCh)
+		<-b.stoppedCh
+	})
+}
+
+func (b *writeBuffer) run() {
+	ticker := time.NewTicker(b.cfg.FlushInterval)
+	defer close(b.stoppedCh)
+
+	for {
+		select {
+		case <-ticker.C:
+			b.flush()
+		case <-b.flushCh:
+			b.flush()
+		case <-b.stopCh:
+			ticker.Stop()
+			b.flush()
+			return
+		}
+	}
+}
+
+// flush replays the spilled statistics and writes the pending ones, it stops at the first batch
+// that fails and reports whether everything was written.
+func (b *writeBuffer) flush() bool {
+	if !b.replay() {
+		b.spill()
+		return false
+	}
+
+	for {
+		b.mu.Lock()
+		size := len(b.pending)
+		if size > b.cfg.BatchSize {
+			size = b.cfg.BatchSize
+		}
+
+		batch := b.pending[:size]
+		removed := b.removed
+		b.mu.Unlock()
+
+		if size == 0 {
+			return true
+		}
+
+		if err := b.write(batch); err != nil {
+			b.spill()
+			return false
+		}
+
+		b.mu.Lock()
+		b.removeFront(size, removed)
+		b.mu.Unlock()
+	}
+}
+
+// removeFront removes the first size records that were at the front of pending when removed was
+// read, minus the ones dropped since. It must be called with mu held.
+func (b *writeBuffer) removeFront(size, removed int) {
+	remaining := size - (b.removed - removed)
+	if remaining <= 0 {
+		return
+	}
+
+	b.pending = b.pending[remaining:]
+	b.removed += remaining
+}
+
+// write stores the batch in a single transaction and records the outcome in the health. Records
+// that were stored already are skipped.
+func (b *writeBuffer) write(batch []statisticRecord) error {
+	err := b.db.Transaction(func(tx *gorm.DB) error {
+		stats := make([]LarasocketsStatistic, 0, len(batch))
+		traffic := make([]LarasocketsTrafficStatistic, 0)
+		var oldest time.Time
+		for _, record := range batch {
+			stats = append(stats, record.Statistic)
+			traffic = append(traffic, record.Traffic...)
+			if oldest.IsZero() || record.Statistic.CreatedAt.Before(oldest) {
+				oldest = record.Statistic.CreatedAt
+			}
+		}
+
+		if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&stats).Error; err != nil {
+			return err
+		}
+
+		if len(traffic) > 0 {
+			if err := tx.Clauses(clause.OnConflict{DoNothing: true}).CreateInBatches(&traffic, 500).Error; err != nil {
+				return err
+			}
+		}
+
+		return b.rewindRollups(tx, oldest)
+	})
+
+	if err != nil {
+		b.recordError(err)
+		return err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.health.Healthy = true
+	b.health.LastWriteAt = time.Now()
+
+	return nil
+}
+
+// spill moves the pending statistics to the spill file, as long as it has room for them.
+func (b *writeBuffer) spill() {
+	if b.cfg.SpillPath == "" {
+		return
+	}
+
+	b.mu.Lock()
+	room := b.cfg.SpillCapacity - b.spilled
+	if room > len(b.pending) {
+		room = len(b.pending)
+	}
+
+	records := b.pending[:room]
+	removed := b.removed
+	b.mu.Unlock()
+
+	if room <= 0 {
+		return
+	}
+
+	file, err := os.OpenFile(b.cfg.SpillPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		b.recordError(err)
+		return
+	}
+
+	writer := bufio.NewWriter(file)
+	encoder := json.NewEncoder(writer)
+	for _, record := range records {
+		record.CollectedAt = record.Statistic.CreatedAt
+		if err = encoder.Encode(record); err != nil {
+			break
+		}
+	}
+
+	if err == nil {
+		err = writer.Flush()
+	}
+
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		b.recordError(err)
+		return
+	}
+
+	b.mu.Lock()
+	b.spilled += room
+	b.removeFront(room, removed)
+	b.mu.Unlock()
+}
+
+// replay writes the spilled statistics in batches and removes the spill file once all of them
+// are stored. A file that can not be replayed completely continues after the last batch written
+// on the next flush, after a restart it is replayed from the start again.
+func (b *writeBuffer) replay() bool {
+	b.mu.Lock()
+	spilled := b.spilled
+	b.mu.Unlock()
+
+	if spilled == 0 {
+		return true
+	}
+
+	file, err := os.Open(b.cfg.SpillPath)
+	if err != nil {
+		b.recordError(err)
+		return false
+	}
+
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+
+	line := 0
+	batch := make([]statisticRecord, 0, b.cfg.BatchSize)
+	for scanner.Scan() {
+		line++
+		if line <= b.replayed {
+			continue
+		}
+
+		var record statisticRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			continue
+		}
+
+		record.restoreTime()
+
+		batch = append(batch, record)
+		if len(batch) < b.cfg.BatchSize {
+			continue
+		}
+
+		if b.write(batch) != nil {
+			return false
+		}
+
+		b.replayed = line
+		batch = batch[:0]
+	}
+
+	if len(batch) > 0 && b.write(batch) != nil {
+		return false
+	}
+
+	if err := os.Remove(b.cfg.SpillPath); err != nil {
+		b.recordError(err)
+		return false
+	}
+
+	b.replayed = 0
+	b.mu.Lock()
+	b.spilled = 0
+	b.mu.Unlock()
+
+	return true
+}
+
+// rewindRollups moves the progress of every rollup back to the bucket of oldest when it was rolled
+// up past it already, so the buckets are rolled up again with the statistics written late. It
+// runs in the transaction of the write and, like the rollups, updates the progress rows first
+// thing, so a rollup running at the same time either sees the statistics or is rewound after it.
+//
+// The statistics a resolution is rolled up from might have been pruned once they are older than
+// their retention, rolling up their buckets again would lose them, so a rollup is never rewound
+// further back than the retention of the resolution before it.
+func (b *writeBuffer) rewindRollups(tx *gorm.DB, oldest time.Time) error {
+	now := time.Now()
+	for i := 1; i < len(b.resolutions); i++ {
+		res := b.resolutions[i]
+		bucket := oldest.Truncate(res.step)
+		if retention := b.resolutions[i-1].retention; retention != 0 {
+			horizon := now.Add(-retention).Truncate(res.step).Add(res.step)
+			if bucket.Before(horizon) {
+				if i == 1 {
+					log.Printf("statistics from %s were stored after the raw retention, they are not rolled up", oldest.Format(time.RFC3339))
+				}
+
+				bucket = horizon
+			}
+		}
+
+		err := tx.Model(&LarasocketsStatisticRollupProgress{}).
+			Where("resolution = ?", res.name).
+			Update("rolled_until", gorm.Expr("CASE WHEN rolled_until > ? THEN ? ELSE rolled_until END", bucket, bucket)).
+			Error
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// reject counts a record that could not be queued as dropped and records the error.
+func (b *writeBuffer) reject(err error) {
+	b.recordError(err)
+
+	b.mu.Lock()
+	b.health.Dropped++
+	b.mu.Unlock()
+}
+
+// recordError marks the storage unhealthy. The error is logged when it differs from the last one,
+// it is not reported by the status endpoint.
+func (b *writeBuffer) recordError(err error) {
+	b.mu.Lock()
+	if b.health.Healthy || b.health.LastError != err.Error() {
+		log.Printf("error storing statistics: %s", err.Error())
+	}
+
+	b.health.Healthy = false
+	b.health.LastError = err.Error()
+	b.health.LastErrorAt = time.Now()
+	b.mu.Unlock()
+}
+
+// newRecordKey returns a random key identifying a record, see LarasocketsStatistic.RecordKey.
+func newRecordKey() (string, error) {
+	key := make([]byte, 16)
+	if _, err := rand.Read(key); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(key), nil
+}
+
+// countSpilled returns the number of statistics in the spill file left by a previous run.
+func countSpilled(path string) int {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0
+	}
+
+	defer file.Close()
+
+	count := 0
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		count++
+	}
+
+	return count
+}
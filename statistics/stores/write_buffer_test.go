@@ -0,0 +1,116 @@
+package stores
+
+import (
+	"github.com/iamsayantan/larasockets/config"
+	"github.com/iamsayantan/larasockets/statistics"
+	"gorm.io/gorm"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func closeTestDatabase(t *testing.T, db *gorm.DB) {
+	t.Helper()
+
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatalf("error getting the database connection: %s", err.Error())
+	}
+
+	if err := sqlDB.Close(); err != nil {
+		t.Fatalf("error closing the database: %s", err.Error())
+	}
+}
+
+// storedTotals returns the number of statistics of the app in the database and the sum of their
+// websocket messages.
+func storedTotals(t *testing.T, db *gorm.DB, appId string) (rows, websocketMessages int) {
+	t.Helper()
+
+	var totals struct {
+		Rows              int
+		WebsocketMessages int
+	}
+
+	err := db.Model(&LarasocketsStatistic{}).
+		Select("COUNT(*) AS rows, COALESCE(SUM(websocket_messages), 0) AS websocket_messages").
+		Where("app_id = ?", appId).
+		Scan(&totals).Error
+
+	if err != nil {
+		t.Fatalf("error reading the statistics: %s", err.Error())
+	}
+
+	return totals.Rows, totals.WebsocketMessages
+}
+
+func TestWriteBufferSpillsWhileTheDatabaseIsClosed(t *testing.T) {
+	dir := t.TempDir()
+	path, spillPath := filepath.Join(dir, "statistics.db"), filepath.Join(dir, "statistics.spill")
+	cfg := config.WriteBufferConfig{BatchSize: 2, FlushInterval: time.Hour, Capacity: 100, SpillPath: spillPath, SpillCapacity: 100}
+
+	db := openTestDatabaseFile(t, path)
+	closeTestDatabase(t, db)
+
+	store := NewDatabaseStorage(db, testRetention, cfg)
+	for i := 1; i <= 5; i++ {
+		store.Store(*statistics.NewStatisticWithData("1", 0, 0, i, 0, 0, 0))
+	}
+	collectedAt := time.Now()
+
+	// the last flush happens when the store is closed, what could not be written is spilled.
+	store.Close()
+
+	if health := store.Health(); health.Healthy || health.Pending != 0 || health.Spilled != 5 {
+		t.Fatalf("expected 5 spilled statistics and an unhealthy store, got %+v", health)
+	}
+
+	spilled, err := ioutil.ReadFile(spillPath)
+	if err != nil {
+		t.Fatalf("error reading the spill file: %s", err.Error())
+	}
+
+	// the spill file is replayed once the database is back, after a restart.
+	db = openTestDatabaseFile(t, path)
+	store = NewDatabaseStorage(db, testRetention, cfg)
+	if health := store.Health(); health.Spilled != 5 {
+		t.Fatalf("expected the 5 spilled statistics to be found after the restart, got %d", health.Spilled)
+	}
+
+	store.Close()
+
+	if rows, websocketMessages := storedTotals(t, db, "1"); rows != 5 || websocketMessages != 15 {
+		t.Fatalf("expected 5 statistics with 15 websocket messages, got %d with %d", rows, websocketMessages)
+	}
+
+	// the statistics keep the time they were collected at rather than the time of the replay.
+	var stored []LarasocketsStatistic
+	db.Where("app_id = ?", "1").Find(&stored)
+	for _, statistic := range stored {
+		if statistic.CreatedAt.After(collectedAt) {
+			t.Fatalf("expected the statistic to be collected before %s, got %s", collectedAt, statistic.CreatedAt)
+		}
+	}
+
+	if _, err := os.Stat(spillPath); !os.IsNotExist(err) {
+		t.Fatalf("expected the spill file to be removed after the replay, got %v", err)
+	}
+
+	// a crash before the spill file was removed replays it again, nothing is stored twice.
+	if err := ioutil.WriteFile(spillPath, spilled, 0644); err != nil {
+		t.Fatalf("error restoring the spill file: %s", err.Error())
+	}
+
+	store = NewDatabaseStorage(db, testRetention, cfg)
+	store.Close()
+
+	if health := store.Health(); !health.Healthy || health.Spilled != 0 {
+		t.Fatalf("expected the spill file to be replayed, got %+v", health)
+	}
+
+	if rows, websocketMessages := storedTotals(t, db, "1"); rows != 5 || websocketMessages != 15 {
+		t.Fatalf("expected the statistics to be stored once, got %d with %d websocket messages", rows, websocketMessages)
+	}
+}
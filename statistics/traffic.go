@@ -0,0 +1,206 @@
+package statistics
+
+import (
+	"runtime"
+	"sort"
+	"sync/atomic"
+	"unsafe"
+)
+
+// MaxTrackedTraffic is the number of channels and event names whose traffic is tracked per app
+// between two dumps to the storage. Apps with more channels keep the heaviest ones.
+const MaxTrackedTraffic = 100
+
+// trafficSlots is the number of names a trafficTable counts the traffic of between two snapshots,
+// the traffic of further names is not tracked until the next snapshot.
+const trafficSlots = 512
+
+// trafficProbes is the number of slots a name is looked up in before it is given up on.
+const trafficProbes = 8
+
+// Kinds of traffic statistics.
+const (
+	TrafficChannel = "channel"
+	TrafficEvent   = "event"
+)
+
+// TrafficStatistic is the traffic of a single channel or event name.
+type TrafficStatistic struct {
+	Name     string
+	Messages int
+	Bytes    int
+	// PeakSubscribers is the highest number of subscribers a message was sent to, it is only
+	// tracked for channels.
+	PeakSubscribers int
+}
+
+// heavyHitters keeps the traffic of at most capacity names using the space saving algorithm. Once
+// it is full, a new name replaces the name with the fewest messages and inherits its count, so a
+// name that is busy enough always makes it into the top, whatever the number of names is.
+type heavyHitters struct {
+	capacity int
+	entries  map[string]*TrafficStatistic
+}
+
+func newHeavyHitters(capacity int) *heavyHitters {
+	return &heavyHitters{capacity: capacity, entries: make(map[string]*TrafficStatistic)}
+}
+
+func (h *heavyHitters) add(name string, bytes, subscribers int) {
+	h.addTraffic(TrafficStatistic{Name: name, Messages: 1, Bytes: bytes, PeakSubscribers: subscribers})
+}
+
+// addTraffic adds the messages and bytes of the traffic to its name at once.
+func (h *heavyHitters) addTraffic(traffic TrafficStatistic) {
+	entry, ok := h.entries[traffic.Name]
+	if !ok {
+		entry = &TrafficStatistic{Name: traffic.Name}
+		if len(h.entries) >= h.capacity {
+			evicted := h.min()
+			delete(h.entries, evicted.Name)
+			entry.Messages = evicted.Messages
+		}
+
+		h.entries[traffic.Name] = entry
+	}
+
+	entry.Messages += traffic.Messages
+	entry.Bytes += traffic.Bytes
+	if traffic.PeakSubscribers > entry.PeakSubscribers {
+		entry.PeakSubscribers = traffic.PeakSubscribers
+	}
+}
+
+func (h *heavyHitters) min() *TrafficStatistic {
+	var min *TrafficStatistic
+	for _, entry := range h.entries {
+		if min == nil || entry.Messages < min.Messages {
+			min = entry
+		}
+	}
+
+	return min
+}
+
+// top returns the tracked traffic, the name with the most messages first.
+func (h *heavyHitters) top() []TrafficStatistic {
+	traffic := make([]TrafficStatistic, 0, len(h.entries))
+	for _, entry := range h.entries {
+		traffic = append(traffic, *entry)
+	}
+
+	SortTraffic(traffic)
+	return traffic
+}
+
+// SortTraffic sorts the traffic by the number of messages, the most messages first.
+func SortTraffic(traffic []TrafficStatistic) {
+	sort.Slice(traffic, func(i, j int) bool {
+		if traffic[i].Messages != traffic[j].Messages {
+			return traffic[i].Messages > traffic[j].Messages
+		}
+
+		return traffic[i].Name < traffic[j].Name
+	})
+}
+
+// trafficTable counts the traffic per channel and per event name without locks, a name claims a
+// slot of its hash with a compare and swap and its counters are updated atomically. A table only
+// lives until the next snapshot of the Counters, which replaces it and folds it into the heavy
+// hitters once the writers still using it are done.
+type trafficTable struct {
+	// writers is the number of goroutines adding to the table, it is accessed atomically.
+	writers  int64
+	channels []trafficSlot
+	events   []trafficSlot
+}
+
+// trafficSlot is the traffic of a name. The int64 fields are accessed atomically, the padding
+// keeps the slots of a slice aligned on 32 bit platforms.
+type trafficSlot struct {
+	messages        int64
+	bytes           int64
+	peakSubscribers int64
+	// name points to the string of the name, it is nil while the slot is free.
+	name unsafe.Pointer
+	_    [8 - unsafe.Sizeof(uintptr(0))]byte
+}
+
+func newTrafficTable() *trafficTable {
+	return &trafficTable{channels: make([]trafficSlot, trafficSlots), events: make([]trafficSlot, trafficSlots)}
+}
+
+func (t *trafficTable) add(channel, event string, bytes, subscribers int) {
+	if slot := findTrafficSlot(t.channels, channel); slot != nil {
+		atomic.AddInt64(&slot.messages, 1)
+		atomic.AddInt64(&slot.bytes, int64(bytes))
+		storeMax(&slot.peakSubscribers, int64(subscribers))
+	}
+
+	if slot := findTrafficSlot(t.events, event); slot != nil {
+		atomic.AddInt64(&slot.messages, 1)
+		atomic.AddInt64(&slot.bytes, int64(bytes))
+	}
+}
+
+// findTrafficSlot returns the slot of the name, claiming a free one if the name has none yet, or
+// nil when the slots the name can be in are all taken by other names.
+func findTrafficSlot(slots []trafficSlot, name string) *trafficSlot {
+	// FNV-1a, inlined so looking up a name does not allocate.
+	hash := uint32(2166136261)
+	for i := 0; i < len(name); i++ {
+		hash ^= uint32(name[i])
+		hash *= 16777619
+	}
+
+	for probe := uint32(0); probe < trafficProbes; probe++ {
+		slot := &slots[(hash+probe)%uint32(len(slots))]
+
+		current := atomic.LoadPointer(&slot.name)
+		if current == nil {
+			claimed := name
+			if atomic.CompareAndSwapPointer(&slot.name, nil, unsafe.Pointer(&claimed)) {
+				return slot
+			}
+
+			current = atomic.LoadPointer(&slot.name)
+		}
+
+		if *(*string)(current) == name {
+			return slot
+		}
+	}
+
+	return nil
+}
+
+// wait waits for the goroutines still adding to the table, it must only be called once the
+// table was replaced.
+func (t *trafficTable) wait() {
+	for atomic.LoadInt64(&t.writers) != 0 {
+		runtime.Gosched()
+	}
+}
+
+// addTo adds the traffic counted in the table to the heavy hitters of the channels and events.
+func (t *trafficTable) addTo(channels, events *heavyHitters) {
+	addTrafficSlots(t.channels, channels)
+	addTrafficSlots(t.events, events)
+}
+
+func addTrafficSlots(slots []trafficSlot, traffic *heavyHitters) {
+	for i := range slots {
+		name := atomic.LoadPointer(&slots[i].name)
+		messages := atomic.LoadInt64(&slots[i].messages)
+		if name == nil || messages == 0 {
+			continue
+		}
+
+		traffic.addTraffic(TrafficStatistic{
+			Name:            *(*string)(name),
+			Messages:        int(messages),
+			Bytes:           int(atomic.LoadInt64(&slots[i].bytes)),
+			PeakSubscribers: int(atomic.LoadInt64(&slots[i].peakSubscribers)),
+		})
+	}
+}
@@ -0,0 +1,149 @@
+package statistics
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+// MaxUsageDays is the longest range a usage report can cover.
+const MaxUsageDays = 366
+
+// UsageDay is the usage of an app within a day, in UTC. The first and the last day of a report
+// only cover the part of the day within the range of the report.
+type UsageDay struct {
+	Day               time.Time
+	ConnectionMinutes float64
+	PeakConnections   int
+	WebsocketMessages int
+	ApiMessages       int
+	BytesReceived     int
+	BytesSent         int
+}
+
+// UsageReport is the usage of an app between From and To, day by day, for billing.
+type UsageReport struct {
+	AppId string
+	From  time.Time
+	To    time.Time
+	Days  []UsageDay
+	Total UsageDay
+}
+
+// ParseUsageRange parses the range of a usage report. Both ends are dates such as 2021-06-30, unix
+// timestamps or RFC 3339 times, a date as the end of the range includes the whole day. The range
+// defaults to the current month in UTC up to now.
+func ParseUsageRange(from, to string, now time.Time) (time.Time, time.Time, error) {
+	end, err := parseUsageTime(to, now, true)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("invalid to: %w", err)
+	}
+
+	year, month, _ := now.UTC().Date()
+	start, err := parseUsageTime(from, time.Date(year, month, 1, 0, 0, 0, 0, time.UTC), false)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("invalid from: %w", err)
+	}
+
+	if !start.Before(end) {
+		return time.Time{}, time.Time{}, errors.New("from must be before to")
+	}
+
+	if end.Sub(start) > MaxUsageDays*24*time.Hour {
+		return time.Time{}, time.Time{}, fmt.Errorf("the range can not be longer than %d days", MaxUsageDays)
+	}
+
+	return start, end, nil
+}
+
+func parseUsageTime(value string, fallback time.Time, endOfDay bool) (time.Time, error) {
+	if value == "" {
+		return fallback, nil
+	}
+
+	if day, err := time.Parse("2006-01-02", value); err == nil {
+		if endOfDay {
+			return day.AddDate(0, 0, 1), nil
+		}
+
+		return day, nil
+	}
+
+	if timestamp, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(timestamp, 0), nil
+	}
+
+	return time.Parse(time.RFC3339, value)
+}
+
+// NewUsageReport reads the usage of the app between from and to from the store.
+func NewUsageReport(store StatsStorage, appId string, from, to time.Time) UsageReport {
+	report := UsageReport{AppId: appId, From: from, To: to, Days: make([]UsageDay, 0)}
+
+	for start := from; start.Before(to); {
+		end := start.UTC().Truncate(24 * time.Hour).Add(24 * time.Hour)
+		if end.After(to) {
+			end = to
+		}
+
+		day := UsageDay{Day: start.UTC().Truncate(24 * time.Hour)}
+		if stat := store.UsageForApp(appId, start, end); stat != nil {
+			day.ConnectionMinutes = float64(stat.ConnectionSeconds()) / 60
+			day.PeakConnections = stat.PeakConnections()
+			day.WebsocketMessages = stat.WebsocketMessages()
+			day.ApiMessages = stat.ApiMessages()
+			day.BytesReceived = stat.BytesReceived()
+			day.BytesSent = stat.BytesSent()
+		}
+
+		report.Days = append(report.Days, day)
+		report.Total.ConnectionMinutes += day.ConnectionMinutes
+		report.Total.PeakConnections = maxInt(report.Total.PeakConnections, day.PeakConnections)
+		report.Total.WebsocketMessages += day.WebsocketMessages
+		report.Total.ApiMessages += day.ApiMessages
+		report.Total.BytesReceived += day.BytesReceived
+		report.Total.BytesSent += day.BytesSent
+
+		start = end
+	}
+
+	return report
+}
+
+// WriteCSV writes the report with a row per day, followed by a row with the totals.
+func (r UsageReport) WriteCSV(w io.Writer) error {
+	writer := csv.NewWriter(w)
+	header := []string{"app_id", "date", "connection_minutes", "peak_connections", "websocket_messages", "api_messages", "bytes_received", "bytes_sent"}
+	if err := writer.Write(header); err != nil {
+		return err
+	}
+
+	for _, day := range r.Days {
+		if err := writer.Write(usageRecord(r.AppId, day.Day.Format("2006-01-02"), day)); err != nil {
+			return err
+		}
+	}
+
+	if err := writer.Write(usageRecord(r.AppId, "total", r.Total)); err != nil {
+		return err
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
+
+func usageRecord(appId, date string, day UsageDay) []string {
+	return []string{
+		appId,
+		date,
+		strconv.FormatFloat(day.ConnectionMinutes, 'f', 2, 64),
+		strconv.Itoa(day.PeakConnections),
+		strconv.Itoa(day.WebsocketMessages),
+		strconv.Itoa(day.ApiMessages),
+		strconv.Itoa(day.BytesReceived),
+		strconv.Itoa(day.BytesSent),
+	}
+}
@@ -0,0 +1,134 @@
+package larasockets
+
+import (
+	"errors"
+	"github.com/iamsayantan/larasockets/config"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	// ErrUserNotFound is returned when no dashboard user exists with the given username.
+	ErrUserNotFound = errors.New("user not found")
+
+	// ErrUserExists is returned when a dashboard user with the same username already exists.
+	ErrUserExists = errors.New("user with the same username already exists")
+)
+
+// Role is what a dashboard user may do with an app. Every role may do everything the roles
+// below it may do.
+type Role string
+
+const (
+	// RoleViewer may look at the statistics, the logs and the connections of the app.
+	RoleViewer Role = config.RoleViewer
+	// RoleOperator may also trigger events, subscribe to any private channel of the app and
+	// disconnect its connections.
+	RoleOperator Role = config.RoleOperator
+	// RoleAdmin may also export the usage of the app.
+	RoleAdmin Role = config.RoleAdmin
+)
+
+// Allows reports whether the role may do what the required role may do.
+func (r Role) Allows(required Role) bool {
+	return r.rank() >= required.rank() && r.rank() > 0
+}
+
+func (r Role) rank() int {
+	switch r {
+	case RoleViewer:
+		return 1
+	case RoleOperator:
+		return 2
+	case RoleAdmin:
+		return 3
+	}
+
+	return 0
+}
+
+// UserManager defines methods to find the dashboard users.
+type UserManager interface {
+	// All returns all the dashboard users.
+	All() []*User
+
+	// FindByUsername returns the user with the given username, or nil.
+	FindByUsername(username string) *User
+}
+
+// UserStore is implemented by the user managers whose users can be changed while the server
+// is running.
+type UserStore interface {
+	UserManager
+
+	// Create adds a new user, ErrUserExists is returned when the username is taken.
+	Create(userConfig config.UserConfig) (*User, error)
+
+	// Update replaces the roles of the user, and its password unless the password hash is
+	// empty. ErrUserNotFound is returned when the user does not exist.
+	Update(userConfig config.UserConfig) (*User, error)
+
+	// Delete removes the user, ErrUserNotFound is returned when the user does not exist.
+	Delete(username string) error
+}
+
+// User is a dashboard user. A user has a role per app, the role for config.AllApps applies to
+// every app.
+type User struct {
+	username     string
+	passwordHash string
+	roles        map[string]Role
+}
+
+func NewUserFromConfig(userConfig config.UserConfig) *User {
+	user := &User{
+		username:     userConfig.Username,
+		passwordHash: userConfig.PasswordHash,
+		roles:        make(map[string]Role, len(userConfig.Roles)),
+	}
+
+	for _, role := range userConfig.Roles {
+		user.roles[role.App] = Role(role.Role)
+	}
+
+	return user
+}
+
+func (u *User) Username() string {
+	return u.username
+}
+
+// CheckPassword reports whether the password matches the bcrypt hash of the user.
+func (u *User) CheckPassword(password string) bool {
+	return bcrypt.CompareHashAndPassword([]byte(u.passwordHash), []byte(password)) == nil
+}
+
+// RoleFor returns the role of the user for the app, the highest of the role for the app and the
+// role for all apps. It is empty when the user has no access to the app.
+func (u *User) RoleFor(appId string) Role {
+	role, all := u.roles[appId], u.roles[config.AllApps]
+	if all.rank() > role.rank() {
+		return all
+	}
+
+	return role
+}
+
+// Roles returns the roles of the user by app id.
+func (u *User) Roles() map[string]Role {
+	roles := make(map[string]Role, len(u.roles))
+	for appId, role := range u.roles {
+		roles[appId] = role
+	}
+
+	return roles
+}
+
+// HashPassword returns the bcrypt hash of the password, as it is stored for a user.
+func HashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+
+	return string(hash), nil
+}
@@ -0,0 +1,33 @@
+package user_managers
+
+import (
+	"github.com/iamsayantan/larasockets"
+	"github.com/iamsayantan/larasockets/config"
+)
+
+type configUserManager struct {
+	users map[string]*larasockets.User
+}
+
+// NewConfigManager returns a UserManager of the users listed in the configuration file.
+func NewConfigManager(usersConfig []config.UserConfig) larasockets.UserManager {
+	manager := &configUserManager{users: make(map[string]*larasockets.User, len(usersConfig))}
+	for _, userConfig := range usersConfig {
+		manager.users[userConfig.Username] = larasockets.NewUserFromConfig(userConfig)
+	}
+
+	return manager
+}
+
+func (c *configUserManager) All() []*larasockets.User {
+	users := make([]*larasockets.User, 0, len(c.users))
+	for _, user := range c.users {
+		users = append(users, user)
+	}
+
+	return users
+}
+
+func (c *configUserManager) FindByUsername(username string) *larasockets.User {
+	return c.users[username]
+}
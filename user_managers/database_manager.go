@@ -0,0 +1,207 @@
+package user_managers
+
+import (
+	"encoding/json"
+	"github.com/iamsayantan/larasockets"
+	"github.com/iamsayantan/larasockets/config"
+	"gorm.io/gorm"
+	"sync"
+	"time"
+)
+
+// LarasocketsUser is the database row of a dashboard user.
+type LarasocketsUser struct {
+	Username     string `gorm:"primarykey;size:191"`
+	PasswordHash string
+	// Roles holds the json encoded roles of the user.
+	Roles     string `gorm:"type:text"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+func newLarasocketsUser(userConfig config.UserConfig) (LarasocketsUser, error) {
+	roles, err := json.Marshal(userConfig.Roles)
+	if err != nil {
+		return LarasocketsUser{}, err
+	}
+
+	return LarasocketsUser{
+		Username:     userConfig.Username,
+		PasswordHash: userConfig.PasswordHash,
+		Roles:        string(roles),
+	}, nil
+}
+
+func (u LarasocketsUser) userConfig() (config.UserConfig, error) {
+	userConfig := config.UserConfig{
+		Username:     u.Username,
+		PasswordHash: u.PasswordHash,
+	}
+
+	if u.Roles != "" {
+		if err := json.Unmarshal([]byte(u.Roles), &userConfig.Roles); err != nil {
+			return userConfig, err
+		}
+	}
+
+	return userConfig, nil
+}
+
+type databaseUserManager struct {
+	db *gorm.DB
+
+	// mu guards the cached users, the database is only read on start up and written to when a
+	// user changes.
+	mu      sync.RWMutex
+	users   map[string]*larasockets.User
+	configs map[string]config.UserConfig
+}
+
+// NewDatabaseManager returns a UserStore whose users are stored in the database. The users given
+// in seed are created if no user with the same username exists yet.
+func NewDatabaseManager(db *gorm.DB, seed []config.UserConfig) (larasockets.UserStore, error) {
+	manager := &databaseUserManager{
+		db:      db,
+		users:   make(map[string]*larasockets.User, 0),
+		configs: make(map[string]config.UserConfig, 0),
+	}
+
+	if err := manager.load(); err != nil {
+		return nil, err
+	}
+
+	for _, userConfig := range seed {
+		if _, ok := manager.users[userConfig.Username]; ok {
+			continue
+		}
+
+		if _, err := manager.Create(userConfig); err != nil {
+			return nil, err
+		}
+	}
+
+	return manager, nil
+}
+
+func (m *databaseUserManager) All() []*larasockets.User {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	users := make([]*larasockets.User, 0, len(m.users))
+	for _, user := range m.users {
+		users = append(users, user)
+	}
+
+	return users
+}
+
+func (m *databaseUserManager) FindByUsername(username string) *larasockets.User {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.users[username]
+}
+
+func (m *databaseUserManager) Create(userConfig config.UserConfig) (*larasockets.User, error) {
+	if err := userConfig.Validate(); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.users[userConfig.Username]; ok {
+		return nil, larasockets.ErrUserExists
+	}
+
+	row, err := newLarasocketsUser(userConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := m.db.Create(&row).Error; err != nil {
+		return nil, err
+	}
+
+	return m.cache(userConfig), nil
+}
+
+func (m *databaseUserManager) Update(userConfig config.UserConfig) (*larasockets.User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	existing, ok := m.configs[userConfig.Username]
+	if !ok {
+		return nil, larasockets.ErrUserNotFound
+	}
+
+	if userConfig.PasswordHash == "" {
+		userConfig.PasswordHash = existing.PasswordHash
+	}
+
+	if err := userConfig.Validate(); err != nil {
+		return nil, err
+	}
+
+	row, err := newLarasocketsUser(userConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	err = m.db.Model(&LarasocketsUser{Username: userConfig.Username}).
+		Select("PasswordHash", "Roles").
+		Updates(row).
+		Error
+
+	if err != nil {
+		return nil, err
+	}
+
+	return m.cache(userConfig), nil
+}
+
+func (m *databaseUserManager) Delete(username string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.users[username]; !ok {
+		return larasockets.ErrUserNotFound
+	}
+
+	if err := m.db.Delete(&LarasocketsUser{Username: username}).Error; err != nil {
+		return err
+	}
+
+	delete(m.users, username)
+	delete(m.configs, username)
+
+	return nil
+}
+
+// cache stores the user in the cache, it must be called with mu held.
+func (m *databaseUserManager) cache(userConfig config.UserConfig) *larasockets.User {
+	user := larasockets.NewUserFromConfig(userConfig)
+	m.users[user.Username()] = user
+	m.configs[user.Username()] = userConfig
+
+	return user
+}
+
+// load fills the cache with all the users stored in the database.
+func (m *databaseUserManager) load() error {
+	var rows []LarasocketsUser
+	if err := m.db.Find(&rows).Error; err != nil {
+		return err
+	}
+
+	for _, row := range rows {
+		userConfig, err := row.userConfig()
+		if err != nil {
+			return err
+		}
+
+		m.cache(userConfig)
+	}
+
+	return nil
+}
@@ -0,0 +1,76 @@
+package larasockets
+
+import (
+	"github.com/iamsayantan/larasockets/config"
+	"testing"
+)
+
+func TestRoleAllows(t *testing.T) {
+	tests := []struct {
+		role     Role
+		required Role
+		allowed  bool
+	}{
+		{role: RoleViewer, required: RoleViewer, allowed: true},
+		{role: RoleViewer, required: RoleOperator, allowed: false},
+		{role: RoleViewer, required: RoleAdmin, allowed: false},
+		{role: RoleOperator, required: RoleViewer, allowed: true},
+		{role: RoleOperator, required: RoleOperator, allowed: true},
+		{role: RoleOperator, required: RoleAdmin, allowed: false},
+		{role: RoleAdmin, required: RoleViewer, allowed: true},
+		{role: RoleAdmin, required: RoleOperator, allowed: true},
+		{role: RoleAdmin, required: RoleAdmin, allowed: true},
+		{role: "", required: RoleViewer, allowed: false},
+		{role: "", required: "", allowed: false},
+		{role: "owner", required: "", allowed: false},
+		{role: RoleViewer, required: "", allowed: true},
+	}
+
+	for _, tt := range tests {
+		if allowed := tt.role.Allows(tt.required); allowed != tt.allowed {
+			t.Fatalf("expected %q allowing %q to be %t, got %t", tt.role, tt.required, tt.allowed, allowed)
+		}
+	}
+}
+
+func TestUserRoleFor(t *testing.T) {
+	newUser := func(roles ...config.UserRoleConfig) *User {
+		return NewUserFromConfig(config.UserConfig{Username: "user", Roles: roles})
+	}
+
+	tests := []struct {
+		name     string
+		user     *User
+		appId    string
+		expected Role
+	}{
+		{name: "no roles", user: newUser(), appId: "1", expected: ""},
+		{name: "role for the app", user: newUser(config.UserRoleConfig{App: "1", Role: config.RoleOperator}), appId: "1", expected: RoleOperator},
+		{name: "role for another app", user: newUser(config.UserRoleConfig{App: "2", Role: config.RoleAdmin}), appId: "1", expected: ""},
+		{name: "role for all apps", user: newUser(config.UserRoleConfig{App: config.AllApps, Role: config.RoleViewer}), appId: "1", expected: RoleViewer},
+		{
+			name:     "app role above all apps",
+			user:     newUser(config.UserRoleConfig{App: config.AllApps, Role: config.RoleViewer}, config.UserRoleConfig{App: "1", Role: config.RoleAdmin}),
+			appId:    "1",
+			expected: RoleAdmin,
+		},
+		{
+			name:     "all apps above app role",
+			user:     newUser(config.UserRoleConfig{App: config.AllApps, Role: config.RoleOperator}, config.UserRoleConfig{App: "1", Role: config.RoleViewer}),
+			appId:    "1",
+			expected: RoleOperator,
+		},
+		{
+			name:     "all apps for another app",
+			user:     newUser(config.UserRoleConfig{App: config.AllApps, Role: config.RoleOperator}, config.UserRoleConfig{App: "1", Role: config.RoleAdmin}),
+			appId:    "2",
+			expected: RoleOperator,
+		},
+	}
+
+	for _, tt := range tests {
+		if role := tt.user.RoleFor(tt.appId); role != tt.expected {
+			t.Fatalf("%s: expected role %q for app %s, got %q", tt.name, tt.expected, tt.appId, role)
+		}
+	}
+}